@@ -28,9 +28,88 @@ var RateLimitCookieLockDuration = env.Int("RATE_LIMIT_COOKIE_LOCK_DURATION", 10*
 // 隐藏思考过程
 var ReasoningHide = env.Int("REASONING_HIDE", 0)
 
+// 思考内容输出格式
+const (
+	// ReasoningFormatContent 以reasoning_content字段输出
+	ReasoningFormatContent = "reasoning_content"
+	// ReasoningFormatThink 以<think>标签内联在content中输出
+	ReasoningFormatThink = "think"
+	// ReasoningFormatHidden 不输出思考内容
+	ReasoningFormatHidden = "hidden"
+)
+
+// 默认思考内容输出格式[reasoning_content、think、hidden]
+var ReasoningFormat = env.String("REASONING_FORMAT", ReasoningFormatContent)
+
+// 按API-KEY指定思考内容输出格式,格式为key:format(多个以,分隔)
+var KeyReasoningFormats = parseKeyReasoningFormats(os.Getenv("KEY_REASONING_FORMAT"))
+
+func parseKeyReasoningFormats(value string) map[string]string {
+	formats := make(map[string]string)
+	for _, item := range strings.Split(value, ",") {
+		index := strings.LastIndex(item, ":")
+		if index <= 0 {
+			continue
+		}
+		key := strings.TrimSpace(item[:index])
+		format := strings.TrimSpace(item[index+1:])
+		if IsValidReasoningFormat(format) {
+			formats[key] = format
+		}
+	}
+	return formats
+}
+
+// IsValidReasoningFormat 校验思考内容输出格式,空值表示使用默认格式
+func IsValidReasoningFormat(format string) bool {
+	switch format {
+	case "", ReasoningFormatContent, ReasoningFormatThink, ReasoningFormatHidden:
+		return true
+	}
+	return false
+}
+
+// GetReasoningFormat 获取API-KEY对应的思考内容输出格式
+func GetReasoningFormat(apiKey string) string {
+	if format, ok := KeyReasoningFormats[apiKey]; ok {
+		return format
+	}
+	if ReasoningHide == 1 {
+		return ReasoningFormatHidden
+	}
+	if !IsValidReasoningFormat(ReasoningFormat) || ReasoningFormat == "" {
+		return ReasoningFormatContent
+	}
+	return ReasoningFormat
+}
+
+// 请求包含上游不支持的采样参数时是否拒绝请求,默认忽略该参数并告警
+var RejectUnsupportedParams = env.Bool("REJECT_UNSUPPORTED_PARAMS", false)
+
+// 输入超出模型上下文窗口时的处理策略
+const (
+	// ContextTruncationNone 不检查,直接转发上游
+	ContextTruncationNone = "none"
+	// ContextTruncationReject 拒绝请求并返回context_length_exceeded
+	ContextTruncationReject = "reject"
+	// ContextTruncationDropOldest 保留系统消息,从最早的对话轮次开始丢弃
+	ContextTruncationDropOldest = "drop_oldest"
+	// ContextTruncationMiddleOut 保留首尾,从中间的对话轮次开始丢弃
+	ContextTruncationMiddleOut = "middle_out"
+)
+
+// 上下文截断策略[none、reject、drop_oldest、middle_out]
+var ContextTruncation = env.String("CONTEXT_TRUNCATION", ContextTruncationReject)
+
 // 前置message
 var PRE_MESSAGES_JSON = env.String("PRE_MESSAGES_JSON", "")
 
+// 模型配置文件(YAML/JSON),为空时使用内置模型
+var ModelConfigPath = env.String("MODEL_CONFIG_PATH", "")
+
+// 模型配置文件检查间隔(秒),文件变更后自动重新加载,0表示不检查
+var ModelConfigReloadInterval = env.Int("MODEL_CONFIG_RELOAD_INTERVAL", 30)
+
 // 路由前缀
 var RoutePrefix = env.String("ROUTE_PREFIX", "")
 var SwaggerEnable = os.Getenv("SWAGGER_ENABLE")
@@ -2,4 +2,5 @@ package helper
 
 const (
 	RequestIdKey = "X-Request-Id"
+	ApiKeyKey    = "ApiKey"
 )
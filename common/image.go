@@ -0,0 +1,59 @@
+package common
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"image"
+	_ "image/gif"
+	_ "image/jpeg"
+	_ "image/png"
+	"strings"
+)
+
+// GetImageSize 从data URL中解析图片宽高,格式支持jpeg/png/gif/webp。
+// 不下载远程图片:图片地址由客户端提供,服务端请求会访问内网地址(SSRF)并阻塞请求
+func GetImageSize(url string) (width int, height int, err error) {
+	if !strings.HasPrefix(url, "data:") {
+		return 0, 0, errors.New("only data urls are supported")
+	}
+	_, encoded, ok := strings.Cut(url, ",")
+	if !ok {
+		return 0, 0, errors.New("invalid data url")
+	}
+	data, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	if width, height, ok := webpSize(data); ok {
+		return width, height, nil
+	}
+	config, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return 0, 0, err
+	}
+	return config.Width, config.Height, nil
+}
+
+// webpSize 解析webp文件头中的尺寸,标准库不支持webp
+func webpSize(data []byte) (int, int, bool) {
+	if len(data) < 30 || string(data[0:4]) != "RIFF" || string(data[8:12]) != "WEBP" {
+		return 0, 0, false
+	}
+	switch string(data[12:16]) {
+	case "VP8 ":
+		width := int(binary.LittleEndian.Uint16(data[26:28]) & 0x3fff)
+		height := int(binary.LittleEndian.Uint16(data[28:30]) & 0x3fff)
+		return width, height, true
+	case "VP8L":
+		bits := binary.LittleEndian.Uint32(data[21:25])
+		return int(bits&0x3fff) + 1, int((bits>>14)&0x3fff) + 1, true
+	case "VP8X":
+		width := int(data[24]) | int(data[25])<<8 | int(data[26])<<16
+		height := int(data[27]) | int(data[28])<<8 | int(data[29])<<16
+		return width + 1, height + 1, true
+	}
+	return 0, 0, false
+}
@@ -25,7 +25,8 @@ func printHelp() {
 	fmt.Println("Usage: kilo2api [--port <port>] [--log-dir <log directory>] [--version] [--help]")
 }
 
-func init() {
+// Init 解析命令行参数,由main在启动时调用
+func Init() {
 	flag.Parse()
 
 	if *PrintVersion {
@@ -0,0 +1,343 @@
+package common
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"reflect"
+	"slices"
+	"strings"
+	"unicode/utf8"
+)
+
+// ValidateJSONSchema 按JSON Schema校验已解析的JSON值,支持结构化输出常用的关键字:
+// type、enum、const、properties、required、additionalProperties、items、
+// min/maxItems、min/maxLength、minimum/maximum、anyOf/oneOf/allOf以及本文档内的$ref
+func ValidateJSONSchema(schema interface{}, value interface{}) error {
+	v := schemaValidator{root: schema}
+	return v.validate(schema, value, "$", nil)
+}
+
+// CheckJSONSchema 检查schema中的$ref均可解析,且不存在未进入下一层值就回到自身的循环引用(如{"$ref":"#"}),
+// 这类schema无法校验任何值。经properties/items进入子值的递归引用(如树形结构)是允许的
+func CheckJSONSchema(schema interface{}) error {
+	v := schemaValidator{root: schema}
+	return v.checkSchema(schema)
+}
+
+type schemaValidator struct {
+	root interface{}
+}
+
+// validate 校验value,refs为校验当前值时已经过的$ref,用于发现循环引用;进入子属性/元素时清空
+func (v schemaValidator) validate(rawSchema interface{}, value interface{}, path string, refs []string) error {
+	// true/false也是合法的schema
+	if b, ok := rawSchema.(bool); ok {
+		if !b {
+			return fmt.Errorf("%s: value is not allowed", path)
+		}
+		return nil
+	}
+	schema, ok := rawSchema.(map[string]interface{})
+	if !ok {
+		return nil
+	}
+
+	if ref, ok := schema["$ref"].(string); ok {
+		if slices.Contains(refs, ref) {
+			return fmt.Errorf("%s: circular $ref %s", path, ref)
+		}
+		resolved, err := v.resolveRef(ref)
+		if err != nil {
+			return fmt.Errorf("%s: %v", path, err)
+		}
+		return v.validate(resolved, value, path, append(refs[:len(refs):len(refs)], ref))
+	}
+
+	if types := schemaTypes(schema["type"]); len(types) > 0 {
+		matched := false
+		for _, t := range types {
+			if jsonTypeMatches(t, value) {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return fmt.Errorf("%s: expected %s, got %s", path, strings.Join(types, " or "), jsonTypeName(value))
+		}
+	}
+
+	if enum, ok := schema["enum"].([]interface{}); ok {
+		found := false
+		for _, candidate := range enum {
+			if jsonEqual(candidate, value) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return fmt.Errorf("%s: value is not one of the allowed enum values", path)
+		}
+	}
+	if constValue, ok := schema["const"]; ok && !jsonEqual(constValue, value) {
+		return fmt.Errorf("%s: value does not match const", path)
+	}
+
+	switch val := value.(type) {
+	case map[string]interface{}:
+		if err := v.validateObject(schema, val, path); err != nil {
+			return err
+		}
+	case []interface{}:
+		if err := v.validateArray(schema, val, path); err != nil {
+			return err
+		}
+	case string:
+		length := utf8.RuneCountInString(val)
+		if minLength, ok := schema["minLength"].(float64); ok && float64(length) < minLength {
+			return fmt.Errorf("%s: string is shorter than %v", path, minLength)
+		}
+		if maxLength, ok := schema["maxLength"].(float64); ok && float64(length) > maxLength {
+			return fmt.Errorf("%s: string is longer than %v", path, maxLength)
+		}
+	case float64:
+		if minimum, ok := schema["minimum"].(float64); ok && val < minimum {
+			return fmt.Errorf("%s: %v is less than minimum %v", path, val, minimum)
+		}
+		if maximum, ok := schema["maximum"].(float64); ok && val > maximum {
+			return fmt.Errorf("%s: %v is greater than maximum %v", path, val, maximum)
+		}
+	}
+
+	if allOf, ok := schema["allOf"].([]interface{}); ok {
+		for _, sub := range allOf {
+			if err := v.validate(sub, value, path, refs); err != nil {
+				return err
+			}
+		}
+	}
+	if anyOf, ok := schema["anyOf"].([]interface{}); ok {
+		if v.countMatches(anyOf, value, path, refs) == 0 {
+			return fmt.Errorf("%s: value does not match any schema in anyOf", path)
+		}
+	}
+	if oneOf, ok := schema["oneOf"].([]interface{}); ok {
+		if v.countMatches(oneOf, value, path, refs) != 1 {
+			return fmt.Errorf("%s: value must match exactly one schema in oneOf", path)
+		}
+	}
+	return nil
+}
+
+func (v schemaValidator) validateObject(schema map[string]interface{}, value map[string]interface{}, path string) error {
+	if required, ok := schema["required"].([]interface{}); ok {
+		for _, rawName := range required {
+			name, _ := rawName.(string)
+			if _, exists := value[name]; !exists {
+				return fmt.Errorf("%s: missing required property %q", path, name)
+			}
+		}
+	}
+
+	properties, _ := schema["properties"].(map[string]interface{})
+	for name, propertyValue := range value {
+		propertyPath := path + "." + name
+		if propertySchema, ok := properties[name]; ok {
+			if err := v.validate(propertySchema, propertyValue, propertyPath, nil); err != nil {
+				return err
+			}
+			continue
+		}
+		switch additional := schema["additionalProperties"].(type) {
+		case bool:
+			if !additional {
+				return fmt.Errorf("%s: additional property %q is not allowed", path, name)
+			}
+		case map[string]interface{}:
+			if err := v.validate(additional, propertyValue, propertyPath, nil); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (v schemaValidator) validateArray(schema map[string]interface{}, value []interface{}, path string) error {
+	if minItems, ok := schema["minItems"].(float64); ok && float64(len(value)) < minItems {
+		return fmt.Errorf("%s: array has fewer than %v items", path, minItems)
+	}
+	if maxItems, ok := schema["maxItems"].(float64); ok && float64(len(value)) > maxItems {
+		return fmt.Errorf("%s: array has more than %v items", path, maxItems)
+	}
+	if items, ok := schema["items"]; ok {
+		for i, item := range value {
+			if err := v.validate(items, item, fmt.Sprintf("%s[%d]", path, i), nil); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (v schemaValidator) countMatches(schemas []interface{}, value interface{}, path string, refs []string) int {
+	count := 0
+	for _, sub := range schemas {
+		if v.validate(sub, value, path, refs) == nil {
+			count++
+		}
+	}
+	return count
+}
+
+// checkSchema 遍历schema及其所有子schema,检查每处的$ref
+func (v schemaValidator) checkSchema(rawSchema interface{}) error {
+	schema, ok := rawSchema.(map[string]interface{})
+	if !ok {
+		return nil
+	}
+	if err := v.checkRefCycle(schema, nil); err != nil {
+		return err
+	}
+	for _, keyword := range []string{"properties", "$defs", "definitions"} {
+		subs, _ := schema[keyword].(map[string]interface{})
+		for _, sub := range subs {
+			if err := v.checkSchema(sub); err != nil {
+				return err
+			}
+		}
+	}
+	for _, keyword := range []string{"allOf", "anyOf", "oneOf"} {
+		subs, _ := schema[keyword].([]interface{})
+		for _, sub := range subs {
+			if err := v.checkSchema(sub); err != nil {
+				return err
+			}
+		}
+	}
+	for _, keyword := range []string{"items", "additionalProperties"} {
+		if err := v.checkSchema(schema[keyword]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// checkRefCycle 沿作用于同一个值的$ref与allOf/anyOf/oneOf查找循环引用
+func (v schemaValidator) checkRefCycle(rawSchema interface{}, refs []string) error {
+	schema, ok := rawSchema.(map[string]interface{})
+	if !ok {
+		return nil
+	}
+	if ref, ok := schema["$ref"].(string); ok {
+		if slices.Contains(refs, ref) {
+			return fmt.Errorf("circular $ref %s", ref)
+		}
+		resolved, err := v.resolveRef(ref)
+		if err != nil {
+			return err
+		}
+		return v.checkRefCycle(resolved, append(refs[:len(refs):len(refs)], ref))
+	}
+	for _, keyword := range []string{"allOf", "anyOf", "oneOf"} {
+		subs, _ := schema[keyword].([]interface{})
+		for _, sub := range subs {
+			if err := v.checkRefCycle(sub, refs); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// resolveRef 解析文档内引用,如#/$defs/Item或#/definitions/Item
+func (v schemaValidator) resolveRef(ref string) (interface{}, error) {
+	if ref == "#" {
+		return v.root, nil
+	}
+	pointer, ok := strings.CutPrefix(ref, "#/")
+	if !ok {
+		return nil, fmt.Errorf("unsupported $ref %s", ref)
+	}
+	current := v.root
+	for _, token := range strings.Split(pointer, "/") {
+		token = strings.ReplaceAll(strings.ReplaceAll(token, "~1", "/"), "~0", "~")
+		object, ok := current.(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("unresolvable $ref %s", ref)
+		}
+		if current, ok = object[token]; !ok {
+			return nil, fmt.Errorf("unresolvable $ref %s", ref)
+		}
+	}
+	return current, nil
+}
+
+func schemaTypes(rawType interface{}) []string {
+	switch t := rawType.(type) {
+	case string:
+		return []string{t}
+	case []interface{}:
+		var types []string
+		for _, item := range t {
+			if s, ok := item.(string); ok {
+				types = append(types, s)
+			}
+		}
+		return types
+	}
+	return nil
+}
+
+func jsonTypeMatches(schemaType string, value interface{}) bool {
+	switch schemaType {
+	case "object":
+		_, ok := value.(map[string]interface{})
+		return ok
+	case "array":
+		_, ok := value.([]interface{})
+		return ok
+	case "string":
+		_, ok := value.(string)
+		return ok
+	case "number":
+		_, ok := value.(float64)
+		return ok
+	case "integer":
+		number, ok := value.(float64)
+		return ok && number == math.Trunc(number)
+	case "boolean":
+		_, ok := value.(bool)
+		return ok
+	case "null":
+		return value == nil
+	}
+	return false
+}
+
+func jsonTypeName(value interface{}) string {
+	switch value.(type) {
+	case map[string]interface{}:
+		return "object"
+	case []interface{}:
+		return "array"
+	case string:
+		return "string"
+	case float64:
+		return "number"
+	case bool:
+		return "boolean"
+	case nil:
+		return "null"
+	}
+	return fmt.Sprintf("%T", value)
+}
+
+// jsonEqual 比较两个JSON值,统一序列化后比较以忽略数值类型差异
+func jsonEqual(a, b interface{}) bool {
+	if reflect.DeepEqual(a, b) {
+		return true
+	}
+	aBytes, errA := json.Marshal(a)
+	bBytes, errB := json.Marshal(b)
+	return errA == nil && errB == nil && string(aBytes) == string(bBytes)
+}
@@ -0,0 +1,73 @@
+package common
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func mustJSON(t *testing.T, s string) interface{} {
+	t.Helper()
+	var v interface{}
+	if err := json.Unmarshal([]byte(s), &v); err != nil {
+		t.Fatalf("invalid json %s: %v", s, err)
+	}
+	return v
+}
+
+func TestValidateJSONSchemaCircularRef(t *testing.T) {
+	tests := []struct {
+		name   string
+		schema string
+		value  string
+	}{
+		{"self reference", `{"$ref":"#"}`, `{}`},
+		{"mutual $defs", `{"$ref":"#/$defs/a","$defs":{"a":{"$ref":"#/$defs/b"},"b":{"$ref":"#/$defs/a"}}}`, `1`},
+		{"through anyOf", `{"anyOf":[{"$ref":"#"}]}`, `"x"`},
+		{"through allOf in property", `{"properties":{"a":{"$ref":"#/$defs/a"}},"$defs":{"a":{"allOf":[{"$ref":"#/$defs/a"}]}}}`, `{"a":1}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// 循环引用须返回错误而不是无限递归
+			if err := ValidateJSONSchema(mustJSON(t, tt.schema), mustJSON(t, tt.value)); err == nil {
+				t.Fatal("expected an error for circular schema")
+			}
+			err := CheckJSONSchema(mustJSON(t, tt.schema))
+			if err == nil || !strings.Contains(err.Error(), "circular $ref") {
+				t.Fatalf("expected circular $ref error, got %v", err)
+			}
+		})
+	}
+}
+
+func TestValidateJSONSchemaRecursiveTree(t *testing.T) {
+	schema := mustJSON(t, `{
+		"$ref": "#/$defs/node",
+		"$defs": {"node": {
+			"type": "object",
+			"required": ["name"],
+			"properties": {
+				"name": {"type": "string"},
+				"children": {"type": "array", "items": {"$ref": "#/$defs/node"}}
+			},
+			"additionalProperties": false
+		}}
+	}`)
+	if err := CheckJSONSchema(schema); err != nil {
+		t.Fatalf("CheckJSONSchema rejected a recursive tree schema: %v", err)
+	}
+	valid := mustJSON(t, `{"name":"root","children":[{"name":"a","children":[{"name":"b"}]}]}`)
+	if err := ValidateJSONSchema(schema, valid); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	invalid := mustJSON(t, `{"name":"root","children":[{"children":[]}]}`)
+	if err := ValidateJSONSchema(schema, invalid); err == nil {
+		t.Fatal("expected missing name error")
+	}
+}
+
+func TestCheckJSONSchemaUnresolvableRef(t *testing.T) {
+	if err := CheckJSONSchema(mustJSON(t, `{"properties":{"a":{"$ref":"#/$defs/missing"}}}`)); err == nil {
+		t.Fatal("expected unresolvable $ref error")
+	}
+}
@@ -0,0 +1,318 @@
+package common
+
+import (
+	"fmt"
+	"kilo2api/common/config"
+	logger "kilo2api/common/loggger"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+type ModelInfo struct {
+	// ID 对外暴露的模型名称
+	ID string
+	// Model 上游模型名称
+	Model  string
+	Source string
+	// MaxTokens 最大输出token数
+	MaxTokens int
+	// ContextWindow 上下文窗口大小,0表示未知
+	ContextWindow int
+	// ContextTruncation 输入超出上下文窗口时的处理策略,为空时使用CONTEXT_TRUNCATION
+	ContextTruncation string
+	// Temperature 请求未指定温度时使用的默认值
+	Temperature *float64
+	// Thinking 是否默认开启思考
+	Thinking bool
+	// ThinkingBudget 默认思考预算,0表示按medium比例计算
+	ThinkingBudget int
+	// ReasoningEffortRatios reasoning_effort对应的思考预算占max_tokens的比例
+	ReasoningEffortRatios map[string]float64
+	// ThinkingMinBudget 思考预算下限
+	ThinkingMinBudget int
+	Vision            bool
+	Tools             bool
+	OwnedBy           string
+	Created           int64
+}
+
+// modelConfig 模型配置文件中的单个模型,id中包含*时作为通配规则透传匹配的模型名称
+type modelConfig struct {
+	ID                    string             `yaml:"id"`
+	Model                 string             `yaml:"model"`
+	Source                string             `yaml:"source"`
+	MaxTokens             int                `yaml:"max_tokens"`
+	ContextWindow         int                `yaml:"context_window"`
+	ContextTruncation     string             `yaml:"context_truncation"`
+	Temperature           *float64           `yaml:"temperature"`
+	Thinking              *bool              `yaml:"thinking"`
+	ThinkingBudget        int                `yaml:"thinking_budget"`
+	ReasoningEffortRatios map[string]float64 `yaml:"reasoning_effort_ratios"`
+	ThinkingMinBudget     int                `yaml:"thinking_min_budget"`
+	Vision                *bool              `yaml:"vision"`
+	Tools                 *bool              `yaml:"tools"`
+	OwnedBy               string             `yaml:"owned_by"`
+	Created               int64              `yaml:"created"`
+	Aliases               []string           `yaml:"aliases"`
+}
+
+// modelConfigFile 模型配置文件,JSON是YAML的子集,两种格式均可解析
+type modelConfigFile struct {
+	Models []modelConfig `yaml:"models"`
+}
+
+// modelRegistry 已加载的模型表
+type modelRegistry struct {
+	models   map[string]ModelInfo
+	names    []string
+	patterns []ModelInfo
+}
+
+// defaultModels 未配置模型文件时使用的内置模型
+var defaultModels = []modelConfig{
+	{ID: "claude-3-7-sonnet-20250219", Model: "claude-3-7-sonnet-20250219", Source: "claude", MaxTokens: 128000, ContextWindow: 200000, OwnedBy: "anthropic"},
+	{ID: "claude-3-7-sonnet-20250219-thinking", Model: "claude-3-7-sonnet-20250219", Source: "claude", MaxTokens: 128000, ContextWindow: 200000, OwnedBy: "anthropic"},
+	{ID: "gemini-2.5-pro-preview-03-25", Model: "google/gemini-2.5-pro-preview-03-25", Source: "openrouter", MaxTokens: 65536, ContextWindow: 1048576},
+	{ID: "gemini-2.5-flash-preview", Model: "google/gemini-2.5-flash-preview", Source: "openrouter", MaxTokens: 65536, ContextWindow: 1048576},
+	{ID: "gpt-4.1", Model: "openai/gpt-4.1", Source: "openrouter", MaxTokens: 65536, ContextWindow: 1047576},
+}
+
+// defaultReasoningEffortRatios reasoning_effort对应的默认思考预算比例
+var defaultReasoningEffortRatios = map[string]float64{
+	"low":    0.2,
+	"medium": 0.5,
+	"high":   0.8,
+}
+
+// defaultThinkingMinBudget 默认思考预算下限,与Anthropic要求的最小预算一致
+const defaultThinkingMinBudget = 1024
+
+var (
+	registryMu    sync.RWMutex
+	registry      = mustBuildModelRegistry(defaultModels)
+	configModTime time.Time
+)
+
+// InitModelRegistry 加载模型配置文件并在文件变更时自动重新加载
+func InitModelRegistry() {
+	if config.ModelConfigPath == "" {
+		return
+	}
+	if err := ReloadModelRegistry(); err != nil {
+		logger.FatalLog(fmt.Sprintf("failed to load model config %s: %v", config.ModelConfigPath, err))
+	}
+	if config.ModelConfigReloadInterval > 0 {
+		go watchModelConfig(time.Duration(config.ModelConfigReloadInterval) * time.Second)
+	}
+}
+
+// ReloadModelRegistry 重新读取模型配置文件,失败时保留当前模型表
+func ReloadModelRegistry() error {
+	info, err := os.Stat(config.ModelConfigPath)
+	if err != nil {
+		return err
+	}
+	data, err := os.ReadFile(config.ModelConfigPath)
+	if err != nil {
+		return err
+	}
+	var file modelConfigFile
+	if err := yaml.Unmarshal(data, &file); err != nil {
+		return err
+	}
+	newRegistry, err := buildModelRegistry(file.Models)
+	if err != nil {
+		return err
+	}
+
+	registryMu.Lock()
+	registry = newRegistry
+	configModTime = info.ModTime()
+	registryMu.Unlock()
+
+	logger.SysLog(fmt.Sprintf("model config loaded: %d models, %d patterns", len(newRegistry.names), len(newRegistry.patterns)))
+	return nil
+}
+
+// watchModelConfig 定期检查模型配置文件的修改时间
+func watchModelConfig(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for range ticker.C {
+		info, err := os.Stat(config.ModelConfigPath)
+		if err != nil {
+			logger.SysError(fmt.Sprintf("stat model config err: %v", err))
+			continue
+		}
+		registryMu.RLock()
+		modified := !info.ModTime().Equal(configModTime)
+		registryMu.RUnlock()
+		if !modified {
+			continue
+		}
+		if err := ReloadModelRegistry(); err != nil {
+			logger.SysError(fmt.Sprintf("reload model config err: %v", err))
+		}
+	}
+}
+
+func mustBuildModelRegistry(configs []modelConfig) *modelRegistry {
+	r, err := buildModelRegistry(configs)
+	if err != nil {
+		panic(err)
+	}
+	return r
+}
+
+func buildModelRegistry(configs []modelConfig) (*modelRegistry, error) {
+	r := &modelRegistry{models: make(map[string]ModelInfo)}
+	for _, mc := range configs {
+		if mc.ID == "" {
+			return nil, fmt.Errorf("model id is required")
+		}
+		if mc.Source == "" {
+			return nil, fmt.Errorf("model %s: source is required", mc.ID)
+		}
+		switch mc.ContextTruncation {
+		case "", config.ContextTruncationNone, config.ContextTruncationReject, config.ContextTruncationDropOldest, config.ContextTruncationMiddleOut:
+		default:
+			return nil, fmt.Errorf("model %s: invalid context_truncation %s", mc.ID, mc.ContextTruncation)
+		}
+		info := mc.toModelInfo()
+
+		if strings.Contains(mc.ID, "*") {
+			r.patterns = append(r.patterns, info)
+			continue
+		}
+		for _, name := range append([]string{mc.ID}, mc.Aliases...) {
+			if _, exists := r.models[name]; exists {
+				return nil, fmt.Errorf("duplicate model name %s", name)
+			}
+			r.models[name] = info
+			r.names = append(r.names, name)
+		}
+	}
+	return r, nil
+}
+
+func (mc modelConfig) toModelInfo() ModelInfo {
+	info := ModelInfo{
+		ID:                    mc.ID,
+		Model:                 mc.Model,
+		Source:                mc.Source,
+		MaxTokens:             mc.MaxTokens,
+		ContextWindow:         mc.ContextWindow,
+		ContextTruncation:     mc.ContextTruncation,
+		Temperature:           mc.Temperature,
+		Thinking:              strings.HasSuffix(mc.ID, "-thinking"),
+		ThinkingBudget:        mc.ThinkingBudget,
+		ReasoningEffortRatios: make(map[string]float64, len(defaultReasoningEffortRatios)),
+		ThinkingMinBudget:     mc.ThinkingMinBudget,
+		Vision:                mc.Vision == nil || *mc.Vision,
+		Tools:                 mc.Tools == nil || *mc.Tools,
+		OwnedBy:               mc.OwnedBy,
+		Created:               mc.Created,
+	}
+	if info.Model == "" && !strings.Contains(mc.ID, "*") {
+		info.Model = mc.ID
+	}
+	if info.MaxTokens <= 0 {
+		info.MaxTokens = 8192
+	}
+	if mc.Thinking != nil {
+		info.Thinking = *mc.Thinking
+	}
+	for effort, ratio := range defaultReasoningEffortRatios {
+		info.ReasoningEffortRatios[effort] = ratio
+	}
+	for effort, ratio := range mc.ReasoningEffortRatios {
+		info.ReasoningEffortRatios[effort] = ratio
+	}
+	if info.ThinkingMinBudget <= 0 {
+		info.ThinkingMinBudget = defaultThinkingMinBudget
+	}
+	if info.OwnedBy == "" {
+		// 默认使用上游模型的厂商前缀,如google/gemini-2.5-pro
+		if vendor, _, found := strings.Cut(info.Model, "/"); found {
+			info.OwnedBy = vendor
+		} else {
+			info.OwnedBy = info.Source
+		}
+	}
+	if info.Created == 0 {
+		info.Created = StartTime
+	}
+	return info
+}
+
+// ContextTruncationStrategy 模型的上下文截断策略,未配置时使用CONTEXT_TRUNCATION
+func (m ModelInfo) ContextTruncationStrategy() string {
+	if m.ContextTruncation != "" {
+		return m.ContextTruncation
+	}
+	return config.ContextTruncation
+}
+
+// matchModelPattern 匹配含单个*的通配规则,返回*匹配的部分
+func matchModelPattern(pattern, name string) (string, bool) {
+	prefix, suffix, _ := strings.Cut(pattern, "*")
+	if len(name) < len(prefix)+len(suffix) || !strings.HasPrefix(name, prefix) || !strings.HasSuffix(name, suffix) {
+		return "", false
+	}
+	return name[len(prefix) : len(name)-len(suffix)], true
+}
+
+// 通过 model 名称查询的方法,依次匹配模型名称、别名与通配规则
+func GetModelInfo(modelName string) (ModelInfo, bool) {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+
+	if info, exists := registry.models[modelName]; exists {
+		return info, true
+	}
+	for _, pattern := range registry.patterns {
+		wildcard, ok := matchModelPattern(pattern.ID, modelName)
+		if !ok {
+			continue
+		}
+		info := pattern
+		info.ID = modelName
+		if info.Model == "" {
+			info.Model = modelName
+		} else {
+			info.Model = strings.Replace(info.Model, "*", wildcard, 1)
+		}
+		return info, true
+	}
+	return ModelInfo{}, false
+}
+
+// GetModelList 返回全部模型名称(含别名),不含通配规则
+func GetModelList() []string {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+	modelList := make([]string, len(registry.names))
+	copy(modelList, registry.names)
+	return modelList
+}
+
+// GetModels 返回全部模型(含别名,ID为对外名称),按名称排序,不含通配规则
+func GetModels() []ModelInfo {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+	models := make([]ModelInfo, 0, len(registry.names))
+	for _, name := range registry.names {
+		info := registry.models[name]
+		info.ID = name
+		models = append(models, info)
+	}
+	sort.Slice(models, func(i, j int) bool {
+		return models[i].ID < models[j].ID
+	})
+	return models
+}
@@ -0,0 +1,26 @@
+package common
+
+import (
+	"kilo2api/common/config"
+	"testing"
+)
+
+func TestModelContextTruncation(t *testing.T) {
+	r, err := buildModelRegistry([]modelConfig{
+		{ID: "default", Source: "openrouter"},
+		{ID: "drop", Source: "openrouter", ContextTruncation: config.ContextTruncationDropOldest},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := r.models["default"].ContextTruncationStrategy(); got != config.ContextTruncation {
+		t.Fatalf("default strategy = %s, want %s", got, config.ContextTruncation)
+	}
+	if got := r.models["drop"].ContextTruncationStrategy(); got != config.ContextTruncationDropOldest {
+		t.Fatalf("model strategy = %s, want %s", got, config.ContextTruncationDropOldest)
+	}
+
+	if _, err := buildModelRegistry([]modelConfig{{ID: "bad", Source: "openrouter", ContextTruncation: "truncate"}}); err == nil {
+		t.Fatal("invalid context_truncation accepted")
+	}
+}
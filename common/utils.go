@@ -80,6 +80,17 @@ func RandomElement[T any](slice []T) (T, error) {
 	return slice[index], nil
 }
 
+// ToInt 将JSON解析得到的数值转换为int,非数值返回0
+func ToInt(value interface{}) int {
+	switch v := value.(type) {
+	case float64:
+		return int(v)
+	case int:
+		return v
+	}
+	return 0
+}
+
 func SliceContains(slice []string, str string) bool {
 	for _, item := range slice {
 		if strings.Contains(str, item) {
@@ -172,38 +183,6 @@ func IsCloudflareChallenge(data string) bool {
 	return false
 }
 
-func IsRateLimit(data string) bool {
-	if data == `{"error":"Too many concurrent requests","message":"You have reached your maximum concurrent request limit. Please try again later."}` {
-		return true
-	}
-
-	return false
-}
-
-func IsUsageLimitExceeded(data string) bool {
-	if strings.HasPrefix(data, `{"error":"Usage limit exceeded","message":"You have reached your Kilo Code usage limit.`) {
-		return true
-	}
-
-	return false
-}
-
-func IsNotLogin(data string) bool {
-	if strings.Contains(data, `{"error":"Invalid token"}`) {
-		return true
-	}
-
-	return false
-}
-
-func IsServerError(data string) bool {
-	if data == `{"error":"Service Unavailable","message":"The service is temporarily unavailable. Please try again later."}` || data == `HTTP error status: 503` {
-		return true
-	}
-
-	return false
-}
-
 // 使用 MD5 算法
 func StringToMD5(str string) string {
 	hash := md5.Sum([]byte(str))
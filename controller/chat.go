@@ -1,27 +1,33 @@
 package controller
 
 import (
-	"bytes"
-	"compress/gzip"
 	"encoding/json"
 	"fmt"
 	"github.com/gin-gonic/gin"
 	"github.com/samber/lo"
-	"io"
 	"kilo2api/common"
 	"kilo2api/common/config"
+	"kilo2api/common/helper"
 	logger "kilo2api/common/loggger"
 	"kilo2api/cycletls"
-	"kilo2api/kilo-api"
 	"kilo2api/model"
+	"kilo2api/provider"
 	"net/http"
 	"strings"
+	"sync"
 	"time"
 )
 
 const (
-	errServerErrMsg  = "Service Unavailable"
 	responseIDFormat = "chatcmpl-%s"
+	// maxChoices 单次请求n的上限,每个choice对应一次上游请求
+	maxChoices = 8
+	// ignoredParamsHeader 返回被忽略的不支持参数
+	ignoredParamsHeader = "X-Ignored-Params"
+	// contextTruncatedHeader 返回超出上下文窗口时丢弃的消息
+	contextTruncatedHeader = "X-Context-Truncated"
+	// modelNotFoundCode 模型不存在的错误码,对应404
+	modelNotFoundCode = "model_not_found"
 )
 
 // ChatForOpenAI @Summary OpenAI对话接口
@@ -33,208 +39,264 @@ const (
 // @Param Authorization header string true "Authorization API-KEY"
 // @Router /v1/chat/completions [post]
 func ChatForOpenAI(c *gin.Context) {
-	client := cycletls.Init()
-	defer safeClose(client)
 
 	var openAIReq model.OpenAIChatCompletionRequest
-	if err := c.BindJSON(&openAIReq); err != nil {
+	if err := c.ShouldBindJSON(&openAIReq); err != nil {
 		logger.Errorf(c.Request.Context(), err.Error())
-		c.JSON(http.StatusInternalServerError, model.OpenAIErrorResponse{
-			OpenAIError: model.OpenAIError{
-				Message: "Invalid request parameters",
-				Type:    "request_error",
-				Code:    "500",
-			},
-		})
+		writeAPIError(c, bindError(err))
 		return
 	}
 
-	openAIReq.RemoveEmptyContentMessages()
-
-	modelInfo, b := common.GetModelInfo(openAIReq.Model)
-	if !b {
-		c.JSON(http.StatusBadRequest, model.OpenAIErrorResponse{
-			OpenAIError: model.OpenAIError{
-				Message: fmt.Sprintf("Model %s not supported", openAIReq.Model),
-				Type:    "invalid_request_error",
-				Code:    "invalid_model",
-			},
-		})
-		return
-	}
-	if openAIReq.MaxTokens > modelInfo.MaxTokens {
-		c.JSON(http.StatusBadRequest, model.OpenAIErrorResponse{
-			OpenAIError: model.OpenAIError{
-				Message: fmt.Sprintf("Max tokens %d exceeds limit %d", openAIReq.MaxTokens, modelInfo.MaxTokens),
-				Type:    "invalid_request_error",
-				Code:    "invalid_max_tokens",
-			},
-		})
+	modelInfo, p, ok := checkChatRequest(c, &openAIReq)
+	if !ok {
 		return
 	}
 
 	if openAIReq.Stream {
-		handleStreamRequest(c, client, openAIReq, modelInfo)
+		handleStreamRequest(c, p, openAIReq, modelInfo)
 	} else {
-		handleNonStreamRequest(c, client, openAIReq, modelInfo)
+		handleNonStreamRequest(c, p, openAIReq, modelInfo)
 	}
 }
 
-func handleNonStreamRequest(c *gin.Context, client cycletls.CycleTLS, openAIReq model.OpenAIChatCompletionRequest, modelInfo common.ModelInfo) {
-	ctx := c.Request.Context()
-	cookieManager := config.NewCookieManager()
-	maxRetries := len(cookieManager.Cookies)
-	cookie, err := cookieManager.GetRandomCookie()
-	if err != nil {
-		c.JSON(500, gin.H{"error": err.Error()})
-		return
+// checkChatRequest 校验对话请求的模型、参数与能力,校验失败时已写入错误响应
+func checkChatRequest(c *gin.Context, openAIReq *model.OpenAIChatCompletionRequest) (common.ModelInfo, provider.Provider, bool) {
+	modelInfo, p, openAIError := validateChatRequest(c, openAIReq)
+	if openAIError != nil {
+		writeAPIError(c, invalidRequestError(*openAIError))
+		return modelInfo, p, false
 	}
-	for attempt := 0; attempt < maxRetries; attempt++ {
-		requestBody, err := createRequestBody(c, &openAIReq, modelInfo)
-		if err != nil {
-			c.JSON(500, gin.H{"error": err.Error()})
-			return
+	return modelInfo, p, true
+}
+
+// validateChatRequest 校验对话请求,返回的错误对应400(模型不存在时为404)
+func validateChatRequest(c *gin.Context, openAIReq *model.OpenAIChatCompletionRequest) (common.ModelInfo, provider.Provider, *model.OpenAIError) {
+	modelInfo, p, b := getModelProvider(openAIReq.Model)
+	if !b {
+		return modelInfo, p, &model.OpenAIError{
+			Message: fmt.Sprintf("Model %s not supported", openAIReq.Model),
+			Type:    "invalid_request_error",
+			Param:   "model",
+			Code:    modelNotFoundCode,
+		}
+	}
+	if openAIReq.MaxTokens == 0 && openAIReq.MaxCompletionTokens > 0 {
+		openAIReq.MaxTokens = openAIReq.MaxCompletionTokens
+	}
+	if openAIReq.MaxTokens > modelInfo.MaxTokens {
+		return modelInfo, p, &model.OpenAIError{
+			Message: fmt.Sprintf("Max tokens %d exceeds limit %d", openAIReq.MaxTokens, modelInfo.MaxTokens),
+			Type:    "invalid_request_error",
+			Code:    "invalid_max_tokens",
 		}
+	}
 
-		jsonData, err := json.Marshal(requestBody)
-		if err != nil {
-			c.JSON(500, gin.H{"error": "Failed to marshal request body"})
-			return
+	// 消息校验先于空消息过滤,错误param中的下标与客户端请求一致
+	if openAIError := openAIReq.Validate(modelInfo); openAIError != nil {
+		return modelInfo, p, openAIError
+	}
+	if validator, ok := p.(provider.MessageValidator); ok {
+		if openAIError := validator.ValidateMessages(*openAIReq, modelInfo); openAIError != nil {
+			return modelInfo, p, openAIError
 		}
-		sseChan, err := kilo_api.MakeStreamChatRequest(c, client, jsonData, cookie, modelInfo)
-		if err != nil {
-			logger.Errorf(ctx, "MakeStreamChatRequest err on attempt %d: %v", attempt+1, err)
-			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
-			return
+	}
+	openAIReq.RemoveEmptyContentMessages()
+	if len(openAIReq.Messages) == 0 {
+		return modelInfo, p, &model.OpenAIError{
+			Message: "messages must contain at least one message with content",
+			Type:    "invalid_request_error",
+			Param:   "messages",
+			Code:    "invalid_messages",
 		}
+	}
 
-		isRateLimit := false
-		var delta string
-		var assistantMsgContent string
-		var shouldContinue bool
-		thinkStartType := new(bool)
-		thinkEndType := new(bool)
-	SSELoop:
-		for response := range sseChan {
-			data := response.Data
-			if data == "" {
-				continue
-			}
-			if response.Done {
-				switch {
-				case common.IsUsageLimitExceeded(data):
-					if config.CheatEnabled {
-						split := strings.Split(cookie, "=")
-						if len(split) == 2 {
-							cookieSession := split[1]
-							cheatResp, err := client.Do(config.CheatUrl, cycletls.Options{
-								Timeout: 10 * 60 * 60,
-								Proxy:   config.ProxyUrl, // 在每个请求中设置代理
-								Body:    "",
-								Headers: map[string]string{
-									"Cookie": cookieSession,
-								},
-							}, "POST")
-							if err != nil {
-								logger.Errorf(ctx, "Cheat err Cookie: %s err: %v", cookie, err)
-								c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
-								return
-							}
-							if cheatResp.Status == 200 {
-								logger.Debug(c, fmt.Sprintf("Cheat Success Cookie: %s", cookie))
-								attempt-- // 抵消循环结束时的attempt++
-								break SSELoop
-							}
-							if cheatResp.Status == 402 {
-								logger.Warnf(ctx, "Cookie Unlink Card Cookie: %s", cookie)
-							} else {
-								logger.Errorf(ctx, "Cheat err Cookie: %s Resp: %v", cookie, cheatResp.Body)
-								c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("Cheat Resp.Status:%v Resp.Body:%v", cheatResp.Status, cheatResp.Body)})
-								return
-							}
-						}
-					}
-
-					isRateLimit = true
-					logger.Warnf(ctx, "Cookie Usage limit exceeded, switching to next cookie, attempt %d/%d, COOKIE:%s", attempt+1, maxRetries, cookie)
-					config.RemoveCookie(cookie)
-					break SSELoop
-				case common.IsServerError(data):
-					logger.Errorf(ctx, errServerErrMsg)
-					c.JSON(http.StatusInternalServerError, gin.H{"error": errServerErrMsg})
-					return
-				case common.IsNotLogin(data):
-					isRateLimit = true
-					logger.Warnf(ctx, "Cookie Not Login, switching to next cookie, attempt %d/%d, COOKIE:%s", attempt+1, maxRetries, cookie)
-					break SSELoop
-				case common.IsRateLimit(data):
-					isRateLimit = true
-					logger.Warnf(ctx, "Cookie rate limited, switching to next cookie, attempt %d/%d, COOKIE:%s", attempt+1, maxRetries, cookie)
-					config.AddRateLimitCookie(cookie, time.Now().Add(time.Duration(config.RateLimitCookieLockDuration)*time.Second))
-					break SSELoop
-				}
-				logger.Warnf(ctx, response.Data)
-				return
+	if err := openAIReq.CheckModelCapabilities(modelInfo); err != nil {
+		return modelInfo, p, &model.OpenAIError{
+			Message: err.Error(),
+			Type:    "invalid_request_error",
+			Code:    "unsupported_capability",
+		}
+	}
+
+	if _, err := openAIReq.ResolveThinkingBudget(modelInfo); err != nil {
+		return modelInfo, p, &model.OpenAIError{
+			Message: err.Error(),
+			Type:    "invalid_request_error",
+			Code:    "invalid_thinking_budget",
+		}
+	}
+
+	if !config.IsValidReasoningFormat(openAIReq.ReasoningFormat) {
+		return modelInfo, p, &model.OpenAIError{
+			Message: fmt.Sprintf("Invalid reasoning_format %s, expected one of: %s, %s, %s", openAIReq.ReasoningFormat, config.ReasoningFormatContent, config.ReasoningFormatThink, config.ReasoningFormatHidden),
+			Type:    "invalid_request_error",
+			Param:   "reasoning_format",
+			Code:    "invalid_reasoning_format",
+		}
+	}
+
+	if err := openAIReq.ResponseFormat.Check(); err != nil {
+		return modelInfo, p, &model.OpenAIError{
+			Message: err.Error(),
+			Type:    "invalid_request_error",
+			Param:   "response_format",
+			Code:    "invalid_response_format",
+		}
+	}
+	if _, ok := p.(provider.ResponseFormatEmulator); ok {
+		if err := openAIReq.ResponseFormat.CheckToolSchema(); err != nil {
+			return modelInfo, p, &model.OpenAIError{
+				Message: err.Error(),
+				Type:    "invalid_request_error",
+				Param:   "response_format.json_schema.schema",
+				Code:    "invalid_response_format",
 			}
+		}
+	}
 
-			logger.Debug(ctx, strings.TrimSpace(data))
+	if openAIReq.N < 0 || openAIReq.N > maxChoices {
+		return modelInfo, p, &model.OpenAIError{
+			Message: fmt.Sprintf("Invalid n %d, expected a value between 1 and %d", openAIReq.N, maxChoices),
+			Type:    "invalid_request_error",
+			Param:   "n",
+			Code:    "invalid_n",
+		}
+	}
 
-			streamDelta, streamShouldContinue := processNoStreamData(c, data, modelInfo, thinkStartType, thinkEndType)
-			delta = streamDelta
-			shouldContinue = streamShouldContinue
-			// 处理事件流数据
-			if !shouldContinue {
-				promptTokens := model.CountTokenText(string(jsonData), openAIReq.Model)
-				completionTokens := model.CountTokenText(assistantMsgContent, openAIReq.Model)
-				finishReason := "stop"
-
-				c.JSON(http.StatusOK, model.OpenAIChatCompletionResponse{
-					ID:      fmt.Sprintf(responseIDFormat, time.Now().Format("20060102150405")),
-					Object:  "chat.completion",
-					Created: time.Now().Unix(),
-					Model:   openAIReq.Model,
-					Choices: []model.OpenAIChoice{{
-						Message: model.OpenAIMessage{
-							Role:    "assistant",
-							Content: assistantMsgContent,
-						},
-						FinishReason: &finishReason,
-					}},
-					Usage: model.OpenAIUsage{
-						PromptTokens:     promptTokens,
-						CompletionTokens: completionTokens,
-						TotalTokens:      promptTokens + completionTokens,
-					},
-				})
-
-				return
-			} else {
-				assistantMsgContent = assistantMsgContent + delta
+	if unsupported := lo.Without(openAIReq.SamplingParams(), p.SupportedParams()...); len(unsupported) > 0 {
+		if config.RejectUnsupportedParams {
+			return modelInfo, p, &model.OpenAIError{
+				Message: fmt.Sprintf("Model %s does not support parameters: %s", openAIReq.Model, strings.Join(unsupported, ", ")),
+				Type:    "invalid_request_error",
+				Param:   unsupported[0],
+				Code:    "unsupported_parameter",
 			}
 		}
-		if !isRateLimit {
-			return
+		logger.Warnf(c.Request.Context(), "model %s ignores unsupported parameters: %s", openAIReq.Model, strings.Join(unsupported, ", "))
+		c.Header(ignoredParamsHeader, strings.Join(unsupported, ","))
+	}
+	return modelInfo, p, nil
+}
+
+func handleNonStreamRequest(c *gin.Context, p provider.Provider, openAIReq model.OpenAIChatCompletionRequest, modelInfo common.ModelInfo) {
+	requestBody, err := createRequestBody(c, p, &openAIReq, modelInfo)
+	if err != nil {
+		writeError(c, err)
+		return
+	}
+
+	jsonData, err := json.Marshal(requestBody)
+	if err != nil {
+		writeError(c, err)
+		return
+	}
+
+	states := make([]*chatResponseState, choiceCount(openAIReq))
+	errs := fanOutChoices(len(states), func(index int) error {
+		state := newChatResponseState(reasoningFormat(c, openAIReq))
+		state.setResponseFormat(openAIReq.ResponseFormat, p)
+		states[index] = state
+		parser := p.NewStreamParser()
+		err := relayChatRequest(c, p, jsonData, func(event cycletls.SSEEvent) bool {
+			// 处理事件流数据
+			return processNoStreamData(c, event, parser, state)
+		})
+		if err == nil {
+			err = state.err
 		}
+		return err
+	})
+	if err := streamError(c, errs, states); err != nil {
+		writeError(c, err)
+		return
+	}
+	if c.Writer.Written() {
+		return
+	}
 
-		// 获取下一个可用的cookie继续尝试
-		cookie, err = cookieManager.GetNextCookie()
-		if err != nil {
-			logger.Errorf(ctx, "No more valid cookies available after attempt %d", attempt+1)
-			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	var choices []model.OpenAIChoice
+	var usage *model.OpenAIUsage
+	for index, state := range states {
+		if err := state.validateOutput(); err != nil {
+			logger.Errorf(c.Request.Context(), "validateOutput err: %v", err)
+			writeAPIError(c, responseFormatAPIError(err))
 			return
 		}
+		finishReason := state.openAIFinishReason()
+		message := model.OpenAIMessage{
+			Role:               "assistant",
+			ReasoningContent:   state.reasoning,
+			ReasoningSignature: state.signature,
+			ToolCalls:          state.toolState.toolCalls(),
+		}
+		if content := state.content; content != "" || len(message.ToolCalls) == 0 {
+			message.Content = &content
+		}
+		choices = append(choices, model.OpenAIChoice{
+			Index:        index,
+			Message:      message,
+			FinishReason: &finishReason,
+		})
+		usage = addUsage(usage, state.openAIUsage(jsonData, openAIReq.Model))
+	}
+
+	c.JSON(http.StatusOK, model.OpenAIChatCompletionResponse{
+		ID:      fmt.Sprintf(responseIDFormat, time.Now().Format("20060102150405")),
+		Object:  "chat.completion",
+		Created: time.Now().Unix(),
+		Model:   openAIReq.Model,
+		Choices: choices,
+		Usage:   usage,
+	})
+}
 
+// choiceCount 返回请求的choice数量,未指定时为1
+func choiceCount(openAIReq model.OpenAIChatCompletionRequest) int {
+	return max(openAIReq.N, 1)
+}
+
+// fanOutChoices 为每个choice并发发起一次上游请求,返回各choice的错误
+func fanOutChoices(n int, run func(index int) error) []error {
+	errs := make([]error, n)
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func(index int) {
+			defer wg.Done()
+			errs[index] = run(index)
+		}(i)
 	}
-	logger.Errorf(ctx, "All cookies exhausted after %d attempts", maxRetries)
-	c.JSON(http.StatusInternalServerError, gin.H{"error": "All cookies are temporarily unavailable."})
-	return
+	wg.Wait()
+	return errs
 }
 
-func createRequestBody(c *gin.Context, openAIReq *model.OpenAIChatCompletionRequest, modelInfo common.ModelInfo) (map[string]interface{}, error) {
+// createRequestBody 构造上游请求体,输入超出上下文窗口时按配置的策略截断
+func createRequestBody(c *gin.Context, p provider.Provider, openAIReq *model.OpenAIChatCompletionRequest, modelInfo common.ModelInfo) (map[string]interface{}, error) {
+	requestBody, err := buildRequestBody(c, p, openAIReq, modelInfo)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := fitContextWindow(c, requestBody, modelInfo, openAIReq.Model, openAIReq.MaxTokens); err != nil {
+		return nil, err
+	}
+	return requestBody, nil
+}
 
-	client := cycletls.Init()
-	defer safeClose(client)
+// fitContextWindow 截断后通过响应头返回丢弃的消息数与token数
+func fitContextWindow(c *gin.Context, requestBody map[string]interface{}, modelInfo common.ModelInfo, modelName string, maxTokens int) (model.ContextTruncation, error) {
+	truncation, err := model.FitContextWindow(requestBody, modelName, modelInfo.ContextWindow, maxTokens, modelInfo.ContextTruncationStrategy())
+	if err != nil {
+		return truncation, err
+	}
+	if truncation.DroppedMessages > 0 {
+		logger.Warnf(c.Request.Context(), "model %s context window exceeded, truncated: %s", modelName, truncation)
+		c.Header(contextTruncatedHeader, truncation.String())
+	}
+	return truncation, nil
+}
 
+// buildRequestBody 按提供方将OpenAI请求转换为上游请求体
+func buildRequestBody(c *gin.Context, p provider.Provider, openAIReq *model.OpenAIChatCompletionRequest, modelInfo common.ModelInfo) (map[string]interface{}, error) {
 	if config.PRE_MESSAGES_JSON != "" {
 		err := openAIReq.PrependMessagesFromJSON(config.PRE_MESSAGES_JSON)
 		if err != nil {
@@ -243,30 +305,18 @@ func createRequestBody(c *gin.Context, openAIReq *model.OpenAIChatCompletionRequ
 	}
 
 	if openAIReq.MaxTokens <= 1 {
-		openAIReq.MaxTokens = 8192
+		openAIReq.MaxTokens = model.DefaultMaxTokens(modelInfo)
 	}
 
-	var data []byte
-	var err error
-	if modelInfo.Source == "claude" {
-		claudeRequest, err := model.ConvertOpenAIToClaudeRequest(*openAIReq, modelInfo)
-		if err != nil {
-			return nil, fmt.Errorf("ConvertOpenAIToClaudeRequest err: %v", err)
-		}
-		data, err = json.Marshal(claudeRequest)
-		if err != nil {
-			return nil, err
-		}
+	// 未指定温度时使用模型配置的默认温度
+	if openAIReq.Temperature == nil && modelInfo.Temperature != nil {
+		temperature := *modelInfo.Temperature
+		openAIReq.Temperature = &temperature
+	}
 
-	} else if modelInfo.Source == "openrouter" {
-		geminiReq, err := model.ConvertOpenAIToGeminiRequest(*openAIReq, modelInfo)
-		if err != nil {
-			return nil, fmt.Errorf("ConvertOpenAIToGeminiRequest err: %v", err)
-		}
-		data, err = json.Marshal(geminiReq)
-		if err != nil {
-			return nil, err
-		}
+	data, err := p.BuildRequest(*openAIReq, modelInfo)
+	if err != nil {
+		return nil, err
 	}
 
 	requestBody := make(map[string]interface{})
@@ -282,9 +332,7 @@ func createRequestBody(c *gin.Context, openAIReq *model.OpenAIChatCompletionRequ
 }
 
 // createStreamResponse 创建流式响应
-func createStreamResponse(responseId, modelName string, jsonData []byte, delta model.OpenAIDelta, finishReason *string) model.OpenAIChatCompletionResponse {
-	promptTokens := model.CountTokenText(string(jsonData), modelName)
-	completionTokens := model.CountTokenText(delta.Content, modelName)
+func createStreamResponse(responseId, modelName string, index int, delta model.OpenAIDelta, finishReason *string) model.OpenAIChatCompletionResponse {
 	return model.OpenAIChatCompletionResponse{
 		ID:      responseId,
 		Object:  "chat.completion.chunk",
@@ -292,488 +340,637 @@ func createStreamResponse(responseId, modelName string, jsonData []byte, delta m
 		Model:   modelName,
 		Choices: []model.OpenAIChoice{
 			{
-				Index:        0,
+				Index:        index,
 				Delta:        delta,
 				FinishReason: finishReason,
 			},
 		},
-		Usage: model.OpenAIUsage{
-			PromptTokens:     promptTokens,
-			CompletionTokens: completionTokens,
-			TotalTokens:      promptTokens + completionTokens,
-		},
 	}
 }
 
 // handleDelta 处理消息字段增量
-func handleDelta(c *gin.Context, delta string, responseId, modelName string, jsonData []byte) error {
+func handleDelta(c *gin.Context, delta, reasoning string, responseId, modelName string, index int) error {
 	// 创建基础响应
-	createResponse := func(content string) model.OpenAIChatCompletionResponse {
+	createResponse := func(content, reasoningContent string) model.OpenAIChatCompletionResponse {
 		return createStreamResponse(
 			responseId,
 			modelName,
-			jsonData,
-			model.OpenAIDelta{Content: content, Role: "assistant"},
+			index,
+			model.OpenAIDelta{Content: content, ReasoningContent: reasoningContent, Role: "assistant"},
 			nil,
 		)
 	}
 
 	// 发送基础事件
 	var err error
-	if err = sendSSEvent(c, createResponse(delta)); err != nil {
+	if err = sendSSEvent(c, createResponse(delta, reasoning)); err != nil {
 		return err
 	}
 
 	return err
 }
 
+// handleSignatureDelta 下发思考块签名
+func handleSignatureDelta(c *gin.Context, signature string, responseId, modelName string, index int) error {
+	return sendSSEvent(c, createStreamResponse(
+		responseId,
+		modelName,
+		index,
+		model.OpenAIDelta{Role: "assistant", ReasoningSignature: signature},
+		nil,
+	))
+}
+
+// handleToolCallDelta 处理tool_calls增量
+func handleToolCallDelta(c *gin.Context, responseId, modelName string, index int, toolCalls ...model.OpenAIToolCall) error {
+	return sendSSEvent(c, createStreamResponse(
+		responseId,
+		modelName,
+		index,
+		model.OpenAIDelta{Role: "assistant", ToolCalls: toolCalls},
+		nil,
+	))
+}
+
 // handleMessageResult 处理消息结果
-func handleMessageResult(c *gin.Context, responseId, modelName string, jsonData []byte) bool {
-	finishReason := "stop"
+func handleMessageResult(c *gin.Context, responseId, modelName string, index int, finishReason string) bool {
 	var delta string
 
-	promptTokens := 0
-	completionTokens := 0
-
-	streamResp := createStreamResponse(responseId, modelName, jsonData, model.OpenAIDelta{Content: delta, Role: "assistant"}, &finishReason)
-	streamResp.Usage = model.OpenAIUsage{
-		PromptTokens:     promptTokens,
-		CompletionTokens: completionTokens,
-		TotalTokens:      promptTokens + completionTokens,
-	}
+	streamResp := createStreamResponse(responseId, modelName, index, model.OpenAIDelta{Content: delta, Role: "assistant"}, &finishReason)
 
 	if err := sendSSEvent(c, streamResp); err != nil {
 		logger.Warnf(c.Request.Context(), "sendSSEvent err: %v", err)
-		return false
 	}
-	c.SSEvent("", " [DONE]")
 	return false
 }
 
+// handleStreamEnd 所有choice结束后下发用量块(usage不为nil时)与[DONE]
+func handleStreamEnd(c *gin.Context, responseId, modelName string, usage *model.OpenAIUsage) {
+	if usage != nil {
+		usageResp := createStreamResponse(responseId, modelName, 0, model.OpenAIDelta{}, nil)
+		usageResp.Choices = []model.OpenAIChoice{}
+		usageResp.Usage = usage
+		if err := sendSSEvent(c, usageResp); err != nil {
+			logger.Warnf(c.Request.Context(), "sendSSEvent err: %v", err)
+		}
+	}
+	c.SSEvent("", " [DONE]")
+	c.Writer.Flush()
+}
+
+// streamWriteLockKey 多个choice并发写入同一响应时使用的写锁
+const streamWriteLockKey = "streamWriteLock"
+
 // sendSSEvent 发送SSE事件
-func sendSSEvent(c *gin.Context, response model.OpenAIChatCompletionResponse) error {
+func sendSSEvent(c *gin.Context, response interface{}) error {
 	jsonResp, err := json.Marshal(response)
 	if err != nil {
 		logger.Errorf(c.Request.Context(), "Failed to marshal response: %v", err)
 		return err
 	}
+	if lock, ok := c.Get(streamWriteLockKey); ok {
+		mu := lock.(*sync.Mutex)
+		mu.Lock()
+		defer mu.Unlock()
+	}
 	c.SSEvent("", " "+string(jsonResp))
 	c.Writer.Flush()
 	return nil
 }
 
-func handleStreamRequest(c *gin.Context, client cycletls.CycleTLS, openAIReq model.OpenAIChatCompletionRequest, modelInfo common.ModelInfo) {
+func handleStreamRequest(c *gin.Context, p provider.Provider, openAIReq model.OpenAIChatCompletionRequest, modelInfo common.ModelInfo) {
 
 	c.Header("Content-Type", "text/event-stream")
 	c.Header("Cache-Control", "no-cache")
 	c.Header("Connection", "keep-alive")
 
 	responseId := fmt.Sprintf(responseIDFormat, time.Now().Format("20060102150405"))
-	ctx := c.Request.Context()
 
-	cookieManager := config.NewCookieManager()
-	maxRetries := len(cookieManager.Cookies)
-	cookie, err := cookieManager.GetRandomCookie()
+	requestBody, err := createRequestBody(c, p, &openAIReq, modelInfo)
 	if err != nil {
-		c.JSON(500, gin.H{"error": err.Error()})
+		writeError(c, err)
 		return
 	}
 
-	thinkStartType := new(bool)
-	thinkEndType := new(bool)
+	jsonData, err := json.Marshal(requestBody)
+	if err != nil {
+		writeError(c, err)
+		return
+	}
 
-	c.Stream(func(w io.Writer) bool {
-		for attempt := 0; attempt < maxRetries; attempt++ {
-			requestBody, err := createRequestBody(c, &openAIReq, modelInfo)
-			if err != nil {
-				c.JSON(500, gin.H{"error": err.Error()})
-				return false
+	states := make([]*chatResponseState, choiceCount(openAIReq))
+	if len(states) > 1 {
+		c.Set(streamWriteLockKey, &sync.Mutex{})
+	}
+	errs := fanOutChoices(len(states), func(index int) error {
+		state := newChatResponseState(reasoningFormat(c, openAIReq))
+		state.index = index
+		state.setResponseFormat(openAIReq.ResponseFormat, p)
+		states[index] = state
+		parser := p.NewStreamParser()
+		err := relayChatRequest(c, p, jsonData, func(event cycletls.SSEEvent) bool {
+			// 处理事件流数据
+			return processStreamData(c, event, responseId, openAIReq.Model, parser, state)
+		})
+		if err == nil {
+			err = state.err
+		}
+		// 上游已结束生成但未正常关闭流时补发结束块
+		if err == nil && state.finishReason != "" && !state.finished {
+			finishStream(c, responseId, openAIReq.Model, state)
+		}
+		return err
+	})
+
+	var usage *model.OpenAIUsage
+	if err := streamError(c, errs, states); err != nil {
+		writeError(c, err)
+		return
+	}
+	for _, state := range states {
+		if state.finished {
+			usage = addUsage(usage, state.openAIUsage(jsonData, openAIReq.Model))
+		}
+	}
+	if c.Request.Context().Err() != nil {
+		return
+	}
+	if openAIReq.StreamOptions == nil || !openAIReq.StreamOptions.IncludeUsage {
+		usage = nil
+	}
+	handleStreamEnd(c, responseId, openAIReq.Model, usage)
+}
+
+// streamError 返回首个失败choice的错误,未返回结束原因即中断的choice视为上游异常;客户端已断开时返回nil。流式与非流式共用
+func streamError(c *gin.Context, errs []error, states []*chatResponseState) error {
+	var streamErr error
+	for index, state := range states {
+		err := errs[index]
+		if err == nil && !state.finished && c.Request.Context().Err() == nil {
+			err = errIncompleteResponse
+		}
+		if err != nil {
+			logger.Errorf(c.Request.Context(), "choice %d err: %v", index, err)
+			if streamErr == nil {
+				streamErr = err
 			}
+		}
+	}
+	return streamErr
+}
+
+// finishStream 下发choice的结束块,结构化输出不符合要求时改为下发错误
+func finishStream(c *gin.Context, responseId, modelName string, state *chatResponseState) {
+	state.finished = true
+	if err := state.validateOutput(); err != nil {
+		logger.Errorf(c.Request.Context(), "validateOutput err: %v", err)
+		sendSSEvent(c, responseFormatError(err))
+		return
+	}
+	handleMessageResult(c, responseId, modelName, state.index, state.openAIFinishReason())
+}
+
+// responseFormatError 模型输出不符合response_format时返回的错误
+func responseFormatError(err error) model.OpenAIErrorResponse {
+	return model.OpenAIErrorResponse{
+		OpenAIError: model.OpenAIError{
+			Message: fmt.Sprintf("Model output does not conform to response_format: %v", err),
+			Type:    "server_error",
+			Code:    "invalid_response_format",
+		},
+	}
+}
+
+// 处理流式数据的辅助函数，返回bool表示是否继续处理
+func processStreamData(c *gin.Context, sseEvent cycletls.SSEEvent, responseId, model string, parser provider.StreamParser, state *chatResponseState) bool {
+	events, done, err := parser.Parse(sseEvent)
+	if err != nil {
+		logger.Errorf(c.Request.Context(), "Failed to parse event: %v", err)
+		state.err = err
+		return false
+	}
 
-			jsonData, err := json.Marshal(requestBody)
-			if err != nil {
-				c.JSON(500, gin.H{"error": "Failed to marshal request body"})
+	for _, event := range events {
+		switch event.Type {
+		case provider.EventThinking, provider.EventText:
+			text, reasoning := state.splitDelta(event)
+			if text == "" && reasoning == "" {
+				continue
+			}
+			if err := handleDelta(c, text, reasoning, responseId, model, state.index); err != nil {
+				logger.Errorf(c.Request.Context(), "handleDelta err: %v", err)
 				return false
 			}
-			sseChan, err := kilo_api.MakeStreamChatRequest(c, client, jsonData, cookie, modelInfo)
-			if err != nil {
-				logger.Errorf(ctx, "MakeStreamChatRequest err on attempt %d: %v", attempt+1, err)
-				c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			state.content += text
+			state.reasoning += reasoning
+			if event.Type == provider.EventText {
+				state.text += event.Text
+			}
+		case provider.EventThinkingSignature:
+			if !state.keepSignature() {
+				continue
+			}
+			if err := handleSignatureDelta(c, event.Text, responseId, model, state.index); err != nil {
+				logger.Errorf(c.Request.Context(), "handleSignatureDelta err: %v", err)
 				return false
 			}
-
-			isRateLimit := false
-		SSELoop:
-			for response := range sseChan {
-
-				if response.Status == 403 {
-					compressedData := []byte(response.Data)
-
-					compressedReader := bytes.NewReader(compressedData)
-					gzipReader, err := gzip.NewReader(compressedReader)
-					if err != nil {
-						logger.Errorf(c.Request.Context(), "Failed to create gzip reader: %v", err)
-						c.JSON(500, gin.H{"error": "Failed to create gzip reader"})
-						return false
-					}
-					// 读取解压后的数据
-					uncompressedData, err := io.ReadAll(gzipReader)
-					if err != nil {
-						logger.Errorf(c.Request.Context(), "Failed to read uncompressed data: %v", err)
-						c.JSON(500, gin.H{"error": "Failed to read uncompressed data"})
-						return false
-					}
-					gzipReader.Close()
-					logger.Errorf(c, string(uncompressedData))
-					//c.JSON(http.StatusForbidden, gin.H{"error": "Forbidden"})
-					config.RemoveCookie(cookie)
-					isRateLimit = true
-					break SSELoop
-				}
-
-				data := response.Data
-				if data == "" {
-					continue
-				}
-
-				if response.Done {
-					switch {
-					case common.IsUsageLimitExceeded(data):
-						if config.CheatEnabled {
-							split := strings.Split(cookie, "=")
-							if len(split) == 2 {
-								cookieSession := split[1]
-								cheatResp, err := client.Do(config.CheatUrl, cycletls.Options{
-									Timeout: 10 * 60 * 60,
-									Proxy:   config.ProxyUrl, // 在每个请求中设置代理
-									Body:    "",
-									Headers: map[string]string{
-										"Cookie": cookieSession,
-									},
-								}, "POST")
-								if err != nil {
-									logger.Errorf(ctx, "Cheat err Cookie: %s err: %v", cookie, err)
-									c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
-									return false
-								}
-								if cheatResp.Status == 200 {
-									logger.Debug(c, fmt.Sprintf("Cheat Success Cookie: %s", cookie))
-									attempt-- // 抵消循环结束时的attempt++
-									break SSELoop
-								}
-								if cheatResp.Status == 402 {
-									logger.Warnf(ctx, "Cheat failed.  Cookie: %s Resp: %v", cookie, cheatResp.Body)
-								} else {
-									logger.Errorf(ctx, "Cheat err Cookie: %s Resp: %v", cookie, cheatResp.Body)
-									c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("Cheat Resp.Status:%v Resp.Body:%v", cheatResp.Status, cheatResp.Body)})
-									return false
-								}
-							}
-						}
-						isRateLimit = true
-						logger.Warnf(ctx, "Cookie Usage limit exceeded, switching to next cookie, attempt %d/%d, COOKIE:%s", attempt+1, maxRetries, cookie)
-						config.RemoveCookie(cookie)
-						break SSELoop
-					case common.IsServerError(data):
-						logger.Errorf(ctx, errServerErrMsg)
-						c.JSON(http.StatusInternalServerError, gin.H{"error": errServerErrMsg})
-						return false
-					case common.IsNotLogin(data):
-						isRateLimit = true
-						logger.Warnf(ctx, "Cookie Not Login, switching to next cookie, attempt %d/%d, COOKIE:%s", attempt+1, maxRetries, cookie)
-						break SSELoop // 使用 label 跳出 SSE 循环
-					case common.IsRateLimit(data):
-						isRateLimit = true
-						logger.Warnf(ctx, "Cookie rate limited, switching to next cookie, attempt %d/%d, COOKIE:%s", attempt+1, maxRetries, cookie)
-						config.AddRateLimitCookie(cookie, time.Now().Add(time.Duration(config.RateLimitCookieLockDuration)*time.Second))
-						break SSELoop
-					}
-					logger.Warnf(ctx, response.Data)
+			state.signature += event.Text
+		case provider.EventToolCallStart:
+			// 工具调用开始前关闭未结束的思考标签
+			if text := closeThinkTag(&state.thinkStartType, &state.thinkEndType); text != "" {
+				if err := handleDelta(c, text, "", responseId, model, state.index); err != nil {
+					logger.Errorf(c.Request.Context(), "handleDelta err: %v", err)
 					return false
 				}
-
-				logger.Debug(ctx, strings.TrimSpace(data))
-
-				_, shouldContinue := processStreamData(c, data, responseId, openAIReq.Model, modelInfo, jsonData, thinkStartType, thinkEndType)
-				// 处理事件流数据
-
-				if !shouldContinue {
+				state.content += text
+			}
+			if state.startJSONTool(event) {
+				continue
+			}
+			if err := handleToolCallDelta(c, responseId, model, state.index, state.toolState.start(event)); err != nil {
+				logger.Errorf(c.Request.Context(), "handleToolCallDelta err: %v", err)
+				return false
+			}
+		case provider.EventToolCallArguments:
+			// 模拟结构化输出的工具参数作为正文下发
+			if state.isJSONToolArguments(event) {
+				if err := handleDelta(c, event.Text, "", responseId, model, state.index); err != nil {
+					logger.Errorf(c.Request.Context(), "handleDelta err: %v", err)
 					return false
 				}
+				state.content += event.Text
+				state.text += event.Text
+				continue
 			}
-
-			if !isRateLimit {
-				return true
+			toolCall, ok := state.toolState.appendArguments(event)
+			if !ok {
+				continue
 			}
-
-			// 获取下一个可用的cookie继续尝试
-			cookie, err = cookieManager.GetNextCookie()
-			if err != nil {
-				logger.Errorf(ctx, "No more valid cookies available after attempt %d", attempt+1)
-				c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			if err := handleToolCallDelta(c, responseId, model, state.index, toolCall); err != nil {
+				logger.Errorf(c.Request.Context(), "handleToolCallDelta err: %v", err)
 				return false
 			}
+		case provider.EventUsage:
+			state.usage = event.Usage
+		case provider.EventFinish:
+			// 用量可能在结束事件之后才到达,等待流结束再下发结束块
+			state.finishReason = event.FinishReason
 		}
+	}
+	if done && !state.finished {
+		finishStream(c, responseId, model, state)
+	}
+	return !done
+}
 
-		logger.Errorf(ctx, "All cookies exhausted after %d attempts", maxRetries)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "All cookies are temporarily unavailable."})
+func processNoStreamData(c *gin.Context, sseEvent cycletls.SSEEvent, parser provider.StreamParser, state *chatResponseState) bool {
+	events, done, err := parser.Parse(sseEvent)
+	if err != nil {
+		logger.Errorf(c.Request.Context(), "Failed to parse event: %v", err)
+		state.err = err
 		return false
-	})
+	}
+
+	for _, event := range events {
+		state.accumulate(event)
+	}
+	// 与流式一致,收到结束原因或上游结束标记才算完整响应
+	if done || state.finishReason != "" {
+		state.finished = true
+	}
+	return !done
 }
 
-// 处理流式数据的辅助函数，返回bool表示是否继续处理
-func processStreamData(c *gin.Context, data, responseId, model string, modelInfo common.ModelInfo, jsonData []byte, thinkStartType, thinkEndType *bool) (string, bool) {
-	data = strings.TrimSpace(data)
-	data = strings.TrimPrefix(data, "data: ")
+// thinkTagText 将思考内容包裹在<think>标签中,思考结束后的首段文本前补充闭合标签
+func thinkTagText(event provider.Event, thinkStartType, thinkEndType *bool) string {
+	if event.Type == provider.EventThinking {
+		if !*thinkStartType {
+			*thinkStartType = true
+			*thinkEndType = false
+			return "<think>\n\n" + event.Text
+		}
+		return event.Text
+	}
+	return closeThinkTag(thinkStartType, thinkEndType) + event.Text
+}
 
-	// 处理[DONE]标记
-	if data == "[DONE]" {
-		return "", false
+// closeThinkTag 思考未闭合时返回闭合标签
+func closeThinkTag(thinkStartType, thinkEndType *bool) string {
+	if *thinkStartType && !*thinkEndType {
+		*thinkStartType = false
+		*thinkEndType = true
+		return "</think>\n\n"
 	}
+	return ""
+}
 
-	var event map[string]interface{}
-	if err := json.Unmarshal([]byte(data), &event); err != nil {
-		logger.Errorf(c.Request.Context(), "Failed to unmarshal event: %v", err)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
-		return "", false
+// OpenaiModels @Summary OpenAI模型列表接口
+// @Description OpenAI模型列表接口
+// @Tags OpenAI
+// @Accept json
+// @Produce json
+// @Param Authorization header string true "Authorization API-KEY"
+// @Success 200 {object} common.ResponseResult{data=model.OpenaiModelListResponse} "成功"
+// @Router /v1/models [get]
+func OpenaiModels(c *gin.Context) {
+	var openaiModelListResponse model.OpenaiModelListResponse
+	var openaiModelResponse []model.OpenaiModelResponse
+	openaiModelListResponse.Object = "list"
+
+	for _, modelInfo := range common.GetModels() {
+		openaiModelResponse = append(openaiModelResponse, model.OpenaiModelResponse{
+			ID:              modelInfo.ID,
+			Object:          "model",
+			Created:         modelInfo.Created,
+			OwnedBy:         modelInfo.OwnedBy,
+			ContextLength:   modelInfo.ContextWindow,
+			MaxOutputTokens: modelInfo.MaxTokens,
+		})
 	}
+	openaiModelListResponse.Data = openaiModelResponse
+	c.JSON(http.StatusOK, openaiModelListResponse)
+	return
+}
 
-	if modelInfo.Source == "claude" {
-		eventType, ok := event["type"]
-		if !ok {
-			logger.Errorf(c.Request.Context(), "Event type not found")
-			return "", false
-		}
+// chatResponseState 记录一次对话响应的累计状态
+type chatResponseState struct {
+	// reasoningFormat 思考内容输出格式
+	reasoningFormat string
+	thinkStartType  bool
+	thinkEndType    bool
+	toolState       *toolCallState
+	// content 已输出的文本内容
+	content string
+	// reasoning 已输出的reasoning_content
+	reasoning string
+	// signature 思考块签名
+	signature string
+	// text 正文文本,不含思考内容,用于校验结构化输出
+	text string
+	// responseFormat 要求JSON输出时的结构化输出格式
+	responseFormat *model.OpenAIResponseFormat
+	// jsonToolName/jsonToolIndex 提供方模拟结构化输出时强制调用的工具,其参数作为正文输出
+	jsonToolName  string
+	jsonToolIndex int
+	// usage 上游报告的用量,未报告时为nil
+	usage        *provider.Usage
+	finishReason string
+	// finished 流式结束块是否已发送
+	finished bool
+	// index 请求n>1时该响应对应的choice序号
+	index int
+	// err 解析上游事件时的错误,包括上游在流中返回的错误
+	err error
+}
 
-		if eventType == "message_stop" {
-			handleMessageResult(c, responseId, model, jsonData)
-			return "", false
-		}
+func newChatResponseState(reasoningFormat string) *chatResponseState {
+	return &chatResponseState{reasoningFormat: reasoningFormat, toolState: newToolCallState(), jsonToolIndex: -1}
+}
 
-		var text string
-		deltaMap, ok := event["delta"].(map[string]interface{})
-		if ok {
-			thinking, ok := deltaMap["thinking"].(string)
-			if ok {
-				if !*thinkStartType {
-					text = "<think>\n\n" + thinking
-					*thinkStartType = true
-					*thinkEndType = false
-				} else {
-					text = thinking
-				}
-			}
+// setResponseFormat 记录结构化输出要求,提供方以工具调用模拟时记录工具名称
+func (s *chatResponseState) setResponseFormat(responseFormat *model.OpenAIResponseFormat, p provider.Provider) {
+	if !responseFormat.IsJSON() {
+		return
+	}
+	s.responseFormat = responseFormat
+	if emulator, ok := p.(provider.ResponseFormatEmulator); ok {
+		s.jsonToolName = emulator.ResponseFormatTool()
+	}
+}
 
-			deltaText, ok := deltaMap["text"].(string)
-			if ok {
-				if *thinkStartType && !*thinkEndType {
-					text = "</think>\n\n" + deltaText
-					*thinkStartType = false
-					*thinkEndType = true
-				} else {
-					text = deltaText
-				}
-			}
-			if err := handleDelta(c, text, responseId, model, jsonData); err != nil {
-				logger.Errorf(c.Request.Context(), "handleDelta err: %v", err)
-				c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
-				return "", false
-			}
-			return text, true
-		}
-		return "", true
-	} else if modelInfo.Source == "openrouter" {
-		// 检查是否有choices数组
-		choices, ok := event["choices"].([]interface{})
-		if !ok || len(choices) == 0 {
-			// 如果有usage信息但没有内容，可能是最后一个消息
-			if _, hasUsage := event["usage"]; hasUsage {
-				return "", false
-			}
-			logger.Errorf(c.Request.Context(), "Invalid openrouter response format: choices not found or empty")
-			return "", false
-		}
+// startJSONTool 判断工具调用是否为模拟结构化输出的工具
+func (s *chatResponseState) startJSONTool(event provider.Event) bool {
+	if s.jsonToolName == "" || event.ToolName != s.jsonToolName {
+		return false
+	}
+	s.jsonToolIndex = event.ToolIndex
+	return true
+}
 
-		// 获取第一个choice
-		choice, ok := choices[0].(map[string]interface{})
-		if !ok {
-			logger.Errorf(c.Request.Context(), "Invalid choice format in openrouter response")
-			return "", false
-		}
+func (s *chatResponseState) isJSONToolArguments(event provider.Event) bool {
+	return s.jsonToolIndex >= 0 && event.ToolIndex == s.jsonToolIndex
+}
 
-		// 获取delta内容
-		delta, ok := choice["delta"].(map[string]interface{})
-		if !ok {
-			logger.Errorf(c.Request.Context(), "Delta not found in openrouter response")
-			return "", false
-		}
+// validateOutput 校验正文是否符合response_format,模型改为调用工具时不校验
+func (s *chatResponseState) validateOutput() error {
+	if s.responseFormat == nil || len(s.toolState.toolCalls()) > 0 {
+		return nil
+	}
+	return s.responseFormat.ValidateOutput(s.text)
+}
 
-		// 获取内容文本
-		content, ok := delta["content"].(string)
-		if !ok {
-			// 没有内容，可能是其他类型的更新
-			return "", true
+// accumulate 将事件累积到响应状态,返回本次新增的正文、思考内容与签名。
+// 工具调用只累积不返回,适用于工具调用需完整下发的接口
+func (s *chatResponseState) accumulate(event provider.Event) (text, reasoning, signature string) {
+	switch event.Type {
+	case provider.EventThinking, provider.EventText:
+		text, reasoning = s.splitDelta(event)
+		s.content += text
+		s.reasoning += reasoning
+		if event.Type == provider.EventText {
+			s.text += event.Text
 		}
-
-		// 处理文本内容
-		if err := handleDelta(c, content, responseId, model, jsonData); err != nil {
-			logger.Errorf(c.Request.Context(), "handleDelta err: %v", err)
-			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
-			return "", false
+	case provider.EventThinkingSignature:
+		if s.keepSignature() {
+			signature = event.Text
+			s.signature += signature
 		}
-
-		// 检查是否完成 - 在处理内容后检查
-		finishReason, hasFinishReason := choice["finish_reason"]
-		if hasFinishReason && finishReason != nil && finishReason != "" {
-			// 处理完成的消息
-			handleMessageResult(c, responseId, model, jsonData)
-			return content, false // 返回内容但标记为结束
+	case provider.EventToolCallStart:
+		text = closeThinkTag(&s.thinkStartType, &s.thinkEndType)
+		s.content += text
+		if !s.startJSONTool(event) {
+			s.toolState.start(event)
 		}
-
-		return content, true
+	case provider.EventToolCallArguments:
+		if s.isJSONToolArguments(event) {
+			text = event.Text
+			s.content += text
+			s.text += text
+			break
+		}
+		s.toolState.appendArguments(event)
+	case provider.EventUsage:
+		s.usage = event.Usage
+	case provider.EventFinish:
+		s.finishReason = event.FinishReason
 	}
-	return "", false
+	return text, reasoning, signature
 }
 
-func processNoStreamData(c *gin.Context, data string, modelInfo common.ModelInfo, thinkStartType *bool, thinkEndType *bool) (string, bool) {
-	data = strings.TrimSpace(data)
-	data = strings.TrimPrefix(data, "data: ")
-
-	// 处理[DONE]标记
-	if data == "[DONE]" {
-		return "", false
+// openAIFinishReason 返回上游报告的结束原因,上游未报告时按是否调用工具推断。
+// 模拟结构化输出的工具调用已作为正文输出,此时结束原因为stop
+func (s *chatResponseState) openAIFinishReason() string {
+	if s.finishReason == "" || s.finishReason == provider.FinishReasonToolCalls {
+		return s.toolState.finishReason()
 	}
+	return s.finishReason
+}
 
-	var event map[string]interface{}
-	if err := json.Unmarshal([]byte(data), &event); err != nil {
-		logger.Errorf(c.Request.Context(), "Failed to unmarshal event: %v", err)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
-		return "", false
+// reasoningFormat 获取思考内容输出格式,请求参数优先于API-KEY及全局配置
+func reasoningFormat(c *gin.Context, openAIReq model.OpenAIChatCompletionRequest) string {
+	if openAIReq.ReasoningFormat != "" {
+		return openAIReq.ReasoningFormat
 	}
+	return config.GetReasoningFormat(c.GetString(helper.ApiKeyKey))
+}
 
-	if modelInfo.Source == "claude" {
-		eventType, ok := event["type"]
-		if !ok {
-			logger.Errorf(c.Request.Context(), "Event type not found")
-			return "", false
-		}
+// keepSignature 隐藏思考内容时无法回传思考块,签名不再下发
+func (s *chatResponseState) keepSignature() bool {
+	return s.reasoningFormat != config.ReasoningFormatHidden
+}
 
-		if eventType == "message_stop" {
-			return "", false
+// splitDelta 按思考内容输出格式拆分增量,返回content与reasoning_content
+func (s *chatResponseState) splitDelta(event provider.Event) (string, string) {
+	switch s.reasoningFormat {
+	case config.ReasoningFormatThink:
+		return thinkTagText(event, &s.thinkStartType, &s.thinkEndType), ""
+	case config.ReasoningFormatHidden:
+		if event.Type == provider.EventThinking {
+			return "", ""
 		}
-
-		var text string
-		deltaMap, ok := event["delta"].(map[string]interface{})
-		if ok {
-			thinking, ok := deltaMap["thinking"].(string)
-			if ok {
-				if !*thinkStartType {
-					text = "<think>\n\n" + thinking
-					*thinkStartType = true
-					*thinkEndType = false
-				} else {
-					text = thinking
-				}
-			}
-
-			deltaText, ok := deltaMap["text"].(string)
-			if ok {
-				if *thinkStartType && !*thinkEndType {
-					text = "</think>\n\n" + deltaText
-					*thinkStartType = false
-					*thinkEndType = true
-				} else {
-					text = deltaText
-				}
-			}
-			return text, true
-		}
-		return "", true
-	} else if modelInfo.Source == "openrouter" {
-		// 检查是否有choices数组
-		choices, ok := event["choices"].([]interface{})
-		if !ok || len(choices) == 0 {
-			// 如果有usage信息但没有内容，可能是最后一个消息
-			if _, hasUsage := event["usage"]; hasUsage {
-				return "", false
-			}
-			logger.Errorf(c.Request.Context(), "Invalid openrouter response format: choices not found or empty")
-			return "", false
+	default:
+		if event.Type == provider.EventThinking {
+			return "", event.Text
 		}
+	}
+	return event.Text, ""
+}
 
-		// 获取第一个choice
-		choice, ok := choices[0].(map[string]interface{})
-		if !ok {
-			logger.Errorf(c.Request.Context(), "Invalid choice format in openrouter response")
-			return "", false
+// openAIUsage 优先使用上游报告的用量,上游未报告时按请求体与输出内容估算
+func (s *chatResponseState) openAIUsage(jsonData []byte, modelName string) *model.OpenAIUsage {
+	if s.usage == nil {
+		completion := s.content
+		for _, toolCall := range s.toolState.toolCalls() {
+			completion += toolCall.Function.Name + toolCall.Function.Arguments
 		}
+		return estimateUsage(jsonData, modelName, s.reasoning, completion)
+	}
+	return toOpenAIUsage(*s.usage)
+}
 
-		// 获取delta内容
-		delta, ok := choice["delta"].(map[string]interface{})
-		if !ok {
-			logger.Errorf(c.Request.Context(), "Delta not found in openrouter response")
-			return "", false
-		}
+// estimateUsage 按请求体与输出内容估算用量,reasoning为输出中的思考内容
+func estimateUsage(jsonData []byte, modelName, reasoning, completion string) *model.OpenAIUsage {
+	promptTokens := model.CountTokenText(string(jsonData), modelName)
+	reasoningTokens := 0
+	if reasoning != "" {
+		reasoningTokens = model.CountTokenText(reasoning, modelName)
+	}
+	completionTokens := reasoningTokens + model.CountTokenText(completion, modelName)
+	return toOpenAIUsage(provider.Usage{
+		PromptTokens:     promptTokens,
+		CompletionTokens: completionTokens,
+		ReasoningTokens:  reasoningTokens,
+	})
+}
 
-		// 获取内容文本
-		content, ok := delta["content"].(string)
-		if !ok {
-			// 没有内容，可能是其他类型的更新
-			return "", true
+// addUsage 累加多个choice的用量
+func addUsage(total, usage *model.OpenAIUsage) *model.OpenAIUsage {
+	if total == nil {
+		return usage
+	}
+	if usage == nil {
+		return total
+	}
+	sum := &model.OpenAIUsage{
+		PromptTokens:     total.PromptTokens + usage.PromptTokens,
+		CompletionTokens: total.CompletionTokens + usage.CompletionTokens,
+		TotalTokens:      total.TotalTokens + usage.TotalTokens,
+	}
+	if total.PromptTokensDetails != nil || usage.PromptTokensDetails != nil {
+		sum.PromptTokensDetails = &model.OpenAIPromptTokensDetails{}
+		for _, u := range []*model.OpenAIUsage{total, usage} {
+			if u.PromptTokensDetails != nil {
+				sum.PromptTokensDetails.CachedTokens += u.PromptTokensDetails.CachedTokens
+			}
 		}
-
-		// 检查是否完成 - 在处理内容后检查
-		finishReason, hasFinishReason := choice["finish_reason"]
-		if hasFinishReason && finishReason != nil && finishReason != "" {
-			// 处理完成的消息
-			return content, false // 返回内容但标记为结束
+	}
+	if total.CompletionTokensDetails != nil || usage.CompletionTokensDetails != nil {
+		sum.CompletionTokensDetails = &model.OpenAICompletionTokensDetails{}
+		for _, u := range []*model.OpenAIUsage{total, usage} {
+			if u.CompletionTokensDetails != nil {
+				sum.CompletionTokensDetails.ReasoningTokens += u.CompletionTokensDetails.ReasoningTokens
+			}
 		}
-
-		return content, true
 	}
-	return "", false
-
+	return sum
 }
 
-// OpenaiModels @Summary OpenAI模型列表接口
-// @Description OpenAI模型列表接口
-// @Tags OpenAI
-// @Accept json
-// @Produce json
-// @Param Authorization header string true "Authorization API-KEY"
-// @Success 200 {object} common.ResponseResult{data=model.OpenaiModelListResponse} "成功"
-// @Router /v1/models [get]
-func OpenaiModels(c *gin.Context) {
-	var modelsResp []string
+// toOpenAIUsage 将上游用量转换为OpenAI格式
+func toOpenAIUsage(usage provider.Usage) *model.OpenAIUsage {
+	openAIUsage := &model.OpenAIUsage{
+		PromptTokens:     usage.PromptTokens,
+		CompletionTokens: usage.CompletionTokens,
+		TotalTokens:      usage.PromptTokens + usage.CompletionTokens,
+	}
+	if usage.CachedTokens > 0 {
+		openAIUsage.PromptTokensDetails = &model.OpenAIPromptTokensDetails{CachedTokens: usage.CachedTokens}
+	}
+	if usage.ReasoningTokens > 0 {
+		openAIUsage.CompletionTokensDetails = &model.OpenAICompletionTokensDetails{ReasoningTokens: usage.ReasoningTokens}
+	}
+	return openAIUsage
+}
 
-	modelsResp = lo.Union(common.GetModelList())
+// toolCallState 记录一次响应中的工具调用
+type toolCallState struct {
+	indexes map[int]int
+	calls   []model.OpenAIToolCall
+}
 
-	var openaiModelListResponse model.OpenaiModelListResponse
-	var openaiModelResponse []model.OpenaiModelResponse
-	openaiModelListResponse.Object = "list"
+func newToolCallState() *toolCallState {
+	return &toolCallState{indexes: make(map[int]int)}
+}
 
-	for _, modelResp := range modelsResp {
-		openaiModelResponse = append(openaiModelResponse, model.OpenaiModelResponse{
-			ID:     modelResp,
-			Object: "model",
-		})
+// start 登记一次新的工具调用,返回需要下发的tool_calls增量
+func (s *toolCallState) start(event provider.Event) model.OpenAIToolCall {
+	index := len(s.calls)
+	s.indexes[event.ToolIndex] = index
+	s.calls = append(s.calls, model.OpenAIToolCall{
+		ID:   event.ToolCallID,
+		Type: "function",
+		Function: model.OpenAIFunctionCall{
+			Name: event.ToolName,
+		},
+	})
+	return model.OpenAIToolCall{
+		Index: &index,
+		ID:    event.ToolCallID,
+		Type:  "function",
+		Function: model.OpenAIFunctionCall{
+			Name: event.ToolName,
+		},
 	}
-	openaiModelListResponse.Data = openaiModelResponse
-	c.JSON(http.StatusOK, openaiModelListResponse)
-	return
 }
 
-func safeClose(client cycletls.CycleTLS) {
-	if client.ReqChan != nil {
-		close(client.ReqChan)
+// appendArguments 追加工具调用的参数片段,返回需要下发的tool_calls增量
+func (s *toolCallState) appendArguments(event provider.Event) (model.OpenAIToolCall, bool) {
+	index, ok := s.indexes[event.ToolIndex]
+	if !ok {
+		return model.OpenAIToolCall{}, false
 	}
-	if client.RespChan != nil {
-		close(client.RespChan)
+	s.calls[index].Function.Arguments += event.Text
+	return model.OpenAIToolCall{
+		Index: &index,
+		Function: model.OpenAIFunctionCall{
+			Arguments: event.Text,
+		},
+	}, true
+}
+
+// toolCalls 返回非流式响应使用的完整工具调用
+func (s *toolCallState) toolCalls() []model.OpenAIToolCall {
+	return s.calls
+}
+
+func (s *toolCallState) finishReason() string {
+	if len(s.calls) > 0 {
+		return provider.FinishReasonToolCalls
 	}
+	return provider.FinishReasonStop
 }
 
 //
-//func processUrl(c *gin.Context, client cycletls.CycleTLS, chatId, cookie string, url string) (string, error) {
+//func processUrl(c *gin.Context, chatId, cookie string, url string) (string, error) {
 //	// 判断是否为URL
 //	if strings.HasPrefix(url, "http://") || strings.HasPrefix(url, "https://") {
 //		// 下载文件
@@ -785,14 +982,14 @@ func safeClose(client cycletls.CycleTLS) {
 //
 //		base64Str := base64.StdEncoding.EncodeToString(bytes)
 //
-//		finalUrl, err := processBytes(c, client, chatId, cookie, base64Str)
+//		finalUrl, err := processBytes(c, chatId, cookie, base64Str)
 //		if err != nil {
 //			logger.Errorf(c.Request.Context(), fmt.Sprintf("processBytes err  %v\n", err))
 //			return "", fmt.Errorf("processBytes err  %v\n", err)
 //		}
 //		return finalUrl, nil
 //	} else {
-//		finalUrl, err := processBytes(c, client, chatId, cookie, url)
+//		finalUrl, err := processBytes(c, chatId, cookie, url)
 //		if err != nil {
 //			logger.Errorf(c.Request.Context(), fmt.Sprintf("processBytes err  %v\n", err))
 //			return "", fmt.Errorf("processBytes err  %v\n", err)
@@ -811,7 +1008,7 @@ func safeClose(client cycletls.CycleTLS) {
 //	return io.ReadAll(resp.Body)
 //}
 //
-//func processBytes(c *gin.Context, client cycletls.CycleTLS, chatId, cookie string, base64Str string) (string, error) {
+//func processBytes(c *gin.Context, chatId, cookie string, base64Str string) (string, error) {
 //	// 检查类型
 //	fileType := common.DetectFileType(base64Str)
 //	if !fileType.IsValid {
@@ -0,0 +1,234 @@
+package controller
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"kilo2api/common/config"
+	"kilo2api/model"
+	"kilo2api/provider"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/pkoukk/tiktoken-go"
+
+	_ "kilo2api/provider/claude"
+	_ "kilo2api/provider/openrouter"
+)
+
+// byteBpeLoader 按字节编码的离线词表,测试时不下载tiktoken词表
+type byteBpeLoader struct{}
+
+func (byteBpeLoader) LoadTiktokenBpe(string) (map[string]int, error) {
+	ranks := make(map[string]int, 256)
+	for i := 0; i < 256; i++ {
+		ranks[string([]byte{byte(i)})] = i
+	}
+	return ranks, nil
+}
+
+var initEncodersOnce sync.Once
+
+func initTestEncoders() {
+	initEncodersOnce.Do(func() {
+		tiktoken.SetBpeLoader(byteBpeLoader{})
+		model.InitTokenEncoders()
+	})
+}
+
+// upstreamProvider 将请求发往本地上游替身的提供方,其余行为与被替换的提供方一致
+type upstreamProvider struct {
+	provider.Provider
+	endpoint string
+}
+
+func (p upstreamProvider) Endpoint() string {
+	return p.endpoint
+}
+
+// fakeUpstream 以本地上游替身替换指定提供方,上游依次返回events中的SSE数据后关闭连接
+func fakeUpstream(t *testing.T, source string, events ...string) {
+	t.Helper()
+	initTestEncoders()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		for _, event := range events {
+			fmt.Fprintf(w, "data: %s\n\n", event)
+		}
+	}))
+	t.Cleanup(server.Close)
+
+	original, ok := provider.Get(source)
+	if !ok {
+		t.Fatalf("provider %s not registered", source)
+	}
+	provider.Register(upstreamProvider{Provider: original, endpoint: server.URL})
+	t.Cleanup(func() { provider.Register(original) })
+
+	cookies := config.KLCookies
+	config.KLCookies = []string{"test-token"}
+	t.Cleanup(func() { config.KLCookies = cookies })
+}
+
+// serve 直接调用handler处理请求
+func serve(handler gin.HandlerFunc, path, body string) *httptest.ResponseRecorder {
+	gin.SetMode(gin.TestMode)
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest(http.MethodPost, path, strings.NewReader(body))
+	c.Request.Header.Set("Content-Type", "application/json")
+	handler(c)
+	return w
+}
+
+// sseData 返回响应中全部SSE事件的data
+func sseData(t *testing.T, body string) []string {
+	t.Helper()
+	var data []string
+	scanner := bufio.NewScanner(strings.NewReader(body))
+	for scanner.Scan() {
+		if line, ok := strings.CutPrefix(scanner.Text(), "data:"); ok {
+			data = append(data, strings.TrimSpace(line))
+		}
+	}
+	return data
+}
+
+func decodeJSON(t *testing.T, s string) map[string]interface{} {
+	t.Helper()
+	var value map[string]interface{}
+	if err := json.Unmarshal([]byte(s), &value); err != nil {
+		t.Fatalf("decode %s: %v", s, err)
+	}
+	return value
+}
+
+const openRouterToolCallStream = `{"choices":[{"index":0,"delta":{"role":"assistant","tool_calls":[{"index":0,"id":"call_1","type":"function","function":{"name":"get_weather","arguments":""}}]}}]}
+{"choices":[{"index":0,"delta":{"tool_calls":[{"index":0,"function":{"arguments":"{\"city\":"}}]}}]}
+{"choices":[{"index":0,"delta":{"tool_calls":[{"index":0,"function":{"arguments":"\"Paris\"}"}}]}}]}
+{"choices":[{"index":0,"delta":{},"finish_reason":"tool_calls"}]}
+{"choices":[],"usage":{"prompt_tokens":20,"completion_tokens":8}}
+[DONE]`
+
+func TestChatNonStreamToolCalls(t *testing.T) {
+	fakeUpstream(t, "openrouter", strings.Split(openRouterToolCallStream, "\n")...)
+
+	w := serve(ChatForOpenAI, "/v1/chat/completions", `{"model":"gpt-4.1","messages":[{"role":"user","content":"weather?"}]}`)
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d, body %s", w.Code, w.Body.String())
+	}
+	var resp model.OpenAIChatCompletionResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+	choice := resp.Choices[0]
+	if choice.FinishReason == nil || *choice.FinishReason != provider.FinishReasonToolCalls {
+		t.Fatalf("finish_reason = %v", choice.FinishReason)
+	}
+	toolCalls := choice.Message.ToolCalls
+	if len(toolCalls) != 1 || toolCalls[0].ID != "call_1" || toolCalls[0].Function.Name != "get_weather" ||
+		toolCalls[0].Function.Arguments != `{"city":"Paris"}` {
+		t.Fatalf("tool_calls = %+v", toolCalls)
+	}
+	if resp.Usage == nil || resp.Usage.PromptTokens != 20 || resp.Usage.CompletionTokens != 8 {
+		t.Fatalf("usage = %+v", resp.Usage)
+	}
+}
+
+func TestChatStreamToolCallDeltasAndUsage(t *testing.T) {
+	fakeUpstream(t, "openrouter", strings.Split(openRouterToolCallStream, "\n")...)
+
+	w := serve(ChatForOpenAI, "/v1/chat/completions",
+		`{"model":"gpt-4.1","stream":true,"stream_options":{"include_usage":true},"messages":[{"role":"user","content":"weather?"}]}`)
+	data := sseData(t, w.Body.String())
+	if len(data) < 3 || data[len(data)-1] != "[DONE]" {
+		t.Fatalf("unexpected stream %q", data)
+	}
+
+	var arguments, finishReason string
+	for _, d := range data[:len(data)-2] {
+		chunk := decodeJSON(t, d)
+		choices, _ := chunk["choices"].([]interface{})
+		if len(choices) != 1 {
+			t.Fatalf("chunk without choice before usage chunk: %s", d)
+		}
+		choice := choices[0].(map[string]interface{})
+		delta, _ := choice["delta"].(map[string]interface{})
+		toolCalls, _ := delta["tool_calls"].([]interface{})
+		for _, rawToolCall := range toolCalls {
+			toolCall := rawToolCall.(map[string]interface{})
+			if toolCall["index"] != float64(0) {
+				t.Fatalf("tool call index = %v", toolCall["index"])
+			}
+			function, _ := toolCall["function"].(map[string]interface{})
+			text, _ := function["arguments"].(string)
+			arguments += text
+		}
+		if reason, ok := choice["finish_reason"].(string); ok {
+			finishReason = reason
+		}
+	}
+	if arguments != `{"city":"Paris"}` || finishReason != provider.FinishReasonToolCalls {
+		t.Fatalf("arguments = %q, finish_reason = %q", arguments, finishReason)
+	}
+
+	// include_usage时[DONE]前下发choices为空的用量块
+	usageChunk := decodeJSON(t, data[len(data)-2])
+	if choices, ok := usageChunk["choices"].([]interface{}); !ok || len(choices) != 0 {
+		t.Fatalf("usage chunk choices = %v", usageChunk["choices"])
+	}
+	usage, _ := usageChunk["usage"].(map[string]interface{})
+	if usage["prompt_tokens"] != float64(20) || usage["completion_tokens"] != float64(8) {
+		t.Fatalf("usage = %v", usage)
+	}
+}
+
+func TestChatStreamWithoutIncludeUsage(t *testing.T) {
+	fakeUpstream(t, "openrouter", strings.Split(openRouterToolCallStream, "\n")...)
+
+	w := serve(ChatForOpenAI, "/v1/chat/completions",
+		`{"model":"gpt-4.1","stream":true,"messages":[{"role":"user","content":"weather?"}]}`)
+	for _, d := range sseData(t, w.Body.String()) {
+		if d != "[DONE]" && decodeJSON(t, d)["usage"] != nil {
+			t.Fatalf("unexpected usage chunk %s", d)
+		}
+	}
+}
+
+func TestChatTruncatedStream(t *testing.T) {
+	truncated := []string{`{"choices":[{"index":0,"delta":{"role":"assistant","content":"partial"},"finish_reason":null}]}`}
+
+	t.Run("non-stream", func(t *testing.T) {
+		fakeUpstream(t, "openrouter", truncated...)
+		w := serve(ChatForOpenAI, "/v1/chat/completions", `{"model":"gpt-4.1","messages":[{"role":"user","content":"hi"}]}`)
+		if w.Code != http.StatusBadGateway {
+			t.Fatalf("status = %d, body %s", w.Code, w.Body.String())
+		}
+		errorBody, _ := decodeJSON(t, w.Body.String())["error"].(map[string]interface{})
+		if errorBody["code"] != "upstream_error" {
+			t.Fatalf("error = %v", errorBody)
+		}
+	})
+
+	t.Run("stream", func(t *testing.T) {
+		fakeUpstream(t, "openrouter", truncated...)
+		w := serve(ChatForOpenAI, "/v1/chat/completions", `{"model":"gpt-4.1","stream":true,"messages":[{"role":"user","content":"hi"}]}`)
+		data := sseData(t, w.Body.String())
+		if len(data) < 2 || data[len(data)-1] != "[DONE]" {
+			t.Fatalf("unexpected stream %q", data)
+		}
+		// 截断的流以错误块结束,而不是结束块
+		if decodeJSON(t, data[len(data)-2])["error"] == nil {
+			t.Fatalf("last chunk is not an error: %s", data[len(data)-2])
+		}
+		for _, d := range data[:len(data)-2] {
+			if strings.Contains(d, `"finish_reason":"stop"`) {
+				t.Fatalf("truncated stream reported stop: %s", d)
+			}
+		}
+	})
+}
@@ -0,0 +1,256 @@
+package controller
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"kilo2api/common/config"
+	logger "kilo2api/common/loggger"
+	"kilo2api/cycletls"
+	"kilo2api/model"
+	"kilo2api/provider"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const completionIDFormat = "cmpl-%s"
+
+// CompletionsForOpenAI @Summary OpenAI文本补全接口
+// @Description OpenAI旧版文本补全接口,prompt包装为对话请求后转发
+// @Tags OpenAI
+// @Accept json
+// @Produce json
+// @Param req body model.OpenAICompletionRequest true "OpenAI文本补全请求"
+// @Param Authorization header string true "Authorization API-KEY"
+// @Router /v1/completions [post]
+func CompletionsForOpenAI(c *gin.Context) {
+
+	var completionReq model.OpenAICompletionRequest
+	if err := c.ShouldBindJSON(&completionReq); err != nil {
+		logger.Errorf(c.Request.Context(), err.Error())
+		writeAPIError(c, bindError(err))
+		return
+	}
+
+	prompts, err := completionReq.Prompts()
+	if err != nil {
+		writeAPIError(c, invalidRequestError(model.OpenAIError{
+			Message: err.Error(),
+			Type:    "invalid_request_error",
+			Param:   "prompt",
+			Code:    "invalid_prompt",
+		}))
+		return
+	}
+	// 每个prompt生成n个choice,choice序号为prompt序号*n+j
+	n := max(completionReq.N, 1)
+	if len(prompts)*n > maxChoices {
+		writeAPIError(c, invalidRequestError(model.OpenAIError{
+			Message: fmt.Sprintf("Too many choices requested: %d prompts x n=%d exceeds limit %d", len(prompts), n, maxChoices),
+			Type:    "invalid_request_error",
+			Param:   "n",
+			Code:    "invalid_n",
+		}))
+		return
+	}
+
+	var p provider.Provider
+	jsonData := make([][]byte, len(prompts))
+	for i, prompt := range prompts {
+		openAIReq := model.ConvertCompletionToOpenAIRequest(completionReq, prompt)
+		// 补全结果只包含正文,不输出思考内容
+		openAIReq.ReasoningFormat = config.ReasoningFormatHidden
+		modelInfo, chatProvider, ok := checkChatRequest(c, &openAIReq)
+		if !ok {
+			return
+		}
+		p = chatProvider
+		requestBody, err := createRequestBody(c, p, &openAIReq, modelInfo)
+		if err != nil {
+			writeError(c, err)
+			return
+		}
+		if jsonData[i], err = json.Marshal(requestBody); err != nil {
+			writeError(c, err)
+			return
+		}
+	}
+
+	if completionReq.Stream {
+		handleCompletionStreamRequest(c, p, completionReq, prompts, jsonData)
+	} else {
+		handleCompletionNonStreamRequest(c, p, completionReq, prompts, jsonData)
+	}
+}
+
+func handleCompletionNonStreamRequest(c *gin.Context, p provider.Provider, completionReq model.OpenAICompletionRequest, prompts []string, jsonData [][]byte) {
+	n := max(completionReq.N, 1)
+	states := make([]*chatResponseState, len(prompts)*n)
+	errs := fanOutChoices(len(states), func(index int) error {
+		state := newChatResponseState(config.ReasoningFormatHidden)
+		states[index] = state
+		parser := p.NewStreamParser()
+		err := relayChatRequest(c, p, jsonData[index/n], func(event cycletls.SSEEvent) bool {
+			return processNoStreamData(c, event, parser, state)
+		})
+		if err == nil {
+			err = state.err
+		}
+		return err
+	})
+	if err := streamError(c, errs, states); err != nil {
+		writeError(c, err)
+		return
+	}
+	if c.Writer.Written() {
+		return
+	}
+
+	var choices []model.OpenAICompletionChoice
+	var usage *model.OpenAIUsage
+	for index, state := range states {
+		text := state.content
+		if completionReq.Echo {
+			text = prompts[index/n] + text
+		}
+		finishReason := state.openAIFinishReason()
+		choices = append(choices, model.OpenAICompletionChoice{
+			Text:         text,
+			Index:        index,
+			FinishReason: &finishReason,
+		})
+		usage = addUsage(usage, state.openAIUsage(jsonData[index/n], completionReq.Model))
+	}
+
+	c.JSON(http.StatusOK, model.OpenAICompletionResponse{
+		ID:      fmt.Sprintf(completionIDFormat, time.Now().Format("20060102150405")),
+		Object:  "text_completion",
+		Created: time.Now().Unix(),
+		Model:   completionReq.Model,
+		Choices: choices,
+		Usage:   usage,
+	})
+}
+
+func handleCompletionStreamRequest(c *gin.Context, p provider.Provider, completionReq model.OpenAICompletionRequest, prompts []string, jsonData [][]byte) {
+	c.Header("Content-Type", "text/event-stream")
+	c.Header("Cache-Control", "no-cache")
+	c.Header("Connection", "keep-alive")
+
+	responseId := fmt.Sprintf(completionIDFormat, time.Now().Format("20060102150405"))
+
+	n := max(completionReq.N, 1)
+	states := make([]*chatResponseState, len(prompts)*n)
+	if len(states) > 1 {
+		c.Set(streamWriteLockKey, &sync.Mutex{})
+	}
+	errs := fanOutChoices(len(states), func(index int) error {
+		state := newChatResponseState(config.ReasoningFormatHidden)
+		state.index = index
+		states[index] = state
+		parser := p.NewStreamParser()
+		echoed := !completionReq.Echo
+		err := relayChatRequest(c, p, jsonData[index/n], func(event cycletls.SSEEvent) bool {
+			// 收到上游首个事件后再回显prompt,请求失败时仍可返回JSON错误
+			if !echoed {
+				echoed = true
+				if err := sendCompletionChunk(c, responseId, completionReq.Model, index, prompts[index/n], nil); err != nil {
+					return false
+				}
+			}
+			return processCompletionStreamData(c, event, responseId, completionReq.Model, parser, state)
+		})
+		if err == nil {
+			err = state.err
+		}
+		if err == nil && state.finishReason != "" && !state.finished {
+			state.finished = true
+			finishReason := state.openAIFinishReason()
+			sendCompletionChunk(c, responseId, completionReq.Model, index, "", &finishReason)
+		}
+		return err
+	})
+
+	var usage *model.OpenAIUsage
+	if err := streamError(c, errs, states); err != nil {
+		writeError(c, err)
+		return
+	}
+	for index, state := range states {
+		if state.finished {
+			usage = addUsage(usage, state.openAIUsage(jsonData[index/n], completionReq.Model))
+		}
+	}
+	if c.Request.Context().Err() != nil {
+		return
+	}
+	if usage != nil && completionReq.StreamOptions != nil && completionReq.StreamOptions.IncludeUsage {
+		if err := sendSSEvent(c, model.OpenAICompletionResponse{
+			ID:      responseId,
+			Object:  "text_completion",
+			Created: time.Now().Unix(),
+			Model:   completionReq.Model,
+			Choices: []model.OpenAICompletionChoice{},
+			Usage:   usage,
+		}); err != nil {
+			logger.Warnf(c.Request.Context(), "sendSSEvent err: %v", err)
+		}
+	}
+	c.SSEvent("", " [DONE]")
+	c.Writer.Flush()
+}
+
+// processCompletionStreamData 处理补全接口的流式数据,仅下发正文,返回bool表示是否继续处理
+func processCompletionStreamData(c *gin.Context, sseEvent cycletls.SSEEvent, responseId, modelName string, parser provider.StreamParser, state *chatResponseState) bool {
+	events, done, err := parser.Parse(sseEvent)
+	if err != nil {
+		logger.Errorf(c.Request.Context(), "Failed to parse event: %v", err)
+		state.err = err
+		return false
+	}
+
+	for _, event := range events {
+		switch event.Type {
+		case provider.EventThinking, provider.EventText:
+			text, _ := state.splitDelta(event)
+			if text == "" {
+				continue
+			}
+			if err := sendCompletionChunk(c, responseId, modelName, state.index, text, nil); err != nil {
+				logger.Errorf(c.Request.Context(), "sendCompletionChunk err: %v", err)
+				return false
+			}
+			state.content += text
+		case provider.EventUsage:
+			state.usage = event.Usage
+		case provider.EventFinish:
+			state.finishReason = event.FinishReason
+		}
+	}
+	if done && !state.finished {
+		state.finished = true
+		finishReason := state.openAIFinishReason()
+		if err := sendCompletionChunk(c, responseId, modelName, state.index, "", &finishReason); err != nil {
+			logger.Warnf(c.Request.Context(), "sendCompletionChunk err: %v", err)
+		}
+	}
+	return !done
+}
+
+// sendCompletionChunk 下发text_completion流式块
+func sendCompletionChunk(c *gin.Context, responseId, modelName string, index int, text string, finishReason *string) error {
+	return sendSSEvent(c, model.OpenAICompletionResponse{
+		ID:      responseId,
+		Object:  "text_completion",
+		Created: time.Now().Unix(),
+		Model:   modelName,
+		Choices: []model.OpenAICompletionChoice{
+			{
+				Text:         text,
+				Index:        index,
+				FinishReason: finishReason,
+			},
+		},
+	})
+}
@@ -0,0 +1,204 @@
+package controller
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	logger "kilo2api/common/loggger"
+	"kilo2api/cycletls"
+	"kilo2api/model"
+	"kilo2api/provider"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// defaultRetryAfter 上游未指定等待时间时,429/503响应的Retry-After
+const defaultRetryAfter = 30 * time.Second
+
+var (
+	// errCookiesExhausted cookie池中没有可用的cookie
+	errCookiesExhausted = errors.New("All cookies are temporarily unavailable.")
+	// errIncompleteResponse 上游流在返回结束原因前中断
+	errIncompleteResponse = errors.New("Upstream response ended unexpectedly")
+)
+
+// apiError 接口错误,包含HTTP状态码与OpenAI格式的错误信息
+type apiError struct {
+	status     int
+	error      model.OpenAIError
+	retryAfter time.Duration
+}
+
+// newAPIError 将错误映射为HTTP状态码与OpenAI SDK可识别的type/code/param
+func newAPIError(err error) apiError {
+	var contextErr *model.ContextLengthError
+	var upstreamErr *provider.UpstreamError
+	var maxBytesErr *http.MaxBytesError
+	var requestErr *cycletls.RequestError
+	var apiErr apiError
+	switch {
+	case errors.As(err, &contextErr):
+		apiErr = apiError{status: http.StatusBadRequest, error: model.OpenAIError{Type: "invalid_request_error", Param: "messages", Code: "context_length_exceeded"}}
+	case errors.As(err, &upstreamErr):
+		apiErr = upstreamAPIError(upstreamErr)
+	case errors.As(err, &requestErr):
+		apiErr = upstreamRequestAPIError(requestErr)
+	case errors.As(err, &maxBytesErr):
+		apiErr = apiError{status: http.StatusRequestEntityTooLarge, error: model.OpenAIError{Type: "invalid_request_error", Code: "request_too_large"}}
+	case errors.Is(err, errCookiesExhausted):
+		apiErr = apiError{status: http.StatusServiceUnavailable, error: model.OpenAIError{Type: "server_error", Code: "service_unavailable"}}
+	case errors.Is(err, context.DeadlineExceeded):
+		apiErr = apiError{status: http.StatusGatewayTimeout, error: model.OpenAIError{Type: "server_error", Code: "timeout"}}
+	case errors.Is(err, errIncompleteResponse):
+		apiErr = apiError{status: http.StatusBadGateway, error: model.OpenAIError{Type: "server_error", Code: "upstream_error"}}
+	default:
+		apiErr = apiError{status: http.StatusInternalServerError, error: model.OpenAIError{Type: "server_error", Code: "internal_error"}}
+	}
+	if apiErr.error.Message == "" {
+		apiErr.error.Message = err.Error()
+	}
+	if apiErr.retryAfter == 0 && (apiErr.status == http.StatusTooManyRequests || apiErr.status == http.StatusServiceUnavailable) {
+		apiErr.retryAfter = defaultRetryAfter
+	}
+	return apiErr
+}
+
+// upstreamAPIError 上游错误的映射。上游凭证失效/被拦截属于网关问题,返回502而非401/403,避免客户端误判自身API-KEY无效
+func upstreamAPIError(upstreamErr *provider.UpstreamError) apiError {
+	apiErr := apiError{
+		status:     http.StatusBadGateway,
+		error:      model.OpenAIError{Message: upstreamErr.Message, Type: "server_error", Code: "upstream_error"},
+		retryAfter: upstreamErr.RetryAfter,
+	}
+	switch upstreamErr.Kind {
+	case provider.ErrorRateLimit:
+		apiErr.status = http.StatusTooManyRequests
+		apiErr.error.Type, apiErr.error.Code = "rate_limit_error", "rate_limit_exceeded"
+	case provider.ErrorQuota:
+		apiErr.status = http.StatusTooManyRequests
+		apiErr.error.Type, apiErr.error.Code = "insufficient_quota", "insufficient_quota"
+	case provider.ErrorOverloaded:
+		apiErr.status = http.StatusServiceUnavailable
+		apiErr.error.Code = "overloaded"
+	case provider.ErrorContextLength:
+		apiErr.status = http.StatusBadRequest
+		apiErr.error.Type, apiErr.error.Param, apiErr.error.Code = "invalid_request_error", "messages", "context_length_exceeded"
+	case provider.ErrorContentFilter:
+		apiErr.status = http.StatusBadRequest
+		apiErr.error.Type, apiErr.error.Code = "invalid_request_error", "content_policy_violation"
+	case provider.ErrorInvalidRequest:
+		apiErr.status = http.StatusBadRequest
+		if upstreamErr.Status == http.StatusNotFound || upstreamErr.Status == http.StatusRequestEntityTooLarge {
+			apiErr.status = upstreamErr.Status
+		}
+		apiErr.error.Type, apiErr.error.Code = "invalid_request_error", "invalid_request"
+	case provider.ErrorAuth, provider.ErrorForbidden, provider.ErrorCloudflare:
+		apiErr.error.Code = "upstream_" + upstreamErr.Kind.String()
+	case provider.ErrorServer:
+		switch upstreamErr.Status {
+		case http.StatusServiceUnavailable:
+			apiErr.status = http.StatusServiceUnavailable
+			apiErr.error.Code = "service_unavailable"
+		case http.StatusGatewayTimeout, http.StatusRequestTimeout:
+			apiErr.status = http.StatusGatewayTimeout
+			apiErr.error.Code = "timeout"
+		}
+	}
+	return apiErr
+}
+
+// upstreamRequestAPIError 上游请求无法构造(代理或地址配置错误)。错误详情可能包含代理凭证,只记录日志不返回给客户端
+func upstreamRequestAPIError(requestErr *cycletls.RequestError) apiError {
+	message := "Failed to build upstream request: invalid upstream URL configuration"
+	if requestErr.Op == cycletls.RequestOpProxy {
+		message = "Failed to build upstream request: invalid proxy configuration (PROXY_URL)"
+	}
+	return apiError{status: http.StatusBadGateway, error: model.OpenAIError{Message: message, Type: "server_error", Code: "upstream_request_error"}}
+}
+
+// invalidRequestError 请求校验失败,模型不存在时返回404
+func invalidRequestError(openAIError model.OpenAIError) apiError {
+	status := http.StatusBadRequest
+	if openAIError.Code == modelNotFoundCode {
+		status = http.StatusNotFound
+	}
+	return apiError{status: status, error: openAIError}
+}
+
+// requestError 请求参数错误
+func requestError(message string) apiError {
+	return invalidRequestError(model.OpenAIError{Message: message, Type: "invalid_request_error", Code: "invalid_request"})
+}
+
+// modelNotFoundError 请求的模型不存在
+func modelNotFoundError(modelName string) apiError {
+	return invalidRequestError(model.OpenAIError{
+		Message: fmt.Sprintf("Model %s not supported", modelName),
+		Type:    "invalid_request_error",
+		Param:   "model",
+		Code:    modelNotFoundCode,
+	})
+}
+
+// responseFormatAPIError 模型输出不符合response_format
+func responseFormatAPIError(err error) apiError {
+	return apiError{status: http.StatusBadGateway, error: responseFormatError(err).OpenAIError}
+}
+
+// bindError 请求体无法解析
+func bindError(err error) apiError {
+	var maxBytesErr *http.MaxBytesError
+	if errors.As(err, &maxBytesErr) {
+		return newAPIError(err)
+	}
+	return apiError{status: http.StatusBadRequest, error: model.OpenAIError{
+		Message: "Invalid request parameters: " + err.Error(),
+		Type:    "invalid_request_error",
+		Code:    "invalid_request",
+	}}
+}
+
+// writeError 以OpenAI格式返回错误
+func writeError(c *gin.Context, err error) {
+	writeAPIError(c, newAPIError(err))
+}
+
+// writeAPIError 统一的错误输出。流式响应已开始时状态码无法修改,改为下发SSE错误块并以[DONE]结束流
+func writeAPIError(c *gin.Context, apiErr apiError) {
+	logAPIError(c, apiErr)
+	errorResponse := model.OpenAIErrorResponse{OpenAIError: apiErr.error}
+	if c.Writer.Written() {
+		if err := sendSSEvent(c, errorResponse); err != nil {
+			logger.Warnf(c.Request.Context(), "sendSSEvent err: %v", err)
+		}
+		c.SSEvent("", " [DONE]")
+		c.Writer.Flush()
+		return
+	}
+	respondError(c, apiErr, errorResponse)
+}
+
+// logAPIError 记录服务端错误,客户端参数错误不记录
+func logAPIError(c *gin.Context, apiErr apiError) {
+	if apiErr.status >= http.StatusInternalServerError {
+		logger.Errorf(c.Request.Context(), "%d %s: %s", apiErr.status, apiErr.error.Code, apiErr.error.Message)
+	}
+}
+
+// respondError 响应尚未写入时以JSON返回错误,body为各协议自己的错误格式
+func respondError(c *gin.Context, apiErr apiError, body interface{}) {
+	setRetryAfter(c, apiErr.retryAfter)
+	// 流式请求可能已设置text/event-stream,错误响应需改回JSON
+	c.Header("Content-Type", "application/json; charset=utf-8")
+	c.JSON(apiErr.status, body)
+}
+
+// setRetryAfter 以秒为单位设置Retry-After,不足1秒向上取整
+func setRetryAfter(c *gin.Context, retryAfter time.Duration) {
+	if retryAfter > 0 {
+		c.Header("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+	}
+}
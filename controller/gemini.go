@@ -0,0 +1,372 @@
+package controller
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"kilo2api/common/config"
+	logger "kilo2api/common/loggger"
+	"kilo2api/cycletls"
+	"kilo2api/model"
+	"kilo2api/provider"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	geminiGenerateContent       = "generateContent"
+	geminiStreamGenerateContent = "streamGenerateContent"
+)
+
+// GenerateContentForGemini @Summary Gemini原生对话接口
+// @Description Gemini原生generateContent/streamGenerateContent接口,可调用任意已配置的模型
+// @Tags Gemini
+// @Accept json
+// @Produce json
+// @Param req body model.GeminiGenerateContentRequest true "Gemini对话请求"
+// @Param x-goog-api-key header string true "API-KEY"
+// @Router /v1beta/models/{model}:generateContent [post]
+// @Router /v1beta/models/{model}:streamGenerateContent [post]
+func GenerateContentForGemini(c *gin.Context) {
+
+	// 路径形如 /{model}:generateContent,模型名可能包含/
+	modelName, action, ok := strings.Cut(strings.TrimPrefix(c.Param("action"), "/"), ":")
+	if !ok || (action != geminiGenerateContent && action != geminiStreamGenerateContent) {
+		writeGeminiAPIError(c, nil, apiError{status: http.StatusNotFound, error: model.OpenAIError{
+			Message: fmt.Sprintf("Method %s not found", c.Param("action")),
+			Type:    "invalid_request_error",
+			Code:    "not_found",
+		}})
+		return
+	}
+	stream := action == geminiStreamGenerateContent
+
+	var geminiReq model.GeminiGenerateContentRequest
+	if err := c.ShouldBindJSON(&geminiReq); err != nil {
+		logger.Errorf(c.Request.Context(), err.Error())
+		writeGeminiAPIError(c, nil, bindError(err))
+		return
+	}
+
+	openAIReq, err := model.ConvertGeminiToOpenAIRequest(geminiReq, modelName, stream)
+	if err != nil {
+		writeGeminiAPIError(c, nil, requestError(err.Error()))
+		return
+	}
+	// 思考内容以thought part单独返回,includeThoughts为false时不返回
+	openAIReq.ReasoningFormat = config.ReasoningFormatHidden
+	if geminiReq.IncludeThoughts() {
+		openAIReq.ReasoningFormat = config.ReasoningFormatContent
+	}
+
+	modelInfo, p, openAIError := validateChatRequest(c, &openAIReq)
+	if openAIError != nil {
+		writeGeminiAPIError(c, nil, invalidRequestError(*openAIError))
+		return
+	}
+
+	requestBody, err := createRequestBody(c, p, &openAIReq, modelInfo)
+	if err != nil {
+		writeGeminiError(c, nil, err)
+		return
+	}
+	jsonData, err := json.Marshal(requestBody)
+	if err != nil {
+		writeGeminiError(c, nil, err)
+		return
+	}
+
+	if stream {
+		handleGeminiStreamRequest(c, p, openAIReq, jsonData)
+	} else {
+		handleGeminiNonStreamRequest(c, p, openAIReq, jsonData)
+	}
+}
+
+func handleGeminiNonStreamRequest(c *gin.Context, p provider.Provider, openAIReq model.OpenAIChatCompletionRequest, jsonData []byte) {
+	states := make([]*chatResponseState, choiceCount(openAIReq))
+	errs := fanOutChoices(len(states), func(index int) error {
+		state := newChatResponseState(openAIReq.ReasoningFormat)
+		state.setResponseFormat(openAIReq.ResponseFormat, p)
+		states[index] = state
+		parser := p.NewStreamParser()
+		err := relayChatRequest(c, p, jsonData, func(event cycletls.SSEEvent) bool {
+			return processNoStreamData(c, event, parser, state)
+		})
+		if err == nil {
+			err = state.err
+		}
+		return err
+	})
+	if err := streamError(c, errs, states); err != nil {
+		writeGeminiError(c, nil, err)
+		return
+	}
+	if c.Writer.Written() {
+		return
+	}
+
+	var candidates []model.GeminiCandidate
+	var usage *model.OpenAIUsage
+	for index, state := range states {
+		if err := state.validateOutput(); err != nil {
+			logger.Errorf(c.Request.Context(), "validateOutput err: %v", err)
+			writeGeminiAPIError(c, nil, responseFormatAPIError(err))
+			return
+		}
+		var parts []model.GeminiPart
+		if state.reasoning != "" || state.signature != "" {
+			parts = append(parts, model.GeminiPart{Text: state.reasoning, Thought: true, ThoughtSignature: state.signature})
+		}
+		if state.content != "" {
+			parts = append(parts, model.GeminiPart{Text: state.content})
+		}
+		parts = append(parts, geminiFunctionCallParts(state.toolState.toolCalls())...)
+		candidates = append(candidates, model.GeminiCandidate{
+			Content:      model.GeminiNativeContent{Role: "model", Parts: parts},
+			FinishReason: geminiFinishReason(state.openAIFinishReason()),
+			Index:        index,
+		})
+		usage = addUsage(usage, state.openAIUsage(jsonData, openAIReq.Model))
+	}
+
+	c.JSON(http.StatusOK, model.GeminiGenerateContentResponse{
+		Candidates:    candidates,
+		UsageMetadata: toGeminiUsage(usage),
+		ModelVersion:  openAIReq.Model,
+	})
+}
+
+func handleGeminiStreamRequest(c *gin.Context, p provider.Provider, openAIReq model.OpenAIChatCompletionRequest, jsonData []byte) {
+	// alt=sse时以SSE下发,否则与Google一致以JSON数组分块下发
+	writer := &geminiStreamWriter{sse: c.Query("alt") == "sse"}
+	if writer.sse {
+		c.Header("Content-Type", "text/event-stream")
+	} else {
+		c.Header("Content-Type", "application/json")
+	}
+	c.Header("Cache-Control", "no-cache")
+	c.Header("Connection", "keep-alive")
+
+	responseId := fmt.Sprintf("%d", time.Now().UnixNano())
+
+	states := make([]*chatResponseState, choiceCount(openAIReq))
+	errs := fanOutChoices(len(states), func(index int) error {
+		state := newChatResponseState(openAIReq.ReasoningFormat)
+		state.index = index
+		state.setResponseFormat(openAIReq.ResponseFormat, p)
+		states[index] = state
+		parser := p.NewStreamParser()
+		err := relayChatRequest(c, p, jsonData, func(event cycletls.SSEEvent) bool {
+			return processGeminiStreamData(c, writer, event, responseId, openAIReq.Model, parser, jsonData, state)
+		})
+		if err == nil {
+			err = state.err
+		}
+		if err == nil && state.finishReason != "" && !state.finished {
+			finishGeminiStream(c, writer, responseId, openAIReq.Model, jsonData, state)
+		}
+		return err
+	})
+
+	if err := streamError(c, errs, states); err != nil {
+		writeGeminiError(c, writer, err)
+		return
+	}
+	writer.close(c)
+}
+
+// processGeminiStreamData 处理流式数据并以Gemini格式下发,返回bool表示是否继续处理
+func processGeminiStreamData(c *gin.Context, writer *geminiStreamWriter, sseEvent cycletls.SSEEvent, responseId, modelName string, parser provider.StreamParser, jsonData []byte, state *chatResponseState) bool {
+	events, done, err := parser.Parse(sseEvent)
+	if err != nil {
+		logger.Errorf(c.Request.Context(), "Failed to parse event: %v", err)
+		state.err = err
+		return false
+	}
+
+	for _, event := range events {
+		// functionCall需完整下发,工具调用在结束时统一下发
+		var part model.GeminiPart
+		text, reasoning, signature := state.accumulate(event)
+		switch {
+		case reasoning != "":
+			part = model.GeminiPart{Text: reasoning, Thought: true}
+		case signature != "":
+			part = model.GeminiPart{Thought: true, ThoughtSignature: signature}
+		case text != "":
+			part = model.GeminiPart{Text: text}
+		default:
+			continue
+		}
+		if err := writer.send(c, geminiStreamChunk(responseId, modelName, state.index, []model.GeminiPart{part})); err != nil {
+			logger.Errorf(c.Request.Context(), "send gemini chunk err: %v", err)
+			return false
+		}
+	}
+	if done && !state.finished {
+		finishGeminiStream(c, writer, responseId, modelName, jsonData, state)
+	}
+	return !done
+}
+
+// finishGeminiStream 下发工具调用、结束原因与用量,结构化输出不符合要求时改为下发错误
+func finishGeminiStream(c *gin.Context, writer *geminiStreamWriter, responseId, modelName string, jsonData []byte, state *chatResponseState) {
+	state.finished = true
+	if err := state.validateOutput(); err != nil {
+		logger.Errorf(c.Request.Context(), "validateOutput err: %v", err)
+		writer.send(c, geminiErrorResponse(responseFormatAPIError(err)))
+		return
+	}
+	chunk := geminiStreamChunk(responseId, modelName, state.index, geminiFunctionCallParts(state.toolState.toolCalls()))
+	chunk.Candidates[0].FinishReason = geminiFinishReason(state.openAIFinishReason())
+	chunk.UsageMetadata = toGeminiUsage(state.openAIUsage(jsonData, modelName))
+	if err := writer.send(c, chunk); err != nil {
+		logger.Warnf(c.Request.Context(), "send gemini chunk err: %v", err)
+	}
+}
+
+func geminiStreamChunk(responseId, modelName string, index int, parts []model.GeminiPart) model.GeminiGenerateContentResponse {
+	if parts == nil {
+		parts = []model.GeminiPart{}
+	}
+	return model.GeminiGenerateContentResponse{
+		Candidates: []model.GeminiCandidate{{
+			Content: model.GeminiNativeContent{Role: "model", Parts: parts},
+			Index:   index,
+		}},
+		ModelVersion: modelName,
+		ResponseID:   responseId,
+	}
+}
+
+// geminiFunctionCallParts 将工具调用转换为functionCall part
+func geminiFunctionCallParts(toolCalls []model.OpenAIToolCall) []model.GeminiPart {
+	var parts []model.GeminiPart
+	for _, toolCall := range toolCalls {
+		args := map[string]interface{}{}
+		if toolCall.Function.Arguments != "" {
+			if err := json.Unmarshal([]byte(toolCall.Function.Arguments), &args); err != nil {
+				args = map[string]interface{}{"arguments": toolCall.Function.Arguments}
+			}
+		}
+		parts = append(parts, model.GeminiPart{FunctionCall: &model.GeminiFunctionCall{
+			ID:   toolCall.ID,
+			Name: toolCall.Function.Name,
+			Args: args,
+		}})
+	}
+	return parts
+}
+
+// geminiFinishReason 将OpenAI的finish_reason转换为Gemini的finishReason,工具调用同样为STOP
+func geminiFinishReason(finishReason string) string {
+	switch finishReason {
+	case provider.FinishReasonLength:
+		return "MAX_TOKENS"
+	case provider.FinishReasonContentFilter:
+		return "SAFETY"
+	}
+	return "STOP"
+}
+
+func toGeminiUsage(usage *model.OpenAIUsage) *model.GeminiUsageMetadata {
+	if usage == nil {
+		return nil
+	}
+	metadata := &model.GeminiUsageMetadata{
+		PromptTokenCount:     usage.PromptTokens,
+		CandidatesTokenCount: usage.CompletionTokens,
+		TotalTokenCount:      usage.TotalTokens,
+	}
+	if usage.PromptTokensDetails != nil {
+		metadata.CachedContentTokenCount = usage.PromptTokensDetails.CachedTokens
+	}
+	if usage.CompletionTokensDetails != nil {
+		metadata.ThoughtsTokenCount = usage.CompletionTokensDetails.ReasoningTokens
+	}
+	return metadata
+}
+
+// geminiStatuses HTTP状态码对应的Google API错误状态
+var geminiStatuses = map[int]string{
+	http.StatusBadRequest:            "INVALID_ARGUMENT",
+	http.StatusNotFound:              "NOT_FOUND",
+	http.StatusRequestEntityTooLarge: "INVALID_ARGUMENT",
+	http.StatusTooManyRequests:       "RESOURCE_EXHAUSTED",
+	http.StatusServiceUnavailable:    "UNAVAILABLE",
+	http.StatusGatewayTimeout:        "DEADLINE_EXCEEDED",
+}
+
+// writeGeminiError 按错误类型返回对应状态码,流式响应已开始时下发错误块并结束流
+func writeGeminiError(c *gin.Context, writer *geminiStreamWriter, err error) {
+	writeGeminiAPIError(c, writer, newAPIError(err))
+}
+
+// writeGeminiAPIError 以Google API格式输出接口错误
+func writeGeminiAPIError(c *gin.Context, writer *geminiStreamWriter, apiErr apiError) {
+	logAPIError(c, apiErr)
+	errorResponse := geminiErrorResponse(apiErr)
+	if c.Writer.Written() && writer != nil {
+		writer.send(c, errorResponse)
+		writer.close(c)
+		return
+	}
+	respondError(c, apiErr, errorResponse)
+}
+
+// geminiErrorResponse 接口错误对应的Google API错误体
+func geminiErrorResponse(apiErr apiError) model.GeminiErrorResponse {
+	status, ok := geminiStatuses[apiErr.status]
+	if !ok {
+		status = "INTERNAL"
+	}
+	return model.NewGeminiErrorResponse(apiErr.status, status, apiErr.error.Message)
+}
+
+// geminiStreamWriter 按SSE或JSON数组格式下发流式块,多个candidate并发写入时加锁
+type geminiStreamWriter struct {
+	mu     sync.Mutex
+	sse    bool
+	opened bool
+}
+
+func (w *geminiStreamWriter) send(c *gin.Context, chunk interface{}) error {
+	jsonResp, err := json.Marshal(chunk)
+	if err != nil {
+		logger.Errorf(c.Request.Context(), "Failed to marshal response: %v", err)
+		return err
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.sse {
+		c.SSEvent("", " "+string(jsonResp))
+	} else {
+		separator := ",\r\n"
+		if !w.opened {
+			w.opened = true
+			separator = "["
+		}
+		if _, err := c.Writer.WriteString(separator + string(jsonResp)); err != nil {
+			return err
+		}
+	}
+	c.Writer.Flush()
+	return nil
+}
+
+// close 结束JSON数组,SSE格式无结束标记
+func (w *geminiStreamWriter) close(c *gin.Context) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.sse {
+		return
+	}
+	if !w.opened {
+		c.Writer.WriteString("[")
+	}
+	c.Writer.WriteString("]")
+	c.Writer.Flush()
+}
@@ -0,0 +1,597 @@
+package controller
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"kilo2api/common"
+	"kilo2api/common/config"
+	logger "kilo2api/common/loggger"
+	"kilo2api/cycletls"
+	"kilo2api/model"
+	"kilo2api/provider"
+	"net/http"
+	"time"
+)
+
+const claudeMessageIDFormat = "msg_%s"
+
+// claudeEventSource 将上游返回的单条SSE事件转换为Anthropic流式事件,返回true表示消息已结束
+type claudeEventSource func(sseEvent cycletls.SSEEvent) ([]map[string]interface{}, bool)
+
+// MessagesForClaude @Summary Anthropic Messages接口
+// @Description Anthropic Messages接口
+// @Tags Anthropic
+// @Accept json
+// @Produce json
+// @Param req body model.ClaudeMessagesRequest true "Anthropic Messages请求"
+// @Param x-api-key header string true "API-KEY"
+// @Router /v1/messages [post]
+func MessagesForClaude(c *gin.Context) {
+
+	var claudeReq model.ClaudeMessagesRequest
+	if err := c.ShouldBindJSON(&claudeReq); err != nil {
+		logger.Errorf(c.Request.Context(), err.Error())
+		writeClaudeAPIError(c, bindError(err))
+		return
+	}
+
+	modelInfo, p, b := getModelProvider(claudeReq.Model)
+	if !b {
+		writeClaudeAPIError(c, modelNotFoundError(claudeReq.Model))
+		return
+	}
+	if claudeReq.MaxTokens > modelInfo.MaxTokens {
+		writeClaudeAPIError(c, requestError(fmt.Sprintf("Max tokens %d exceeds limit %d", claudeReq.MaxTokens, modelInfo.MaxTokens)))
+		return
+	}
+
+	if _, ok := checkClaudeRequest(c, p, claudeReq, modelInfo); !ok {
+		return
+	}
+
+	jsonData, eventSource, finish, err := createClaudeMessagesBody(c, p, claudeReq, modelInfo)
+	if err != nil {
+		writeClaudeError(c, err)
+		return
+	}
+
+	if claudeReq.Stream {
+		handleClaudeStreamRequest(c, p, jsonData, eventSource, finish)
+	} else {
+		handleClaudeNonStreamRequest(c, p, claudeReq, jsonData, eventSource, finish)
+	}
+}
+
+// checkClaudeRequest 校验模型能力、思考预算与提供方的消息约束,messages与count_tokens共用,失败时已写入错误响应
+func checkClaudeRequest(c *gin.Context, p provider.Provider, claudeReq model.ClaudeMessagesRequest, modelInfo common.ModelInfo) (model.OpenAIChatCompletionRequest, bool) {
+	openAIReq, err := model.ConvertClaudeToOpenAIRequest(claudeReq)
+	if err != nil {
+		writeClaudeError(c, err)
+		return openAIReq, false
+	}
+	if err := openAIReq.CheckModelCapabilities(modelInfo); err != nil {
+		writeClaudeAPIError(c, requestError(err.Error()))
+		return openAIReq, false
+	}
+	if _, err := openAIReq.ResolveThinkingBudget(modelInfo); err != nil {
+		writeClaudeAPIError(c, requestError(err.Error()))
+		return openAIReq, false
+	}
+	if validator, ok := p.(provider.MessageValidator); ok {
+		if openAIError := validator.ValidateMessages(openAIReq, modelInfo); openAIError != nil {
+			writeClaudeAPIError(c, invalidRequestError(*openAIError))
+			return openAIReq, false
+		}
+	}
+	return openAIReq, true
+}
+
+// fitClaudeContextWindow 透传的Anthropic请求同样按上下文窗口截断,未截断时保持原请求体
+func fitClaudeContextWindow(c *gin.Context, jsonData []byte, modelInfo common.ModelInfo, claudeReq model.ClaudeMessagesRequest) ([]byte, error) {
+	if modelInfo.ContextWindow <= 0 || modelInfo.ContextTruncationStrategy() == config.ContextTruncationNone {
+		return jsonData, nil
+	}
+	requestBody := make(map[string]interface{})
+	if err := json.Unmarshal(jsonData, &requestBody); err != nil {
+		return nil, err
+	}
+	truncation, err := fitContextWindow(c, requestBody, modelInfo, claudeReq.Model, claudeReq.MaxTokens)
+	if err != nil {
+		return nil, err
+	}
+	if truncation.DroppedMessages == 0 {
+		return jsonData, nil
+	}
+	return json.Marshal(requestBody)
+}
+
+// createClaudeMessagesBody 构造上游请求体。原生支持Anthropic协议的提供方直接透传请求与事件,
+// 其他提供方先转换为OpenAI请求,再由事件源将归一化事件编码为Anthropic事件。
+func createClaudeMessagesBody(c *gin.Context, p provider.Provider, claudeReq model.ClaudeMessagesRequest, modelInfo common.ModelInfo) ([]byte, claudeEventSource, func() []map[string]interface{}, error) {
+	if nativeProvider, ok := p.(provider.ClaudeNativeProvider); ok {
+		jsonData, err := nativeProvider.BuildClaudeRequest(claudeReq, modelInfo)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		if jsonData, err = fitClaudeContextWindow(c, jsonData, modelInfo, claudeReq); err != nil {
+			return nil, nil, nil, err
+		}
+		logger.Debug(c.Request.Context(), fmt.Sprintf("RequestBody: %s", jsonData))
+		eventSource, finish := newClaudePassthroughSource(c, claudeReq.Model)
+		return jsonData, eventSource, finish, nil
+	}
+
+	openAIReq, err := model.ConvertClaudeToOpenAIRequest(claudeReq)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	requestBody, err := createRequestBody(c, p, &openAIReq, modelInfo)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	jsonData, err := json.Marshal(requestBody)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	eventSource, finish := newClaudeEventSource(c, p, claudeReq.Model)
+	return jsonData, eventSource, finish, nil
+}
+
+// newClaudeEventSource 创建将上游数据解析为归一化事件并编码为Anthropic流式事件的事件源。
+// 上游在结束标记之前中断时,finish补发error事件而非结束事件,与透传事件源一致
+func newClaudeEventSource(c *gin.Context, p provider.Provider, modelName string) (claudeEventSource, func() []map[string]interface{}) {
+	parser := p.NewStreamParser()
+	encoder := newClaudeEventEncoder(modelName)
+	ended := false
+	eventSource := func(sseEvent cycletls.SSEEvent) ([]map[string]interface{}, bool) {
+		events, done, err := parser.Parse(sseEvent)
+		if err != nil {
+			logger.Errorf(c.Request.Context(), "Failed to parse event: %v", err)
+			ended = true
+			return encoder.fail(err), true
+		}
+		var claudeEvents []map[string]interface{}
+		for _, event := range events {
+			claudeEvents = append(claudeEvents, encoder.encode(event)...)
+		}
+		if done {
+			ended = true
+			claudeEvents = append(claudeEvents, encoder.finish()...)
+		}
+		return claudeEvents, done
+	}
+	finish := func() []map[string]interface{} {
+		if ended {
+			return nil
+		}
+		ended = true
+		logger.Errorf(c.Request.Context(), "upstream stream ended without a finish event")
+		return encoder.fail(errIncompleteResponse)
+	}
+	return eventSource, finish
+}
+
+// newClaudePassthroughSource 创建透传上游Anthropic事件的事件源。
+// 上游在message_stop或error之前结束时,finish补发error事件,避免客户端把截断的消息当作完整响应
+func newClaudePassthroughSource(c *gin.Context, modelName string) (claudeEventSource, func() []map[string]interface{}) {
+	ended := false
+	eventSource := func(sseEvent cycletls.SSEEvent) ([]map[string]interface{}, bool) {
+		var event map[string]interface{}
+		if err := json.Unmarshal([]byte(sseEvent.Data), &event); err != nil {
+			logger.Errorf(c.Request.Context(), "Failed to unmarshal event: %v", err)
+			return nil, false
+		}
+		// 对外展示请求的模型名称
+		if event["type"] == "message_start" {
+			if message, ok := event["message"].(map[string]interface{}); ok {
+				message["model"] = modelName
+			}
+		}
+		ended = event["type"] == "message_stop" || event["type"] == "error"
+		return []map[string]interface{}{event}, ended
+	}
+	finish := func() []map[string]interface{} {
+		if ended {
+			return nil
+		}
+		ended = true
+		logger.Errorf(c.Request.Context(), "upstream stream ended without message_stop")
+		return []map[string]interface{}{claudeErrorEvent(newAPIError(errIncompleteResponse))}
+	}
+	return eventSource, finish
+}
+
+func handleClaudeStreamRequest(c *gin.Context, p provider.Provider, jsonData []byte, eventSource claudeEventSource, finish func() []map[string]interface{}) {
+	c.Header("Content-Type", "text/event-stream")
+	c.Header("Cache-Control", "no-cache")
+	c.Header("Connection", "keep-alive")
+
+	err := relayChatRequest(c, p, jsonData, func(event cycletls.SSEEvent) bool {
+		events, done := eventSource(event)
+		for _, event := range events {
+			if err := sendTypedSSEvent(c, event); err != nil {
+				return false
+			}
+		}
+		return !done
+	})
+	if err != nil {
+		writeClaudeError(c, err)
+		return
+	}
+	for _, event := range finish() {
+		if err := sendTypedSSEvent(c, event); err != nil {
+			return
+		}
+	}
+}
+
+func handleClaudeNonStreamRequest(c *gin.Context, p provider.Provider, claudeReq model.ClaudeMessagesRequest, jsonData []byte, eventSource claudeEventSource, finish func() []map[string]interface{}) {
+	aggregator := newClaudeMessageAggregator()
+	err := relayChatRequest(c, p, jsonData, func(event cycletls.SSEEvent) bool {
+		events, done := eventSource(event)
+		for _, event := range events {
+			aggregator.add(event)
+		}
+		return !done
+	})
+	if err != nil {
+		writeClaudeError(c, err)
+		return
+	}
+	for _, event := range finish() {
+		aggregator.add(event)
+	}
+
+	if aggregator.err != nil {
+		writeClaudeAPIError(c, aggregator.apiError())
+		return
+	}
+	c.JSON(http.StatusOK, aggregator.result(claudeReq.Model))
+}
+
+// claudeErrorTypes HTTP状态码对应的Anthropic错误类型
+var claudeErrorTypes = map[int]string{
+	http.StatusBadRequest:            "invalid_request_error",
+	http.StatusUnauthorized:          "authentication_error",
+	http.StatusForbidden:             "permission_error",
+	http.StatusNotFound:              "not_found_error",
+	http.StatusRequestEntityTooLarge: "request_too_large",
+	http.StatusTooManyRequests:       "rate_limit_error",
+	http.StatusServiceUnavailable:    "overloaded_error",
+}
+
+func claudeErrorType(status int) string {
+	if errorType, ok := claudeErrorTypes[status]; ok {
+		return errorType
+	}
+	return "api_error"
+}
+
+// claudeErrorEvent 流式响应中的error事件
+func claudeErrorEvent(apiErr apiError) map[string]interface{} {
+	return map[string]interface{}{
+		"type": "error",
+		"error": map[string]interface{}{
+			"type":    claudeErrorType(apiErr.status),
+			"message": apiErr.error.Message,
+		},
+	}
+}
+
+// writeClaudeError 按错误类型返回对应状态码,流式响应已开始时下发error事件
+func writeClaudeError(c *gin.Context, err error) {
+	writeClaudeAPIError(c, newAPIError(err))
+}
+
+// writeClaudeAPIError 以Anthropic格式输出接口错误
+func writeClaudeAPIError(c *gin.Context, apiErr apiError) {
+	logAPIError(c, apiErr)
+	if c.Writer.Written() {
+		sendTypedSSEvent(c, claudeErrorEvent(apiErr))
+		return
+	}
+	respondError(c, apiErr, model.NewClaudeErrorResponse(claudeErrorType(apiErr.status), apiErr.error.Message))
+}
+
+// sendTypedSSEvent 以事件的type字段作为事件名发送SSE,用于Anthropic与Responses接口
+func sendTypedSSEvent(c *gin.Context, event map[string]interface{}) error {
+	jsonResp, err := json.Marshal(event)
+	if err != nil {
+		logger.Errorf(c.Request.Context(), "Failed to marshal event: %v", err)
+		return err
+	}
+	eventType, _ := event["type"].(string)
+	c.SSEvent(eventType, " "+string(jsonResp))
+	c.Writer.Flush()
+	return nil
+}
+
+// claudeMessageAggregator 将Anthropic流式事件聚合为完整的message响应
+type claudeMessageAggregator struct {
+	message     map[string]interface{}
+	content     []map[string]interface{}
+	partialJSON map[int]string
+	err         map[string]interface{}
+}
+
+func newClaudeMessageAggregator() *claudeMessageAggregator {
+	return &claudeMessageAggregator{
+		message:     make(map[string]interface{}),
+		partialJSON: make(map[int]string),
+	}
+}
+
+func (a *claudeMessageAggregator) add(event map[string]interface{}) {
+	switch event["type"] {
+	case "message_start":
+		if message, ok := event["message"].(map[string]interface{}); ok {
+			a.message = message
+		}
+	case "content_block_start":
+		index := common.ToInt(event["index"])
+		block, _ := event["content_block"].(map[string]interface{})
+		for len(a.content) <= index {
+			a.content = append(a.content, nil)
+		}
+		a.content[index] = block
+	case "content_block_delta":
+		index := common.ToInt(event["index"])
+		if index >= len(a.content) || a.content[index] == nil {
+			return
+		}
+		block := a.content[index]
+		delta, _ := event["delta"].(map[string]interface{})
+		switch delta["type"] {
+		case "text_delta":
+			text, _ := block["text"].(string)
+			deltaText, _ := delta["text"].(string)
+			block["text"] = text + deltaText
+		case "thinking_delta":
+			thinking, _ := block["thinking"].(string)
+			deltaThinking, _ := delta["thinking"].(string)
+			block["thinking"] = thinking + deltaThinking
+		case "signature_delta":
+			block["signature"] = delta["signature"]
+		case "input_json_delta":
+			partial, _ := delta["partial_json"].(string)
+			a.partialJSON[index] += partial
+		}
+	case "content_block_stop":
+		index := common.ToInt(event["index"])
+		if partial, ok := a.partialJSON[index]; ok && index < len(a.content) && a.content[index] != nil {
+			a.content[index]["input"] = model.ParseToolArguments(partial)
+			delete(a.partialJSON, index)
+		}
+	case "message_delta":
+		if delta, ok := event["delta"].(map[string]interface{}); ok {
+			for key, value := range delta {
+				a.message[key] = value
+			}
+		}
+		if usage, ok := event["usage"].(map[string]interface{}); ok {
+			messageUsage, ok := a.message["usage"].(map[string]interface{})
+			if !ok {
+				messageUsage = make(map[string]interface{})
+				a.message["usage"] = messageUsage
+			}
+			for key, value := range usage {
+				messageUsage[key] = value
+			}
+		}
+	case "error":
+		a.err = event
+	}
+}
+
+// apiError 将流中的error事件按上游错误分类映射为状态码,流内错误没有HTTP状态码
+func (a *claudeMessageAggregator) apiError() apiError {
+	body, _ := json.Marshal(a.err)
+	return newAPIError(provider.ClassifyUpstreamError(http.StatusOK, nil, string(body)))
+}
+
+// result 返回聚合后的message
+func (a *claudeMessageAggregator) result(modelName string) map[string]interface{} {
+	content := make([]map[string]interface{}, 0, len(a.content))
+	for _, block := range a.content {
+		if block != nil {
+			content = append(content, block)
+		}
+	}
+
+	message := a.message
+	if _, ok := message["id"]; !ok {
+		message["id"] = fmt.Sprintf(claudeMessageIDFormat, time.Now().Format("20060102150405"))
+	}
+	message["type"] = "message"
+	message["role"] = "assistant"
+	message["model"] = modelName
+	message["content"] = content
+	if _, ok := message["stop_reason"]; !ok {
+		message["stop_reason"] = "end_turn"
+	}
+	if _, ok := message["stop_sequence"]; !ok {
+		message["stop_sequence"] = nil
+	}
+	return message
+}
+
+// claudeEventEncoder 将归一化事件编码为Anthropic流式事件
+type claudeEventEncoder struct {
+	model       string
+	started     bool
+	finished    bool
+	blockIndex  int
+	blockType   string
+	nextIndex   int
+	toolIndexes map[int]int
+	stopReason  string
+	usage       provider.Usage
+}
+
+func newClaudeEventEncoder(modelName string) *claudeEventEncoder {
+	return &claudeEventEncoder{
+		model:       modelName,
+		blockIndex:  -1,
+		toolIndexes: make(map[int]int),
+		stopReason:  "end_turn",
+	}
+}
+
+func (s *claudeEventEncoder) encode(event provider.Event) []map[string]interface{} {
+	events := s.start()
+
+	switch event.Type {
+	case provider.EventText:
+		if s.blockType != "text" {
+			events = append(events, s.startBlock("text", map[string]interface{}{
+				"type": "text",
+				"text": "",
+			})...)
+		}
+		events = append(events, s.delta(s.blockIndex, map[string]interface{}{
+			"type": "text_delta",
+			"text": event.Text,
+		}))
+	case provider.EventThinking:
+		if s.blockType != "thinking" {
+			events = append(events, s.startBlock("thinking", map[string]interface{}{
+				"type":     "thinking",
+				"thinking": "",
+			})...)
+		}
+		events = append(events, s.delta(s.blockIndex, map[string]interface{}{
+			"type":     "thinking_delta",
+			"thinking": event.Text,
+		}))
+	case provider.EventThinkingSignature:
+		if s.blockType == "thinking" {
+			events = append(events, s.delta(s.blockIndex, map[string]interface{}{
+				"type":      "signature_delta",
+				"signature": event.Text,
+			}))
+		}
+	case provider.EventToolCallStart:
+		events = append(events, s.startBlock("tool_use", map[string]interface{}{
+			"type":  "tool_use",
+			"id":    event.ToolCallID,
+			"name":  event.ToolName,
+			"input": map[string]interface{}{},
+		})...)
+		s.toolIndexes[event.ToolIndex] = s.blockIndex
+	case provider.EventToolCallArguments:
+		if blockIndex, ok := s.toolIndexes[event.ToolIndex]; ok && event.Text != "" {
+			events = append(events, s.delta(blockIndex, map[string]interface{}{
+				"type":         "input_json_delta",
+				"partial_json": event.Text,
+			}))
+		}
+	case provider.EventUsage:
+		s.usage = *event.Usage
+	case provider.EventFinish:
+		switch event.FinishReason {
+		case provider.FinishReasonLength:
+			s.stopReason = "max_tokens"
+		case provider.FinishReasonToolCalls:
+			s.stopReason = "tool_use"
+		case provider.FinishReasonContentFilter:
+			s.stopReason = "refusal"
+		default:
+			s.stopReason = "end_turn"
+		}
+	}
+	return events
+}
+
+func (s *claudeEventEncoder) delta(blockIndex int, delta map[string]interface{}) map[string]interface{} {
+	return map[string]interface{}{
+		"type":  "content_block_delta",
+		"index": blockIndex,
+		"delta": delta,
+	}
+}
+
+// start 首个事件到达时发送message_start
+func (s *claudeEventEncoder) start() []map[string]interface{} {
+	if s.started {
+		return nil
+	}
+	s.started = true
+	return []map[string]interface{}{{
+		"type": "message_start",
+		"message": map[string]interface{}{
+			"id":            fmt.Sprintf(claudeMessageIDFormat, time.Now().Format("20060102150405")),
+			"type":          "message",
+			"role":          "assistant",
+			"model":         s.model,
+			"content":       []interface{}{},
+			"stop_reason":   nil,
+			"stop_sequence": nil,
+			"usage": map[string]interface{}{
+				"input_tokens":  0,
+				"output_tokens": 0,
+			},
+		},
+	}}
+}
+
+// startBlock 关闭当前内容块并开启新的内容块
+func (s *claudeEventEncoder) startBlock(blockType string, contentBlock map[string]interface{}) []map[string]interface{} {
+	events := s.stopBlock()
+	s.blockIndex = s.nextIndex
+	s.blockType = blockType
+	s.nextIndex++
+	return append(events, map[string]interface{}{
+		"type":          "content_block_start",
+		"index":         s.blockIndex,
+		"content_block": contentBlock,
+	})
+}
+
+func (s *claudeEventEncoder) stopBlock() []map[string]interface{} {
+	if s.blockIndex < 0 {
+		return nil
+	}
+	event := map[string]interface{}{
+		"type":  "content_block_stop",
+		"index": s.blockIndex,
+	}
+	s.blockIndex = -1
+	s.blockType = ""
+	return []map[string]interface{}{event}
+}
+
+// fail 上游返回错误时发送error事件,之后不再发送结束事件
+func (s *claudeEventEncoder) fail(err error) []map[string]interface{} {
+	s.finished = true
+	return []map[string]interface{}{claudeErrorEvent(newAPIError(err))}
+}
+
+// finish 发送结束事件,重复调用时不再发送
+func (s *claudeEventEncoder) finish() []map[string]interface{} {
+	if s.finished {
+		return nil
+	}
+	s.finished = true
+	events := s.start()
+	events = append(events, s.stopBlock()...)
+	return append(events,
+		map[string]interface{}{
+			"type": "message_delta",
+			"delta": map[string]interface{}{
+				"stop_reason":   s.stopReason,
+				"stop_sequence": nil,
+			},
+			"usage": map[string]interface{}{
+				"input_tokens":                s.usage.PromptTokens - s.usage.CachedTokens - s.usage.CacheCreationTokens,
+				"cache_creation_input_tokens": s.usage.CacheCreationTokens,
+				"cache_read_input_tokens":     s.usage.CachedTokens,
+				"output_tokens":               s.usage.CompletionTokens,
+			},
+		},
+		map[string]interface{}{
+			"type": "message_stop",
+		},
+	)
+}
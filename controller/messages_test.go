@@ -0,0 +1,61 @@
+package controller
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestMessagesTranslatedTruncatedStream(t *testing.T) {
+	truncated := []string{
+		`{"type":"message_start","message":{"id":"msg_1","usage":{"input_tokens":10,"output_tokens":1}}}`,
+		`{"type":"content_block_start","index":0,"content_block":{"type":"text","text":""}}`,
+		`{"type":"content_block_delta","index":0,"delta":{"type":"text_delta","text":"partial"}}`,
+	}
+	const body = `{"model":"claude-3-7-sonnet-20250219","max_tokens":1024,"messages":[{"role":"user","content":"hi"}]`
+
+	t.Run("non-stream", func(t *testing.T) {
+		fakeUpstream(t, "claude", truncated...)
+		w := serve(MessagesForClaude, "/v1/messages", body+`}`)
+		if w.Code != http.StatusBadGateway {
+			t.Fatalf("status = %d, body %s", w.Code, w.Body.String())
+		}
+		if resp := decodeJSON(t, w.Body.String()); resp["type"] != "error" {
+			t.Fatalf("body = %v", resp)
+		}
+	})
+
+	t.Run("stream", func(t *testing.T) {
+		fakeUpstream(t, "claude", truncated...)
+		w := serve(MessagesForClaude, "/v1/messages", body+`,"stream":true}`)
+		data := sseData(t, w.Body.String())
+		if len(data) == 0 {
+			t.Fatal("empty stream")
+		}
+		// 截断的流以error事件结束,不下发message_stop
+		for _, d := range data {
+			if decodeJSON(t, d)["type"] == "message_stop" {
+				t.Fatalf("truncated stream sent message_stop: %q", data)
+			}
+		}
+		if last := decodeJSON(t, data[len(data)-1]); last["type"] != "error" {
+			t.Fatalf("last event = %v", last)
+		}
+	})
+}
+
+func TestMessagesTranslatedStopReason(t *testing.T) {
+	fakeUpstream(t, "claude",
+		`{"type":"message_start","message":{"id":"msg_1","usage":{"input_tokens":10,"output_tokens":1}}}`,
+		`{"type":"content_block_start","index":0,"content_block":{"type":"text","text":""}}`,
+		`{"type":"content_block_delta","index":0,"delta":{"type":"text_delta","text":"partial"}}`,
+		`{"type":"message_delta","delta":{"stop_reason":"max_tokens"},"usage":{"output_tokens":5}}`,
+		`{"type":"message_stop"}`,
+	)
+	w := serve(MessagesForClaude, "/v1/messages", `{"model":"claude-3-7-sonnet-20250219","max_tokens":1024,"messages":[{"role":"user","content":"hi"}]}`)
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d, body %s", w.Code, w.Body.String())
+	}
+	if resp := decodeJSON(t, w.Body.String()); resp["stop_reason"] != "max_tokens" {
+		t.Fatalf("stop_reason = %v", resp["stop_reason"])
+	}
+}
@@ -0,0 +1,390 @@
+package controller
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"kilo2api/common"
+	"kilo2api/common/config"
+	logger "kilo2api/common/loggger"
+	"kilo2api/cycletls"
+	"kilo2api/model"
+	"kilo2api/provider"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// ollamaVersion /api/version返回的版本,部分客户端据此判断接口能力
+const ollamaVersion = "0.9.0"
+
+// ChatForOllama @Summary Ollama对话接口
+// @Description Ollama对话接口,默认以NDJSON流式返回
+// @Tags Ollama
+// @Accept json
+// @Produce json
+// @Param req body model.OllamaChatRequest true "Ollama对话请求"
+// @Router /api/chat [post]
+func ChatForOllama(c *gin.Context) {
+	var ollamaReq model.OllamaChatRequest
+	if err := c.ShouldBindJSON(&ollamaReq); err != nil {
+		logger.Errorf(c.Request.Context(), err.Error())
+		writeOllamaAPIError(c, bindError(err))
+		return
+	}
+	ollamaReq.Model = ollamaModelName(ollamaReq.Model)
+
+	openAIReq, err := model.ConvertOllamaChatToOpenAIRequest(ollamaReq)
+	if err != nil {
+		writeOllamaAPIError(c, requestError(err.Error()))
+		return
+	}
+	handleOllamaRequest(c, openAIReq, ollamaReq.Think, false)
+}
+
+// GenerateForOllama @Summary Ollama补全接口
+// @Description Ollama补全接口,默认以NDJSON流式返回
+// @Tags Ollama
+// @Accept json
+// @Produce json
+// @Param req body model.OllamaGenerateRequest true "Ollama补全请求"
+// @Router /api/generate [post]
+func GenerateForOllama(c *gin.Context) {
+	var ollamaReq model.OllamaGenerateRequest
+	if err := c.ShouldBindJSON(&ollamaReq); err != nil {
+		logger.Errorf(c.Request.Context(), err.Error())
+		writeOllamaAPIError(c, bindError(err))
+		return
+	}
+	ollamaReq.Model = ollamaModelName(ollamaReq.Model)
+
+	// 空prompt用于预加载模型,直接返回完成
+	if ollamaReq.Prompt == "" && ollamaReq.Suffix == "" {
+		c.JSON(http.StatusOK, model.OllamaGenerateResponse{
+			Model:       ollamaReq.Model,
+			CreatedAt:   time.Now().UTC().Format(time.RFC3339Nano),
+			OllamaStats: model.OllamaStats{Done: true, DoneReason: "load"},
+		})
+		return
+	}
+
+	handleOllamaRequest(c, model.ConvertOllamaGenerateToOpenAIRequest(ollamaReq), ollamaReq.Think, true)
+}
+
+func handleOllamaRequest(c *gin.Context, openAIReq model.OpenAIChatCompletionRequest, think interface{}, generate bool) {
+
+	openAIReq.ReasoningFormat = config.ReasoningFormatHidden
+	if model.OllamaThinkEnabled(think) {
+		openAIReq.ReasoningFormat = config.ReasoningFormatContent
+	}
+
+	modelInfo, p, openAIError := validateChatRequest(c, &openAIReq)
+	if openAIError != nil {
+		if openAIError.Code == modelNotFoundCode {
+			writeOllamaAPIError(c, ollamaModelNotFoundError(openAIReq.Model))
+			return
+		}
+		writeOllamaAPIError(c, invalidRequestError(*openAIError))
+		return
+	}
+
+	requestBody, err := createRequestBody(c, p, &openAIReq, modelInfo)
+	if err != nil {
+		writeOllamaError(c, err)
+		return
+	}
+	jsonData, err := json.Marshal(requestBody)
+	if err != nil {
+		writeOllamaError(c, err)
+		return
+	}
+
+	encoder := &ollamaEncoder{modelName: openAIReq.Model, generate: generate, start: time.Now()}
+	state := newChatResponseState(openAIReq.ReasoningFormat)
+	state.setResponseFormat(openAIReq.ResponseFormat, p)
+	parser := p.NewStreamParser()
+
+	if !openAIReq.Stream {
+		err := relayChatRequest(c, p, jsonData, func(event cycletls.SSEEvent) bool {
+			return processNoStreamData(c, event, parser, state)
+		})
+		if err == nil {
+			err = state.err
+		}
+		if err := streamError(c, []error{err}, []*chatResponseState{state}); err != nil {
+			writeOllamaError(c, err)
+			return
+		}
+		if c.Writer.Written() {
+			return
+		}
+		if err := state.validateOutput(); err != nil {
+			logger.Errorf(c.Request.Context(), "validateOutput err: %v", err)
+			writeOllamaAPIError(c, responseFormatAPIError(err))
+			return
+		}
+		encoder.firstToken = encoder.start
+		c.JSON(http.StatusOK, encoder.encode(state.content, state.reasoning, ollamaToolCalls(state.toolState.toolCalls()), encoder.stats(state, jsonData)))
+		return
+	}
+
+	c.Header("Content-Type", "application/x-ndjson")
+	c.Header("Cache-Control", "no-cache")
+	c.Header("Connection", "keep-alive")
+
+	err = relayChatRequest(c, p, jsonData, func(event cycletls.SSEEvent) bool {
+		events, done, err := parser.Parse(event)
+		if err != nil {
+			logger.Errorf(c.Request.Context(), "Failed to parse event: %v", err)
+			state.err = err
+			return false
+		}
+		for _, event := range events {
+			// 工具调用需完整下发,结束时统一下发
+			text, reasoning, _ := state.accumulate(event)
+			if text == "" && reasoning == "" {
+				continue
+			}
+			if encoder.firstToken.IsZero() {
+				encoder.firstToken = time.Now()
+			}
+			if err := sendNDJSON(c, encoder.encode(text, reasoning, nil, model.OllamaStats{})); err != nil {
+				logger.Errorf(c.Request.Context(), "sendNDJSON err: %v", err)
+				return false
+			}
+		}
+		if done || state.finishReason != "" {
+			state.finished = true
+		}
+		return !done
+	})
+	if err == nil {
+		err = state.err
+	}
+	// 上游未返回结束原因即中断时以错误行结束,不下发done行
+	if err := streamError(c, []error{err}, []*chatResponseState{state}); err != nil {
+		writeOllamaError(c, err)
+		return
+	}
+	if c.Request.Context().Err() != nil {
+		return
+	}
+	if err := state.validateOutput(); err != nil {
+		logger.Errorf(c.Request.Context(), "validateOutput err: %v", err)
+		sendNDJSON(c, model.OllamaErrorResponse{Error: responseFormatError(err).OpenAIError.Message})
+		return
+	}
+	if toolCalls := ollamaToolCalls(state.toolState.toolCalls()); len(toolCalls) > 0 {
+		sendNDJSON(c, encoder.encode("", "", toolCalls, model.OllamaStats{}))
+	}
+	if encoder.firstToken.IsZero() {
+		encoder.firstToken = time.Now()
+	}
+	sendNDJSON(c, encoder.encode("", "", nil, encoder.stats(state, jsonData)))
+}
+
+// ollamaEncoder 按/api/chat或/api/generate的格式编码响应
+type ollamaEncoder struct {
+	modelName string
+	generate  bool
+	start     time.Time
+	// firstToken 首个token的时间,用于计算prompt_eval_duration与eval_duration
+	firstToken time.Time
+}
+
+func (e *ollamaEncoder) encode(text, thinking string, toolCalls []model.OllamaToolCall, stats model.OllamaStats) interface{} {
+	createdAt := time.Now().UTC().Format(time.RFC3339Nano)
+	if e.generate {
+		return model.OllamaGenerateResponse{
+			Model:       e.modelName,
+			CreatedAt:   createdAt,
+			Response:    text,
+			Thinking:    thinking,
+			OllamaStats: stats,
+		}
+	}
+	return model.OllamaChatResponse{
+		Model:     e.modelName,
+		CreatedAt: createdAt,
+		Message: model.OllamaMessage{
+			Role:      "assistant",
+			Content:   text,
+			Thinking:  thinking,
+			ToolCalls: toolCalls,
+		},
+		OllamaStats: stats,
+	}
+}
+
+// stats 生成结束行的统计信息
+func (e *ollamaEncoder) stats(state *chatResponseState, jsonData []byte) model.OllamaStats {
+	now := time.Now()
+	usage := state.openAIUsage(jsonData, e.modelName)
+	doneReason := "stop"
+	if state.openAIFinishReason() == provider.FinishReasonLength {
+		doneReason = "length"
+	}
+	return model.OllamaStats{
+		Done:               true,
+		DoneReason:         doneReason,
+		TotalDuration:      now.Sub(e.start).Nanoseconds(),
+		PromptEvalCount:    usage.PromptTokens,
+		PromptEvalDuration: e.firstToken.Sub(e.start).Nanoseconds(),
+		EvalCount:          usage.CompletionTokens,
+		EvalDuration:       now.Sub(e.firstToken).Nanoseconds(),
+	}
+}
+
+// ollamaToolCalls 将工具调用转换为Ollama格式,参数为对象
+func ollamaToolCalls(toolCalls []model.OpenAIToolCall) []model.OllamaToolCall {
+	var result []model.OllamaToolCall
+	for _, toolCall := range toolCalls {
+		arguments := map[string]interface{}{}
+		if toolCall.Function.Arguments != "" {
+			if err := json.Unmarshal([]byte(toolCall.Function.Arguments), &arguments); err != nil {
+				arguments = map[string]interface{}{"arguments": toolCall.Function.Arguments}
+			}
+		}
+		result = append(result, model.OllamaToolCall{Function: model.OllamaToolCallFunction{
+			Name:      toolCall.Function.Name,
+			Arguments: arguments,
+		}})
+	}
+	return result
+}
+
+// writeOllamaError 按错误类型返回对应状态码,流式响应已开始时以NDJSON错误行结束
+func writeOllamaError(c *gin.Context, err error) {
+	writeOllamaAPIError(c, newAPIError(err))
+}
+
+// writeOllamaAPIError 以Ollama格式输出接口错误
+func writeOllamaAPIError(c *gin.Context, apiErr apiError) {
+	logAPIError(c, apiErr)
+	if c.Writer.Written() {
+		sendNDJSON(c, model.OllamaErrorResponse{Error: apiErr.error.Message})
+		return
+	}
+	respondError(c, apiErr, model.OllamaErrorResponse{Error: apiErr.error.Message})
+}
+
+// ollamaModelNotFoundError Ollama客户端按该文案判断模型不存在并提示拉取
+func ollamaModelNotFoundError(modelName string) apiError {
+	apiErr := modelNotFoundError(modelName)
+	apiErr.error.Message = fmt.Sprintf("model %q not found", modelName)
+	return apiErr
+}
+
+// sendNDJSON 下发一行JSON
+func sendNDJSON(c *gin.Context, response interface{}) error {
+	jsonResp, err := json.Marshal(response)
+	if err != nil {
+		logger.Errorf(c.Request.Context(), "Failed to marshal response: %v", err)
+		return err
+	}
+	if _, err := c.Writer.Write(append(jsonResp, '\n')); err != nil {
+		return err
+	}
+	c.Writer.Flush()
+	return nil
+}
+
+// ollamaModelName Ollama客户端会为模型名追加:latest标签
+func ollamaModelName(name string) string {
+	return strings.TrimSuffix(name, ":latest")
+}
+
+// TagsForOllama @Summary Ollama模型列表接口
+// @Description Ollama模型列表接口
+// @Tags Ollama
+// @Produce json
+// @Success 200 {object} model.OllamaTagsResponse "成功"
+// @Router /api/tags [get]
+func TagsForOllama(c *gin.Context) {
+	models := []model.OllamaModel{}
+	for _, modelInfo := range common.GetModels() {
+		digest := sha256.Sum256([]byte(modelInfo.ID))
+		models = append(models, model.OllamaModel{
+			Name:       modelInfo.ID,
+			Model:      modelInfo.ID,
+			ModifiedAt: time.Unix(modelInfo.Created, 0).UTC().Format(time.RFC3339),
+			Digest:     hex.EncodeToString(digest[:]),
+			Details:    ollamaModelDetails(modelInfo),
+		})
+	}
+	c.JSON(http.StatusOK, model.OllamaTagsResponse{Models: models})
+}
+
+// ShowForOllama @Summary Ollama模型详情接口
+// @Description Ollama模型详情接口,返回上下文窗口与能力
+// @Tags Ollama
+// @Accept json
+// @Produce json
+// @Param req body model.OllamaShowRequest true "模型名称"
+// @Success 200 {object} model.OllamaShowResponse "成功"
+// @Router /api/show [post]
+func ShowForOllama(c *gin.Context) {
+	var showReq model.OllamaShowRequest
+	if err := c.ShouldBindJSON(&showReq); err != nil {
+		writeOllamaAPIError(c, bindError(err))
+		return
+	}
+	modelName := showReq.Model
+	if modelName == "" {
+		modelName = showReq.Name
+	}
+	modelName = ollamaModelName(modelName)
+	modelInfo, ok := common.GetModelInfo(modelName)
+	if !ok {
+		writeOllamaAPIError(c, ollamaModelNotFoundError(modelName))
+		return
+	}
+
+	capabilities := []string{"completion"}
+	if modelInfo.Tools {
+		capabilities = append(capabilities, "tools")
+	}
+	if modelInfo.Vision {
+		capabilities = append(capabilities, "vision")
+	}
+	if modelInfo.Thinking {
+		capabilities = append(capabilities, "thinking")
+	}
+	details := ollamaModelDetails(modelInfo)
+	modelDetails := map[string]interface{}{
+		"general.architecture": details.Family,
+		"general.basename":     modelInfo.ID,
+	}
+	if modelInfo.ContextWindow > 0 {
+		modelDetails[details.Family+".context_length"] = modelInfo.ContextWindow
+	}
+	c.JSON(http.StatusOK, model.OllamaShowResponse{
+		Parameters:   fmt.Sprintf("num_predict %d", modelInfo.MaxTokens),
+		Details:      details,
+		ModelInfo:    modelDetails,
+		Capabilities: capabilities,
+		ModifiedAt:   time.Unix(modelInfo.Created, 0).UTC().Format(time.RFC3339),
+	})
+}
+
+// VersionForOllama @Summary Ollama版本接口
+// @Tags Ollama
+// @Produce json
+// @Router /api/version [get]
+func VersionForOllama(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{"version": ollamaVersion})
+}
+
+func ollamaModelDetails(modelInfo common.ModelInfo) model.OllamaModelDetails {
+	family := modelInfo.OwnedBy
+	if family == "" {
+		family = modelInfo.Source
+	}
+	return model.OllamaModelDetails{
+		Format:   "api",
+		Family:   family,
+		Families: []string{family},
+	}
+}
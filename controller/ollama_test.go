@@ -0,0 +1,42 @@
+package controller
+
+import (
+	"bufio"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestOllamaTruncatedStream(t *testing.T) {
+	truncated := []string{`{"choices":[{"index":0,"delta":{"role":"assistant","content":"partial"},"finish_reason":null}]}`}
+
+	t.Run("non-stream", func(t *testing.T) {
+		fakeUpstream(t, "openrouter", truncated...)
+		w := serve(ChatForOllama, "/api/chat", `{"model":"gpt-4.1:latest","stream":false,"messages":[{"role":"user","content":"hi"}]}`)
+		if w.Code != http.StatusBadGateway {
+			t.Fatalf("status = %d, body %s", w.Code, w.Body.String())
+		}
+	})
+
+	t.Run("stream", func(t *testing.T) {
+		fakeUpstream(t, "openrouter", truncated...)
+		w := serve(ChatForOllama, "/api/chat", `{"model":"gpt-4.1:latest","messages":[{"role":"user","content":"hi"}]}`)
+		var lines []map[string]interface{}
+		scanner := bufio.NewScanner(strings.NewReader(w.Body.String()))
+		for scanner.Scan() {
+			lines = append(lines, decodeJSON(t, scanner.Text()))
+		}
+		if len(lines) == 0 {
+			t.Fatal("empty stream")
+		}
+		// 截断的流以错误行结束,不下发done行
+		for _, line := range lines {
+			if line["done"] == true {
+				t.Fatalf("truncated stream sent done line: %v", line)
+			}
+		}
+		if last := lines[len(lines)-1]; last["error"] == nil {
+			t.Fatalf("last line = %v", last)
+		}
+	})
+}
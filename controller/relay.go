@@ -0,0 +1,202 @@
+package controller
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"io"
+	"kilo2api/common"
+	"kilo2api/common/config"
+	logger "kilo2api/common/loggger"
+	"kilo2api/cycletls"
+	"kilo2api/kilo-api"
+	"kilo2api/provider"
+	"strings"
+	"time"
+)
+
+// upstreamClient 所有请求共享的上游客户端,连接按代理与TLS指纹在cycletls中复用(keep-alive/HTTP2)
+var upstreamClient = cycletls.Init()
+
+// relayHandler 处理上游返回的单条SSE事件,返回false表示流已结束
+type relayHandler func(event cycletls.SSEEvent) bool
+
+// relayAttemptResult 单次上游请求的结果
+type relayAttemptResult int
+
+const (
+	// relayDone 请求已结束(包括客户端断开)
+	relayDone relayAttemptResult = iota
+	// relayNextCookie 切换下一个cookie重试
+	relayNextCookie
+	// relaySameCookie 额度已恢复,使用同一cookie重试
+	relaySameCookie
+)
+
+// relayChatRequest 使用cookie池向上游发起流式请求,遇到额度耗尽/限流/登录失效时自动切换cookie重试。
+// 返回error时尚未向handler传递任何数据,由调用方负责以各自接口的格式返回错误;
+// 所有cookie均不可用时返回最后一次的上游错误。
+func relayChatRequest(c *gin.Context, p provider.Provider, jsonData []byte, handle relayHandler) error {
+	ctx := c.Request.Context()
+	cookieManager := config.NewCookieManager()
+	maxRetries := len(cookieManager.Cookies)
+	cookie, err := cookieManager.GetRandomCookie()
+	if err != nil {
+		logger.Errorf(ctx, "GetRandomCookie err: %v", err)
+		return errCookiesExhausted
+	}
+
+	// lastErr 最近一次切换cookie的原因,所有cookie均不可用时返回给客户端
+	var lastErr error
+	for attempt := 0; attempt < maxRetries; attempt++ {
+		result, err := relayAttempt(c, p, jsonData, cookie, handle, fmt.Sprintf("attempt %d/%d, COOKIE:%s", attempt+1, maxRetries, cookie))
+		switch result {
+		case relayDone:
+			return err
+		case relaySameCookie:
+			attempt-- // 抵消循环结束时的attempt++
+			continue
+		}
+		lastErr = err
+
+		// 获取下一个可用的cookie继续尝试
+		cookie, err = cookieManager.GetNextCookie()
+		if err != nil {
+			logger.Errorf(ctx, "No more valid cookies available after attempt %d", attempt+1)
+			break
+		}
+	}
+
+	logger.Errorf(ctx, "All cookies exhausted after %d attempts", maxRetries)
+	if lastErr != nil {
+		return lastErr
+	}
+	return errCookiesExhausted
+}
+
+// relayAttempt 使用指定cookie发起一次上游请求,切换cookie时返回切换原因。请求使用独立的ctx,
+// 返回时(handler停止读取或客户端断开)即中断上游请求,避免上游继续生成。
+func relayAttempt(c *gin.Context, p provider.Provider, jsonData []byte, cookie string, handle relayHandler, attemptInfo string) (relayAttemptResult, error) {
+	ctx := c.Request.Context()
+	attemptCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	sseChan, err := kilo_api.MakeStreamChatRequest(attemptCtx, c, upstreamClient, jsonData, cookie, p)
+	if err != nil {
+		logger.Errorf(ctx, "MakeStreamChatRequest err on %s: %v", attemptInfo, err)
+		return relayDone, err
+	}
+
+	for response := range sseChan {
+		data := response.Data
+		if response.Status == 403 || (response.Done && data != "") {
+			upstreamErr := p.ClassifyError(response.Status, response.Headers, data)
+			switch upstreamErr.Kind {
+			case provider.ErrorForbidden:
+				logger.Errorf(ctx, decompressForbiddenBody(data))
+				config.RemoveCookie(cookie)
+				return relayNextCookie, upstreamErr
+			case provider.ErrorQuota:
+				if config.CheatEnabled {
+					cheated, err := cheatCookie(c, cookie)
+					if err != nil {
+						return relayDone, err
+					}
+					if cheated {
+						return relaySameCookie, nil
+					}
+				}
+				logger.Warnf(ctx, "Cookie Usage limit exceeded, switching to next cookie, %s", attemptInfo)
+				config.RemoveCookie(cookie)
+				return relayNextCookie, upstreamErr
+			case provider.ErrorAuth:
+				logger.Warnf(ctx, "Cookie Not Login, switching to next cookie, %s", attemptInfo)
+				return relayNextCookie, upstreamErr
+			case provider.ErrorRateLimit:
+				lockDuration := time.Duration(config.RateLimitCookieLockDuration) * time.Second
+				if upstreamErr.RetryAfter > 0 {
+					lockDuration = upstreamErr.RetryAfter
+				}
+				logger.Warnf(ctx, "Cookie rate limited for %v, switching to next cookie, %s", lockDuration, attemptInfo)
+				config.AddRateLimitCookie(cookie, time.Now().Add(lockDuration))
+				return relayNextCookie, upstreamErr
+			}
+			logger.Errorf(ctx, "%v, %s", upstreamErr, attemptInfo)
+			return relayDone, upstreamErr
+		}
+
+		if data == "" {
+			continue
+		}
+
+		logger.Debug(ctx, strings.TrimSpace(data))
+
+		if !handle(response.SSEEvent()) {
+			return relayDone, nil
+		}
+	}
+
+	if ctx.Err() != nil {
+		// 客户端已断开,上游请求随ctx一同取消
+		logger.Warnf(ctx, "Request cancelled by client: %v", ctx.Err())
+	}
+	return relayDone, nil
+}
+
+// cheatCookie 额度耗尽时尝试为cookie重新获取额度,返回true表示成功可继续使用该cookie
+func cheatCookie(c *gin.Context, cookie string) (bool, error) {
+	ctx := c.Request.Context()
+	split := strings.Split(cookie, "=")
+	if len(split) != 2 {
+		return false, nil
+	}
+	cookieSession := split[1]
+	cheatResp, err := upstreamClient.DoWithContext(ctx, config.CheatUrl, cycletls.Options{
+		Timeout: 10 * 60 * 60,
+		Proxy:   config.ProxyUrl, // 在每个请求中设置代理
+		Body:    "",
+		Headers: map[string]string{
+			"Cookie": cookieSession,
+		},
+	}, "POST")
+	if err != nil {
+		logger.Errorf(ctx, "Cheat err Cookie: %s err: %v", cookie, err)
+		return false, err
+	}
+	if cheatResp.Status == 200 {
+		logger.Debug(ctx, fmt.Sprintf("Cheat Success Cookie: %s", cookie))
+		return true, nil
+	}
+	if cheatResp.Status == 402 {
+		logger.Warnf(ctx, "Cheat failed.  Cookie: %s Resp: %v", cookie, cheatResp.Body)
+		return false, nil
+	}
+	logger.Errorf(ctx, "Cheat err Cookie: %s Resp: %v", cookie, cheatResp.Body)
+	return false, fmt.Errorf("Cheat Resp.Status:%v Resp.Body:%v", cheatResp.Status, cheatResp.Body)
+}
+
+// getModelProvider 查询模型信息及其上游提供方
+func getModelProvider(modelName string) (common.ModelInfo, provider.Provider, bool) {
+	modelInfo, ok := common.GetModelInfo(modelName)
+	if !ok {
+		return modelInfo, nil, false
+	}
+	p, ok := provider.Get(modelInfo.Source)
+	return modelInfo, p, ok
+}
+
+// decompressForbiddenBody 403响应体可能为gzip压缩,解压失败时返回原始内容
+func decompressForbiddenBody(data string) string {
+	gzipReader, err := gzip.NewReader(bytes.NewReader([]byte(data)))
+	if err != nil {
+		return data
+	}
+	defer gzipReader.Close()
+	uncompressedData, err := io.ReadAll(gzipReader)
+	if err != nil {
+		return data
+	}
+	return string(uncompressedData)
+}
@@ -0,0 +1,574 @@
+package controller
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"kilo2api/common"
+	logger "kilo2api/common/loggger"
+	"kilo2api/cycletls"
+	"kilo2api/model"
+	"kilo2api/provider"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+// ResponsesForOpenAI @Summary OpenAI Responses接口
+// @Description OpenAI Responses接口
+// @Tags OpenAI
+// @Accept json
+// @Produce json
+// @Param req body model.OpenAIResponsesRequest true "OpenAI Responses请求"
+// @Param Authorization header string true "Authorization API-KEY"
+// @Router /v1/responses [post]
+func ResponsesForOpenAI(c *gin.Context) {
+
+	var responsesReq model.OpenAIResponsesRequest
+	if err := c.ShouldBindJSON(&responsesReq); err != nil {
+		logger.Errorf(c.Request.Context(), err.Error())
+		writeAPIError(c, bindError(err))
+		return
+	}
+
+	modelInfo, p, b := getModelProvider(responsesReq.Model)
+	if !b {
+		writeAPIError(c, modelNotFoundError(responsesReq.Model))
+		return
+	}
+	if responsesReq.MaxOutputTokens > modelInfo.MaxTokens {
+		writeAPIError(c, invalidRequestError(model.OpenAIError{
+			Message: fmt.Sprintf("Max output tokens %d exceeds limit %d", responsesReq.MaxOutputTokens, modelInfo.MaxTokens),
+			Type:    "invalid_request_error",
+			Param:   "max_output_tokens",
+			Code:    "invalid_max_tokens",
+		}))
+		return
+	}
+
+	openAIReq, err := model.ConvertResponsesToOpenAIRequest(responsesReq)
+	if err != nil {
+		writeAPIError(c, invalidRequestError(model.OpenAIError{
+			Message: err.Error(),
+			Type:    "invalid_request_error",
+			Param:   "input",
+			Code:    "invalid_input",
+		}))
+		return
+	}
+	openAIReq.RemoveEmptyContentMessages()
+	if err := openAIReq.CheckModelCapabilities(modelInfo); err != nil {
+		writeAPIError(c, invalidRequestError(model.OpenAIError{
+			Message: err.Error(),
+			Type:    "invalid_request_error",
+			Code:    "unsupported_capability",
+		}))
+		return
+	}
+	if _, err := openAIReq.ResolveThinkingBudget(modelInfo); err != nil {
+		writeAPIError(c, invalidRequestError(model.OpenAIError{
+			Message: err.Error(),
+			Type:    "invalid_request_error",
+			Param:   "reasoning",
+			Code:    "invalid_thinking_budget",
+		}))
+		return
+	}
+
+	requestBody, err := createRequestBody(c, p, &openAIReq, modelInfo)
+	if err != nil {
+		writeError(c, err)
+		return
+	}
+	jsonData, err := json.Marshal(requestBody)
+	if err != nil {
+		writeError(c, err)
+		return
+	}
+
+	eventSource, finish := newClaudeEventSource(c, p, responsesReq.Model)
+	builder := newResponsesBuilder(responsesReq, jsonData)
+
+	if responsesReq.Stream {
+		handleResponsesStreamRequest(c, p, jsonData, eventSource, finish, builder)
+	} else {
+		handleResponsesNonStreamRequest(c, p, jsonData, eventSource, finish, builder)
+	}
+}
+
+func handleResponsesStreamRequest(c *gin.Context, p provider.Provider, jsonData []byte, eventSource claudeEventSource, finish func() []map[string]interface{}, builder *responsesBuilder) {
+	c.Header("Content-Type", "text/event-stream")
+	c.Header("Cache-Control", "no-cache")
+	c.Header("Connection", "keep-alive")
+
+	send := func(events []map[string]interface{}) bool {
+		for _, event := range events {
+			if err := sendTypedSSEvent(c, event); err != nil {
+				return false
+			}
+		}
+		return true
+	}
+
+	started, ended := false, false
+	err := relayChatRequest(c, p, jsonData, func(event cycletls.SSEEvent) bool {
+		if !started {
+			started = true
+			if !send(builder.begin()) {
+				return false
+			}
+		}
+		events, done := eventSource(event)
+		ended = done
+		for _, event := range events {
+			if !send(builder.add(event)) {
+				return false
+			}
+		}
+		return !done
+	})
+	if err == nil && !ended && c.Request.Context().Err() == nil {
+		err = errIncompleteResponse
+	}
+	if err != nil {
+		if !started {
+			writeError(c, err)
+			return
+		}
+		// 流已开始,以response.failed结束
+		builder.fail(newAPIError(err))
+	}
+	if !started {
+		send(builder.begin())
+	}
+	if builder.failed == nil {
+		for _, event := range finish() {
+			send(builder.add(event))
+		}
+	}
+	send(builder.finish())
+}
+
+func handleResponsesNonStreamRequest(c *gin.Context, p provider.Provider, jsonData []byte, eventSource claudeEventSource, finish func() []map[string]interface{}, builder *responsesBuilder) {
+	builder.begin()
+	ended := false
+	err := relayChatRequest(c, p, jsonData, func(event cycletls.SSEEvent) bool {
+		events, done := eventSource(event)
+		ended = done
+		for _, event := range events {
+			builder.add(event)
+		}
+		return !done
+	})
+	if err == nil && !ended && c.Request.Context().Err() == nil {
+		err = errIncompleteResponse
+	}
+	if err != nil {
+		writeError(c, err)
+		return
+	}
+	for _, event := range finish() {
+		builder.add(event)
+	}
+	builder.finish()
+	// 上游在流中返回错误时按错误类型返回状态码,而非200的failed响应
+	if builder.failedError != nil {
+		writeAPIError(c, *builder.failedError)
+		return
+	}
+	c.JSON(http.StatusOK, builder.response)
+}
+
+// responsesOutputItem Responses输出项及其对应的上游内容块
+type responsesOutputItem struct {
+	outputIndex int
+	item        map[string]interface{}
+	text        strings.Builder
+}
+
+// responsesBuilder 将Anthropic流式事件转换为Responses接口的流式事件并构建最终的response对象
+type responsesBuilder struct {
+	response     map[string]interface{}
+	output       []map[string]interface{}
+	items        map[int]*responsesOutputItem
+	sequence     int
+	stopReason   string
+	inputTokens  int
+	cachedTokens int
+	outputTokens int
+	failed       map[string]interface{}
+	failedError  *apiError
+	// jsonData 上游请求体,上游未返回用量时用于估算输入token
+	jsonData []byte
+	// reasoning与completion 已下发的思考内容与其他输出内容,用于统计推理token及估算输出token
+	reasoning  strings.Builder
+	completion strings.Builder
+}
+
+func newResponsesBuilder(responsesReq model.OpenAIResponsesRequest, jsonData []byte) *responsesBuilder {
+	var instructions interface{}
+	if responsesReq.Instructions != "" {
+		instructions = responsesReq.Instructions
+	}
+	var maxOutputTokens interface{}
+	if responsesReq.MaxOutputTokens > 0 {
+		maxOutputTokens = responsesReq.MaxOutputTokens
+	}
+	parallelToolCalls := true
+	if responsesReq.ParallelToolCalls != nil {
+		parallelToolCalls = *responsesReq.ParallelToolCalls
+	}
+	toolChoice := responsesReq.ToolChoice
+	if toolChoice == nil {
+		toolChoice = "auto"
+	}
+	tools := responsesReq.Tools
+	if tools == nil {
+		tools = []model.OpenAIResponsesTool{}
+	}
+
+	return &responsesBuilder{
+		response: map[string]interface{}{
+			"id":                  "resp_" + common.GetUUID(),
+			"object":              "response",
+			"created_at":          time.Now().Unix(),
+			"status":              "in_progress",
+			"error":               nil,
+			"incomplete_details":  nil,
+			"instructions":        instructions,
+			"max_output_tokens":   maxOutputTokens,
+			"model":               responsesReq.Model,
+			"output":              []map[string]interface{}{},
+			"parallel_tool_calls": parallelToolCalls,
+			"reasoning":           responsesReq.Reasoning,
+			"temperature":         responsesReq.Temperature,
+			"top_p":               responsesReq.TopP,
+			"tool_choice":         toolChoice,
+			"tools":               tools,
+			"metadata":            responsesReq.Metadata,
+			"usage":               nil,
+		},
+		items:      make(map[int]*responsesOutputItem),
+		stopReason: "end_turn",
+		jsonData:   jsonData,
+	}
+}
+
+// event 创建带序号的Responses事件
+func (b *responsesBuilder) event(eventType string, fields map[string]interface{}) map[string]interface{} {
+	event := map[string]interface{}{
+		"type":            eventType,
+		"sequence_number": b.sequence,
+	}
+	for key, value := range fields {
+		event[key] = value
+	}
+	b.sequence++
+	return event
+}
+
+// begin 返回response.created与response.in_progress事件
+func (b *responsesBuilder) begin() []map[string]interface{} {
+	return []map[string]interface{}{
+		b.event("response.created", map[string]interface{}{"response": copyMap(b.response)}),
+		b.event("response.in_progress", map[string]interface{}{"response": copyMap(b.response)}),
+	}
+}
+
+// updateInputUsage 累加缓存命中与缓存写入的输入token,未携带输入用量时保留原值
+func (b *responsesBuilder) updateInputUsage(usage map[string]interface{}) {
+	inputTokens := common.ToInt(usage["input_tokens"]) + common.ToInt(usage["cache_creation_input_tokens"]) + common.ToInt(usage["cache_read_input_tokens"])
+	if inputTokens > 0 {
+		b.inputTokens = inputTokens
+		b.cachedTokens = common.ToInt(usage["cache_read_input_tokens"])
+	}
+}
+
+// add 处理一条Anthropic事件,返回需要下发的Responses事件
+func (b *responsesBuilder) add(event map[string]interface{}) []map[string]interface{} {
+	switch event["type"] {
+	case "message_start":
+		if message, ok := event["message"].(map[string]interface{}); ok {
+			if usage, ok := message["usage"].(map[string]interface{}); ok {
+				b.updateInputUsage(usage)
+			}
+		}
+	case "content_block_start":
+		block, _ := event["content_block"].(map[string]interface{})
+		return b.startItem(common.ToInt(event["index"]), block)
+	case "content_block_delta":
+		delta, _ := event["delta"].(map[string]interface{})
+		return b.deltaItem(common.ToInt(event["index"]), delta)
+	case "content_block_stop":
+		return b.stopItem(common.ToInt(event["index"]))
+	case "message_delta":
+		if delta, ok := event["delta"].(map[string]interface{}); ok {
+			if stopReason, ok := delta["stop_reason"].(string); ok {
+				b.stopReason = stopReason
+			}
+		}
+		if usage, ok := event["usage"].(map[string]interface{}); ok {
+			b.updateInputUsage(usage)
+			b.outputTokens = common.ToInt(usage["output_tokens"])
+		}
+	case "error":
+		body, _ := json.Marshal(event)
+		b.fail(newAPIError(provider.ClassifyUpstreamError(http.StatusOK, nil, string(body))))
+	}
+	return nil
+}
+
+// fail 记录导致响应失败的错误,finish时以response.failed结束,非流式请求按其状态码返回
+func (b *responsesBuilder) fail(apiErr apiError) {
+	b.failedError = &apiErr
+	b.failed = map[string]interface{}{
+		"code":    apiErr.error.Code,
+		"message": apiErr.error.Message,
+	}
+}
+
+func (b *responsesBuilder) startItem(blockIndex int, block map[string]interface{}) []map[string]interface{} {
+	var item map[string]interface{}
+	switch block["type"] {
+	case "thinking":
+		item = map[string]interface{}{
+			"id":      "rs_" + common.GetUUID(),
+			"type":    "reasoning",
+			"summary": []interface{}{},
+		}
+	case "text":
+		item = map[string]interface{}{
+			"id":      "msg_" + common.GetUUID(),
+			"type":    "message",
+			"status":  "in_progress",
+			"role":    "assistant",
+			"content": []interface{}{},
+		}
+	case "tool_use":
+		id, _ := block["id"].(string)
+		name, _ := block["name"].(string)
+		item = map[string]interface{}{
+			"id":        "fc_" + common.GetUUID(),
+			"type":      "function_call",
+			"status":    "in_progress",
+			"call_id":   id,
+			"name":      name,
+			"arguments": "",
+		}
+	default:
+		return nil
+	}
+
+	outputItem := &responsesOutputItem{
+		outputIndex: len(b.output),
+		item:        item,
+	}
+	b.items[blockIndex] = outputItem
+	b.output = append(b.output, item)
+
+	events := []map[string]interface{}{
+		b.event("response.output_item.added", map[string]interface{}{
+			"output_index": outputItem.outputIndex,
+			"item":         copyMap(item),
+		}),
+	}
+	switch item["type"] {
+	case "reasoning":
+		events = append(events, b.event("response.reasoning_summary_part.added", map[string]interface{}{
+			"item_id":       item["id"],
+			"output_index":  outputItem.outputIndex,
+			"summary_index": 0,
+			"part":          map[string]interface{}{"type": "summary_text", "text": ""},
+		}))
+	case "message":
+		events = append(events, b.event("response.content_part.added", map[string]interface{}{
+			"item_id":       item["id"],
+			"output_index":  outputItem.outputIndex,
+			"content_index": 0,
+			"part":          map[string]interface{}{"type": "output_text", "text": "", "annotations": []interface{}{}},
+		}))
+	}
+	return events
+}
+
+func (b *responsesBuilder) deltaItem(blockIndex int, delta map[string]interface{}) []map[string]interface{} {
+	outputItem, ok := b.items[blockIndex]
+	if !ok {
+		return nil
+	}
+	item := outputItem.item
+
+	switch delta["type"] {
+	case "thinking_delta":
+		thinking, _ := delta["thinking"].(string)
+		outputItem.text.WriteString(thinking)
+		return []map[string]interface{}{b.event("response.reasoning_summary_text.delta", map[string]interface{}{
+			"item_id":       item["id"],
+			"output_index":  outputItem.outputIndex,
+			"summary_index": 0,
+			"delta":         thinking,
+		})}
+	case "text_delta":
+		text, _ := delta["text"].(string)
+		outputItem.text.WriteString(text)
+		return []map[string]interface{}{b.event("response.output_text.delta", map[string]interface{}{
+			"item_id":       item["id"],
+			"output_index":  outputItem.outputIndex,
+			"content_index": 0,
+			"delta":         text,
+		})}
+	case "signature_delta":
+		// 思考块签名作为encrypted_content返回,客户端回传reasoning项时用于重建thinking块
+		signature, _ := delta["signature"].(string)
+		item["encrypted_content"] = signature
+	case "input_json_delta":
+		partial, _ := delta["partial_json"].(string)
+		outputItem.text.WriteString(partial)
+		return []map[string]interface{}{b.event("response.function_call_arguments.delta", map[string]interface{}{
+			"item_id":      item["id"],
+			"output_index": outputItem.outputIndex,
+			"delta":        partial,
+		})}
+	}
+	return nil
+}
+
+func (b *responsesBuilder) stopItem(blockIndex int) []map[string]interface{} {
+	outputItem, ok := b.items[blockIndex]
+	if !ok {
+		return nil
+	}
+	delete(b.items, blockIndex)
+	item := outputItem.item
+	text := outputItem.text.String()
+
+	var events []map[string]interface{}
+	switch item["type"] {
+	case "reasoning":
+		b.reasoning.WriteString(text)
+		part := map[string]interface{}{"type": "summary_text", "text": text}
+		item["summary"] = []interface{}{part}
+		events = append(events,
+			b.event("response.reasoning_summary_text.done", map[string]interface{}{
+				"item_id":       item["id"],
+				"output_index":  outputItem.outputIndex,
+				"summary_index": 0,
+				"text":          text,
+			}),
+			b.event("response.reasoning_summary_part.done", map[string]interface{}{
+				"item_id":       item["id"],
+				"output_index":  outputItem.outputIndex,
+				"summary_index": 0,
+				"part":          part,
+			}),
+		)
+	case "message":
+		b.completion.WriteString(text)
+		part := map[string]interface{}{"type": "output_text", "text": text, "annotations": []interface{}{}}
+		item["content"] = []interface{}{part}
+		item["status"] = "completed"
+		events = append(events,
+			b.event("response.output_text.done", map[string]interface{}{
+				"item_id":       item["id"],
+				"output_index":  outputItem.outputIndex,
+				"content_index": 0,
+				"text":          text,
+			}),
+			b.event("response.content_part.done", map[string]interface{}{
+				"item_id":       item["id"],
+				"output_index":  outputItem.outputIndex,
+				"content_index": 0,
+				"part":          part,
+			}),
+		)
+	case "function_call":
+		name, _ := item["name"].(string)
+		b.completion.WriteString(name + text)
+		item["arguments"] = text
+		item["status"] = "completed"
+		events = append(events, b.event("response.function_call_arguments.done", map[string]interface{}{
+			"item_id":      item["id"],
+			"output_index": outputItem.outputIndex,
+			"arguments":    text,
+		}))
+	}
+
+	return append(events, b.event("response.output_item.done", map[string]interface{}{
+		"output_index": outputItem.outputIndex,
+		"item":         copyMap(item),
+	}))
+}
+
+// finish 关闭未结束的输出项并返回最终的response事件
+func (b *responsesBuilder) finish() []map[string]interface{} {
+	var events []map[string]interface{}
+	blockIndexes := make([]int, 0, len(b.items))
+	for blockIndex := range b.items {
+		blockIndexes = append(blockIndexes, blockIndex)
+	}
+	sort.Ints(blockIndexes)
+	for _, blockIndex := range blockIndexes {
+		events = append(events, b.stopItem(blockIndex)...)
+	}
+
+	b.response["output"] = b.output
+	usage := b.usage()
+	var cachedTokens, reasoningTokens int
+	if usage.PromptTokensDetails != nil {
+		cachedTokens = usage.PromptTokensDetails.CachedTokens
+	}
+	if usage.CompletionTokensDetails != nil {
+		reasoningTokens = usage.CompletionTokensDetails.ReasoningTokens
+	}
+	b.response["usage"] = map[string]interface{}{
+		"input_tokens":          usage.PromptTokens,
+		"input_tokens_details":  map[string]interface{}{"cached_tokens": cachedTokens},
+		"output_tokens":         usage.CompletionTokens,
+		"output_tokens_details": map[string]interface{}{"reasoning_tokens": reasoningTokens},
+		"total_tokens":          usage.TotalTokens,
+	}
+
+	eventType := "response.completed"
+	switch {
+	case b.failed != nil:
+		eventType = "response.failed"
+		b.response["status"] = "failed"
+		b.response["error"] = b.failed
+	case b.stopReason == "max_tokens":
+		eventType = "response.incomplete"
+		b.response["status"] = "incomplete"
+		b.response["incomplete_details"] = map[string]interface{}{"reason": "max_output_tokens"}
+	default:
+		b.response["status"] = "completed"
+	}
+	return append(events, b.event(eventType, map[string]interface{}{"response": copyMap(b.response)}))
+}
+
+// usage 上游未返回用量时与对话接口一致按请求体与输出内容估算。
+// Anthropic事件不携带推理token,按已下发的思考内容计数
+func (b *responsesBuilder) usage() *model.OpenAIUsage {
+	modelName, _ := b.response["model"].(string)
+	if b.inputTokens == 0 && b.outputTokens == 0 {
+		return estimateUsage(b.jsonData, modelName, b.reasoning.String(), b.completion.String())
+	}
+	reasoningTokens := 0
+	if b.reasoning.Len() > 0 {
+		reasoningTokens = min(model.CountTokenText(b.reasoning.String(), modelName), b.outputTokens)
+	}
+	return toOpenAIUsage(provider.Usage{
+		PromptTokens:     b.inputTokens,
+		CompletionTokens: b.outputTokens,
+		CachedTokens:     b.cachedTokens,
+		ReasoningTokens:  reasoningTokens,
+	})
+}
+
+// copyMap 浅拷贝map,避免后续修改影响已发送的事件
+func copyMap(source map[string]interface{}) map[string]interface{} {
+	target := make(map[string]interface{}, len(source))
+	for key, value := range source {
+		target[key] = value
+	}
+	return target
+}
@@ -0,0 +1,45 @@
+package controller
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestResponsesTruncatedStream(t *testing.T) {
+	truncated := []string{`{"choices":[{"index":0,"delta":{"role":"assistant","content":"partial"},"finish_reason":null}]}`}
+
+	t.Run("non-stream", func(t *testing.T) {
+		fakeUpstream(t, "openrouter", truncated...)
+		w := serve(ResponsesForOpenAI, "/v1/responses", `{"model":"gpt-4.1","input":"hi"}`)
+		if w.Code != http.StatusBadGateway {
+			t.Fatalf("status = %d, body %s", w.Code, w.Body.String())
+		}
+	})
+
+	t.Run("stream", func(t *testing.T) {
+		fakeUpstream(t, "openrouter", truncated...)
+		w := serve(ResponsesForOpenAI, "/v1/responses", `{"model":"gpt-4.1","input":"hi","stream":true}`)
+		data := sseData(t, w.Body.String())
+		if len(data) == 0 {
+			t.Fatal("empty stream")
+		}
+		// 截断的流以response.failed结束,而不是response.completed
+		last := decodeJSON(t, data[len(data)-1])
+		response, _ := last["response"].(map[string]interface{})
+		if last["type"] != "response.failed" || response["status"] != "failed" {
+			t.Fatalf("last event = %v", last)
+		}
+	})
+}
+
+func TestResponsesUpstreamErrorStatus(t *testing.T) {
+	fakeUpstream(t, "openrouter",
+		`{"choices":[{"index":0,"delta":{"role":"assistant","content":"partial"},"finish_reason":null}]}`,
+		`{"error":{"message":"Rate limit exceeded","code":429}}`,
+	)
+	w := serve(ResponsesForOpenAI, "/v1/responses", `{"model":"gpt-4.1","input":"hi"}`)
+	// 流中返回的错误按错误类型返回状态码,而非200的failed响应
+	if w.Code != http.StatusTooManyRequests {
+		t.Fatalf("status = %d, body %s", w.Code, w.Body.String())
+	}
+}
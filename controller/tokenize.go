@@ -0,0 +1,81 @@
+package controller
+
+import (
+	"github.com/gin-gonic/gin"
+	logger "kilo2api/common/loggger"
+	"kilo2api/model"
+	"net/http"
+)
+
+// CountTokensForClaude @Summary Anthropic token计数接口
+// @Description 按对话接口的转换链路构造上游请求并统计输入token,不发起生成
+// @Tags Anthropic
+// @Accept json
+// @Produce json
+// @Param req body model.ClaudeMessagesRequest true "Anthropic Messages请求"
+// @Param x-api-key header string true "API-KEY"
+// @Router /v1/messages/count_tokens [post]
+func CountTokensForClaude(c *gin.Context) {
+	var claudeReq model.ClaudeMessagesRequest
+	if err := c.ShouldBindJSON(&claudeReq); err != nil {
+		logger.Errorf(c.Request.Context(), err.Error())
+		writeClaudeAPIError(c, bindError(err))
+		return
+	}
+
+	modelInfo, p, b := getModelProvider(claudeReq.Model)
+	if !b {
+		writeClaudeAPIError(c, modelNotFoundError(claudeReq.Model))
+		return
+	}
+
+	openAIReq, ok := checkClaudeRequest(c, p, claudeReq, modelInfo)
+	if !ok {
+		return
+	}
+	requestBody, err := buildRequestBody(c, p, &openAIReq, modelInfo)
+	if err != nil {
+		writeClaudeError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"input_tokens": model.CountRequestBodyTokens(requestBody, claudeReq.Model)})
+}
+
+// TokenizeForOpenAI @Summary OpenAI token计数接口
+// @Description 按对话接口的转换链路构造上游请求并统计输入token,不发起生成
+// @Tags OpenAI
+// @Accept json
+// @Produce json
+// @Param req body model.OpenAITokenizeRequest true "对话请求或prompt"
+// @Param Authorization header string true "Authorization API-KEY"
+// @Success 200 {object} model.OpenAITokenizeResponse "成功"
+// @Router /v1/tokenize [post]
+func TokenizeForOpenAI(c *gin.Context) {
+	var tokenizeReq model.OpenAITokenizeRequest
+	if err := c.ShouldBindJSON(&tokenizeReq); err != nil {
+		logger.Errorf(c.Request.Context(), err.Error())
+		writeAPIError(c, bindError(err))
+		return
+	}
+
+	openAIReq := tokenizeReq.OpenAIChatCompletionRequest
+	if len(openAIReq.Messages) == 0 && tokenizeReq.Prompt != "" {
+		openAIReq.Messages = []model.OpenAIChatMessage{{Role: "user", Content: tokenizeReq.Prompt}}
+	}
+	modelInfo, p, ok := checkChatRequest(c, &openAIReq)
+	if !ok {
+		return
+	}
+	requestBody, err := buildRequestBody(c, p, &openAIReq, modelInfo)
+	if err != nil {
+		writeError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, model.OpenAITokenizeResponse{
+		Model:       openAIReq.Model,
+		Count:       model.CountRequestBodyTokens(requestBody, openAIReq.Model),
+		MaxModelLen: modelInfo.ContextWindow,
+	})
+}
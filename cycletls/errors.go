@@ -66,6 +66,28 @@ func parseError(err error) (errormessage errorMessage) {
 	return
 }
 
+// 请求构造失败的环节
+const (
+	// RequestOpProxy 代理地址无效
+	RequestOpProxy = "proxy"
+	// RequestOpURL 请求地址无效
+	RequestOpURL = "url"
+)
+
+// RequestError 请求构造失败,请求未发往上游。Err可能包含代理地址等配置内容
+type RequestError struct {
+	Op  string
+	Err error
+}
+
+func (e *RequestError) Error() string {
+	return fmt.Sprintf("invalid %s: %v", e.Op, e.Err)
+}
+
+func (e *RequestError) Unwrap() error {
+	return e.Err
+}
+
 type errExtensionNotExist struct {
 	Context string
 }
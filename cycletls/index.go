@@ -1,7 +1,7 @@
 package cycletls
 
 import (
-	"bufio"
+	"context"
 	"encoding/json"
 	"flag"
 	"fmt"
@@ -14,7 +14,6 @@ import (
 	"os"
 	"runtime"
 	"strings"
-	"time"
 )
 
 // Options sets CycleTLS client options
@@ -45,6 +44,8 @@ type fullRequest struct {
 	req     *http.Request
 	client  http.Client
 	options cycleTLSRequest
+	// shared client来自共享连接池,请求结束后不关闭连接
+	shared bool
 }
 
 // Response contains Cycletls response data
@@ -74,7 +75,8 @@ type CycleTLS struct {
 }
 
 // ready Request
-func processRequest(request cycleTLSRequest) (result fullRequest) {
+// processRequest 构造请求,代理地址或URL无效时返回*RequestError
+func processRequest(request cycleTLSRequest) (result fullRequest, err error) {
 	var browser = Browser{
 		JA3:                request.Options.Ja3,
 		UserAgent:          request.Options.UserAgent,
@@ -83,20 +85,19 @@ func processRequest(request cycleTLSRequest) (result fullRequest) {
 		forceHTTP1:         request.Options.ForceHTTP1,
 	}
 
-	client, err := newClient(
+	client, shared, err := getClient(
 		browser,
 		request.Options.Timeout,
 		request.Options.DisableRedirect,
-		request.Options.UserAgent,
 		request.Options.Proxy,
 	)
 	if err != nil {
-		log.Fatal(err)
+		return result, &RequestError{Op: RequestOpProxy, Err: err}
 	}
 
 	req, err := http.NewRequest(strings.ToUpper(request.Options.Method), request.Options.URL, strings.NewReader(request.Options.Body))
 	if err != nil {
-		log.Fatal(err)
+		return result, &RequestError{Op: RequestOpURL, Err: err}
 	}
 	headerorder := []string{}
 	//master header order, all your headers will be ordered based on this list and anything extra will be appended to the end
@@ -161,7 +162,7 @@ func processRequest(request cycleTLSRequest) (result fullRequest) {
 	//set our Host header
 	u, err := url.Parse(request.Options.URL)
 	if err != nil {
-		panic(err)
+		return result, &RequestError{Op: RequestOpURL, Err: err}
 	}
 
 	//append our normal headers
@@ -172,12 +173,14 @@ func processRequest(request cycleTLSRequest) (result fullRequest) {
 	}
 	req.Header.Set("Host", u.Host)
 	req.Header.Set("user-agent", request.Options.UserAgent)
-	return fullRequest{req: req, client: client, options: request}
+	return fullRequest{req: req, client: client, options: request, shared: shared}, nil
 
 }
 
 func dispatcher(res fullRequest) (response Response, err error) {
-	defer res.client.CloseIdleConnections()
+	if !res.shared {
+		defer res.client.CloseIdleConnections()
+	}
 	finalUrl := res.options.Options.URL
 	resp, err := res.client.Do(res.req)
 	if err != nil {
@@ -228,18 +231,27 @@ func dispatcher(res fullRequest) (response Response, err error) {
 }
 
 // Queue queues request in worker pool
-func (client CycleTLS) Queue(URL string, options Options, Method string) {
+func (client CycleTLS) Queue(URL string, options Options, Method string) error {
 
 	options.URL = URL
 	options.Method = Method
 	//TODO add timestamp to request
 	opt := cycleTLSRequest{"Queued Request", options}
-	response := processRequest(opt)
+	response, err := processRequest(opt)
+	if err != nil {
+		return err
+	}
 	client.ReqChan <- response
+	return nil
 }
 
 // Do creates a single request
 func (client CycleTLS) Do(URL string, options Options, Method string) (response Response, err error) {
+	return client.DoWithContext(context.Background(), URL, options, Method)
+}
+
+// DoWithContext creates a single request that is aborted when ctx is done
+func (client CycleTLS) DoWithContext(ctx context.Context, URL string, options Options, Method string) (response Response, err error) {
 
 	options.URL = URL
 	options.Method = Method
@@ -253,11 +265,18 @@ func (client CycleTLS) Do(URL string, options Options, Method string) (response
 	}
 	opt := cycleTLSRequest{"cycleTLSRequest", options}
 
-	res := processRequest(opt)
+	res, err := processRequest(opt)
+	if err != nil {
+		return response, err
+	}
+	res.req = res.req.WithContext(ctx)
 	response, err = dispatcher(res)
 	if err != nil {
 		return response, err
 	}
+	if ctx.Err() != nil {
+		return response, ctx.Err()
+	}
 
 	return response, nil
 }
@@ -317,7 +336,11 @@ func readSocket(reqChan chan fullRequest, c *websocket.Conn) {
 			return
 		}
 
-		reply := processRequest(*request)
+		reply, err := processRequest(*request)
+		if err != nil {
+			log.Print("Request Error", err)
+			continue
+		}
 
 		reqChan <- reply
 	}
@@ -372,7 +395,7 @@ func WSEndpoint(w nhttp.ResponseWriter, r *nhttp.Request) {
 		}
 		headers, err := PrettyStruct(r.Header)
 		if err != nil {
-			log.Fatal(err)
+			log.Print("Invalid Request:", err)
 		}
 		log.Println(headers)
 		log.Println(body)
@@ -413,25 +436,49 @@ type SSEResponse struct {
 	RequestID string
 	Status    int
 	Data      string
+	Event     string            // SSE事件名
+	ID        string            // SSE事件ID
+	Headers   map[string]string // 仅非2xx响应携带,用于读取Retry-After等错误信息
 	Done      bool
 	FinalUrl  string // 添加 FinalUrl 字段
 }
 
-func dispatcherSSE(res fullRequest, sseChan chan<- SSEResponse) {
-	defer res.client.CloseIdleConnections()
+// SSEEvent 返回响应对应的SSE事件
+func (r SSEResponse) SSEEvent() SSEEvent {
+	return SSEEvent{Event: r.Event, Data: r.Data, ID: r.ID}
+}
+
+func dispatcherSSE(ctx context.Context, res fullRequest, sseChan chan<- SSEResponse) {
+	if !res.shared {
+		defer res.client.CloseIdleConnections()
+	}
+
+	// send 发送响应,ctx结束(调用方已放弃读取)时返回false
+	send := func(response SSEResponse) bool {
+		select {
+		case sseChan <- response:
+			return true
+		case <-ctx.Done():
+			return false
+		}
+	}
 
 	finalUrl := res.options.Options.URL
 
 	resp, err := res.client.Do(res.req)
 	if err != nil {
+		if ctx.Err() != nil {
+			// 调用方已取消,无需返回错误
+			return
+		}
 		parsedError := parseError(err)
-		sseChan <- SSEResponse{
+		send(SSEResponse{
 			RequestID: res.options.RequestID,
 			Status:    parsedError.StatusCode,
 			Data:      fmt.Sprintf("%s-> \n%s", parsedError.ErrorMsg, err.Error()),
 			Done:      true,
 			FinalUrl:  finalUrl,
-		}
+		})
 		return
 	}
 	defer resp.Body.Close()
@@ -439,18 +486,23 @@ func dispatcherSSE(res fullRequest, sseChan chan<- SSEResponse) {
 	// 检查HTTP状态码，非2xx状态码可能表示错误
 	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
 		bodyBytes, _ := io.ReadAll(resp.Body)
-		errorMsg := string(bodyBytes)
+		errorMsg := DecompressBody(bodyBytes, resp.Header["Content-Encoding"], resp.Header["Content-Type"])
 		if errorMsg == "" {
 			errorMsg = fmt.Sprintf("HTTP error status: %d", resp.StatusCode)
 		}
+		headers := make(map[string]string)
+		for name, values := range resp.Header {
+			headers[name] = strings.Join(values, ",")
+		}
 
-		sseChan <- SSEResponse{
+		send(SSEResponse{
 			RequestID: res.options.RequestID,
 			Status:    resp.StatusCode,
 			Data:      errorMsg,
+			Headers:   headers,
 			Done:      true,
 			FinalUrl:  finalUrl,
-		}
+		})
 		return
 	}
 
@@ -459,77 +511,58 @@ func dispatcherSSE(res fullRequest, sseChan chan<- SSEResponse) {
 		finalUrl = resp.Request.URL.String()
 	}
 
-	reader := bufio.NewReader(resp.Body)
-	const maxRetries = 3
-	retries := 0
-
+	decoder := NewSSEDecoder(resp.Body)
 	for {
-		// 读取直到换行符
-		line, err := reader.ReadString('\n')
+		event, err := decoder.Next()
+		if err == io.EOF {
+			break
+		}
 		if err != nil {
-			if err == io.EOF {
-				break
-			}
-
-			if retries < maxRetries {
-				retries++
-				time.Sleep(time.Second * time.Duration(retries))
-				continue
+			if ctx.Err() != nil {
+				// 调用方已取消,上游连接随请求一同中断
+				return
 			}
-
-			sseChan <- SSEResponse{
+			send(SSEResponse{
 				RequestID: res.options.RequestID,
 				Status:    resp.StatusCode,
 				Data:      "Error reading stream: " + err.Error(),
 				Done:      true,
 				FinalUrl:  finalUrl,
-			}
+			})
 			return
 		}
 
-		// 重置重试计数
-		retries = 0
-
-		// 去除行尾的空白字符
-		line = strings.TrimSpace(line)
-
-		// 跳过空行
-		if line == "" {
-			continue
-		}
-
-		// 处理数据行
-		if strings.HasPrefix(line, "data: ") {
-			data := strings.TrimSpace(strings.TrimPrefix(line, "data: "))
-			if data != "" {
-				sseChan <- SSEResponse{
-					RequestID: res.options.RequestID,
-					Status:    resp.StatusCode,
-					Data:      data,
-					Done:      false,
-					FinalUrl:  finalUrl,
-				}
-			}
-		}
-
-		// 检查是否有结束标记
-		if strings.HasSuffix(line, "[DONE]") {
-			break
+		if !send(SSEResponse{
+			RequestID: res.options.RequestID,
+			Status:    resp.StatusCode,
+			Data:      event.Data,
+			Event:     event.Event,
+			ID:        event.ID,
+			Done:      false,
+			FinalUrl:  finalUrl,
+		}) {
+			return
 		}
 	}
 
 	// 发送完成信号
-	sseChan <- SSEResponse{
+	send(SSEResponse{
 		RequestID: res.options.RequestID,
 		Status:    resp.StatusCode,
 		Data:      "",
 		Done:      true,
 		FinalUrl:  finalUrl,
-	}
+	})
 }
 
 // 修改 Do 方法以支持 SSE
 func (client CycleTLS) DoSSE(URL string, options Options, Method string) (<-chan SSEResponse, error) {
+	return client.DoSSEWithContext(context.Background(), URL, options, Method)
+}
+
+// DoSSEWithContext 发起SSE请求,ctx结束时中断上游请求并关闭返回的通道。
+// 调用方不再读取通道时应取消ctx,否则发送协程会一直阻塞。
+func (client CycleTLS) DoSSEWithContext(ctx context.Context, URL string, options Options, Method string) (<-chan SSEResponse, error) {
 	sseChan := make(chan SSEResponse)
 
 	options.URL = URL
@@ -542,11 +575,15 @@ func (client CycleTLS) DoSSE(URL string, options Options, Method string) (<-chan
 	}
 
 	opt := cycleTLSRequest{"cycleTLSRequest", options}
-	res := processRequest(opt)
+	res, err := processRequest(opt)
+	if err != nil {
+		return nil, err
+	}
+	res.req = res.req.WithContext(ctx)
 
 	go func() {
 		defer close(sseChan)
-		dispatcherSSE(res, sseChan)
+		dispatcherSSE(ctx, res, sseChan)
 	}()
 
 	return sseChan, nil
@@ -0,0 +1,52 @@
+package cycletls
+
+import (
+	http "github.com/Danny-Dasilva/fhttp"
+	"sync"
+)
+
+// clientKey 影响连接能否复用的传输设置,相同设置的请求共享同一个client及其连接池
+type clientKey struct {
+	ja3                string
+	userAgent          string
+	proxy              string
+	timeout            int
+	disableRedirect    bool
+	insecureSkipVerify bool
+	forceHTTP1         bool
+}
+
+var (
+	sharedClients   = make(map[clientKey]http.Client)
+	sharedClientsMu sync.Mutex
+)
+
+// getClient 返回传输设置对应的长连接client,首次使用时创建。
+// 携带Cookies的请求会把cookie固定在roundTripper中,不能共享,每次单独创建
+func getClient(browser Browser, timeout int, disableRedirect bool, proxyURL string) (client http.Client, shared bool, err error) {
+	if len(browser.Cookies) > 0 {
+		client, err = newClient(browser, timeout, disableRedirect, browser.UserAgent, proxyURL)
+		return client, false, err
+	}
+
+	key := clientKey{
+		ja3:                browser.JA3,
+		userAgent:          browser.UserAgent,
+		proxy:              proxyURL,
+		timeout:            timeout,
+		disableRedirect:    disableRedirect,
+		insecureSkipVerify: browser.InsecureSkipVerify,
+		forceHTTP1:         browser.forceHTTP1,
+	}
+	sharedClientsMu.Lock()
+	defer sharedClientsMu.Unlock()
+	if client, ok := sharedClients[key]; ok {
+		return client, true, nil
+	}
+	client, err = newClient(browser, timeout, disableRedirect, browser.UserAgent, proxyURL)
+	if err != nil {
+		return client, false, err
+	}
+	sharedClients[key] = client
+	return client, true, nil
+}
@@ -0,0 +1,134 @@
+package cycletls
+
+import (
+	"context"
+	"fmt"
+	"net"
+	nhttp "net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// newSSEServer 本地TLS上游替身,返回一条事件后结束流,并统计新建连接数
+func newSSEServer(t testing.TB, enableHTTP2 bool) (*httptest.Server, *int64) {
+	t.Helper()
+	var conns int64
+	server := httptest.NewUnstartedServer(nhttp.HandlerFunc(func(w nhttp.ResponseWriter, r *nhttp.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		fmt.Fprint(w, "data: hello\n\n")
+		w.(nhttp.Flusher).Flush()
+		fmt.Fprint(w, "data: [DONE]\n\n")
+	}))
+	server.EnableHTTP2 = enableHTTP2
+	server.Config.ConnState = func(_ net.Conn, state nhttp.ConnState) {
+		if state == nhttp.StateNew {
+			atomic.AddInt64(&conns, 1)
+		}
+	}
+	server.StartTLS()
+	t.Cleanup(server.Close)
+	return server, &conns
+}
+
+func sseOptions(forceHTTP1 bool) Options {
+	return Options{
+		InsecureSkipVerify: true,
+		Timeout:            30,
+		ForceHTTP1:         forceHTTP1,
+		Headers:            map[string]string{"Content-Type": "application/json"},
+	}
+}
+
+// timeToFirstEvent 发起SSE请求并读完整个流,返回收到第一条事件的耗时。
+// shared为false时模拟共享连接池之前的行为:每个请求新建client,结束后关闭连接
+func timeToFirstEvent(t testing.TB, url string, options Options, shared bool) time.Duration {
+	t.Helper()
+	start := time.Now()
+	var sseChan <-chan SSEResponse
+	if shared {
+		var err error
+		if sseChan, err = Init().DoSSEWithContext(context.Background(), url, options, "POST"); err != nil {
+			t.Fatal(err)
+		}
+	} else {
+		options.URL, options.Method = url, "POST"
+		options.Ja3 = "771,4865-4866-4867-49195-49199-49196-49200-52393-52392-49171-49172-156-157-47-53,18-35-65281-45-17513-27-65037-16-10-11-5-13-0-43-23-51,29-23-24,0"
+		options.UserAgent = "Mozilla/5.0 (Macintosh; Intel Mac OS X 10_15_7) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/121.0.0.0 Safari/537.36"
+		res, err := processRequest(cycleTLSRequest{"cycleTLSRequest", options})
+		if err != nil {
+			t.Fatal(err)
+		}
+		browser := Browser{JA3: options.Ja3, UserAgent: options.UserAgent, InsecureSkipVerify: true, forceHTTP1: options.ForceHTTP1}
+		if res.client, err = newClient(browser, options.Timeout, false, options.UserAgent); err != nil {
+			t.Fatal(err)
+		}
+		res.shared = false
+		ch := make(chan SSEResponse)
+		go func() {
+			defer close(ch)
+			dispatcherSSE(context.Background(), res, ch)
+		}()
+		sseChan = ch
+	}
+
+	var first time.Duration
+	for response := range sseChan {
+		if first == 0 {
+			first = time.Since(start)
+			if response.Status != nhttp.StatusOK {
+				t.Fatalf("status %d: %s", response.Status, response.Data)
+			}
+		}
+	}
+	return first
+}
+
+func TestSharedClientReusesConnection(t *testing.T) {
+	for _, forceHTTP1 := range []bool{false, true} {
+		t.Run(fmt.Sprintf("forceHTTP1=%v", forceHTTP1), func(t *testing.T) {
+			server, conns := newSSEServer(t, true)
+			for i := 0; i < 5; i++ {
+				timeToFirstEvent(t, server.URL, sseOptions(forceHTTP1), true)
+			}
+			if got := atomic.LoadInt64(conns); got != 1 {
+				t.Fatalf("expected 1 connection for sequential requests, got %d", got)
+			}
+		})
+	}
+}
+
+func TestConnectionCloseHeaderDisablesReuse(t *testing.T) {
+	server, conns := newSSEServer(t, true)
+	options := sseOptions(false)
+	options.Headers["Connection"] = "close"
+	for i := 0; i < 3; i++ {
+		timeToFirstEvent(t, server.URL, options, true)
+	}
+	if got := atomic.LoadInt64(conns); got != 3 {
+		t.Fatalf("expected a new connection per request with Connection: close, got %d", got)
+	}
+}
+
+// BenchmarkTimeToFirstEvent 对比每个请求新建client(before)与共享连接池(after)的首事件延迟
+func BenchmarkTimeToFirstEvent(b *testing.B) {
+	for _, forceHTTP1 := range []bool{false, true} {
+		for _, shared := range []bool{false, true} {
+			name := fmt.Sprintf("forceHTTP1=%v/before", forceHTTP1)
+			if shared {
+				name = fmt.Sprintf("forceHTTP1=%v/after", forceHTTP1)
+			}
+			b.Run(name, func(b *testing.B) {
+				server, conns := newSSEServer(b, true)
+				var total time.Duration
+				b.ResetTimer()
+				for i := 0; i < b.N; i++ {
+					total += timeToFirstEvent(b, server.URL, sseOptions(forceHTTP1), shared)
+				}
+				b.ReportMetric(float64(total.Microseconds())/float64(b.N), "µs/first-event")
+				b.ReportMetric(float64(atomic.LoadInt64(conns))/float64(b.N), "conns/op")
+			})
+		}
+	}
+}
@@ -8,6 +8,7 @@ import (
 
 	"strings"
 	"sync"
+	"time"
 
 	http "github.com/Danny-Dasilva/fhttp"
 	http2 "github.com/Danny-Dasilva/fhttp/http2"
@@ -17,6 +18,13 @@ import (
 
 var errProtocolNegotiated = errors.New("protocol negotiated")
 
+const (
+	// maxIdleConnsPerHost HTTP/1.1每个上游保留的空闲连接数,HTTP/2在单个连接上多路复用
+	maxIdleConnsPerHost = 16
+	// idleConnTimeout 空闲连接的保留时间
+	idleConnTimeout = 90 * time.Second
+)
+
 type roundTripper struct {
 	sync.Mutex
 	// fix typing
@@ -51,30 +59,49 @@ func (rt *roundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
 	}
 	req.Header.Set("User-Agent", rt.UserAgent)
 	addr := rt.getDialTLSAddr(req)
-	if _, ok := rt.cachedTransports[addr]; !ok {
-		if err := rt.getTransport(req, addr); err != nil {
-			return nil, err
-		}
+	transport, err := rt.transport(req, addr)
+	if err != nil {
+		return nil, err
+	}
+	return transport.RoundTrip(req)
+}
+
+// transport 返回addr对应的transport,首次请求时按ALPN协商结果创建。roundTripper被多个请求共享,map访问需加锁
+func (rt *roundTripper) transport(req *http.Request, addr string) (http.RoundTripper, error) {
+	rt.Lock()
+	transport, ok := rt.cachedTransports[addr]
+	rt.Unlock()
+	if ok {
+		return transport, nil
+	}
+	if err := rt.getTransport(req, addr); err != nil {
+		return nil, err
 	}
-	return rt.cachedTransports[addr].RoundTrip(req)
+	rt.Lock()
+	defer rt.Unlock()
+	return rt.cachedTransports[addr], nil
 }
 
 func (rt *roundTripper) getTransport(req *http.Request, addr string) error {
 	switch strings.ToLower(req.URL.Scheme) {
 	case "http":
-		rt.cachedTransports[addr] = &http.Transport{DialContext: rt.dialer.DialContext, DisableKeepAlives: true}
+		rt.Lock()
+		defer rt.Unlock()
+		if rt.cachedTransports[addr] == nil {
+			rt.cachedTransports[addr] = &http.Transport{DialContext: rt.dialer.DialContext, MaxIdleConnsPerHost: maxIdleConnsPerHost, IdleConnTimeout: idleConnTimeout}
+		}
 		return nil
 	case "https":
 	default:
 		return fmt.Errorf("invalid URL scheme: [%v]", req.URL.Scheme)
 	}
 
-	_, err := rt.dialTLS(req.Context(), "tcp", addr)
+	conn, err := rt.dialTLS(req.Context(), "tcp", addr)
 	switch err {
 	case errProtocolNegotiated:
 	case nil:
-		// Should never happen.
-		panic("dialTLS returned no error when determining cachedTransports")
+		// 并发的首次请求已创建transport,多余的连接直接关闭
+		_ = conn.Close()
 	default:
 		return err
 	}
@@ -83,14 +110,18 @@ func (rt *roundTripper) getTransport(req *http.Request, addr string) error {
 }
 
 func (rt *roundTripper) dialTLS(ctx context.Context, network, addr string) (net.Conn, error) {
-	rt.Lock()
-	defer rt.Unlock()
-
 	// If we have the connection from when we determined the HTTPS
 	// cachedTransports to use, return that.
+	// 暂存的连接只能使用一次,之后的新连接需重新握手
+	rt.Lock()
 	if conn := rt.cachedConnections[addr]; conn != nil {
+		delete(rt.cachedConnections, addr)
+		rt.Unlock()
 		return conn, nil
 	}
+	rt.Unlock()
+
+	// 握手不持有锁,避免并发建立连接时相互阻塞
 	rawConn, err := rt.dialer.DialContext(ctx, network, addr)
 	if err != nil {
 		return nil, err
@@ -126,6 +157,8 @@ func (rt *roundTripper) dialTLS(ctx context.Context, network, addr string) (net.
 		return nil, fmt.Errorf("uTlsConn.Handshake() error: %+v", err)
 	}
 
+	rt.Lock()
+	defer rt.Unlock()
 	if rt.cachedTransports[addr] != nil {
 		return conn, nil
 	}
@@ -144,7 +177,7 @@ func (rt *roundTripper) dialTLS(ctx context.Context, network, addr string) (net.
 		rt.cachedTransports[addr] = &t2
 	default:
 		// Assume the remote peer is speaking HTTP 1.x + TLS.
-		rt.cachedTransports[addr] = &http.Transport{DialTLSContext: rt.dialTLS, DisableKeepAlives: true}
+		rt.cachedTransports[addr] = &http.Transport{DialTLSContext: rt.dialTLS, MaxIdleConnsPerHost: maxIdleConnsPerHost, IdleConnTimeout: idleConnTimeout}
 
 	}
 
@@ -168,10 +201,17 @@ func (rt *roundTripper) getDialTLSAddr(req *http.Request) string {
 }
 
 func (rt *roundTripper) CloseIdleConnections() {
+	rt.Lock()
+	defer rt.Unlock()
 	for addr, conn := range rt.cachedConnections {
 		_ = conn.Close()
 		delete(rt.cachedConnections, addr)
 	}
+	for _, transport := range rt.cachedTransports {
+		if closer, ok := transport.(interface{ CloseIdleConnections() }); ok {
+			closer.CloseIdleConnections()
+		}
+	}
 }
 
 func newRoundTripper(browser Browser, dialer ...proxy.ContextDialer) http.RoundTripper {
@@ -0,0 +1,142 @@
+package cycletls
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// maxSSELineSize 单行最大长度,data中可能包含base64图片
+const maxSSELineSize = 16 * 1024 * 1024
+
+// SSEEvent 按WHATWG规范解析得到的SSE事件
+type SSEEvent struct {
+	// Event 事件名,未指定时为"message"
+	Event string
+	// Data 多行data以\n连接
+	Data string
+	// ID 最近一次设置的事件ID
+	ID string
+	// Retry 重连间隔(毫秒),未指定时为0
+	Retry int
+}
+
+// SSEDecoder SSE事件解码器,支持LF/CR/CRLF换行、多行data、注释与id/retry字段。
+// 数据可任意分片到达,解码器按行缓冲,不依赖底层每次读取的边界。
+type SSEDecoder struct {
+	scanner     *bufio.Scanner
+	started     bool
+	lastEventID string
+}
+
+// NewSSEDecoder 创建SSE解码器
+func NewSSEDecoder(reader io.Reader) *SSEDecoder {
+	scanner := bufio.NewScanner(reader)
+	scanner.Buffer(make([]byte, 0, 64*1024), maxSSELineSize)
+	scanner.Split(scanSSELines)
+	return &SSEDecoder{scanner: scanner}
+}
+
+// Next 返回下一条事件,流结束时返回io.EOF,结尾未以空行结束的事件按规范丢弃
+func (d *SSEDecoder) Next() (SSEEvent, error) {
+	var eventType string
+	var data strings.Builder
+	hasData := false
+	retry := 0
+
+	for d.scanner.Scan() {
+		line := d.scanner.Text()
+		if !d.started {
+			d.started = true
+			line = strings.TrimPrefix(line, "\uFEFF")
+		}
+
+		// 空行分发事件
+		if line == "" {
+			if !hasData {
+				eventType = ""
+				continue
+			}
+			if eventType == "" {
+				eventType = "message"
+			}
+			return SSEEvent{
+				Event: eventType,
+				Data:  strings.TrimSuffix(data.String(), "\n"),
+				ID:    d.lastEventID,
+				Retry: retry,
+			}, nil
+		}
+
+		// 注释行,常用于保活
+		if strings.HasPrefix(line, ":") {
+			continue
+		}
+
+		field, value, found := strings.Cut(line, ":")
+		if found {
+			value = strings.TrimPrefix(value, " ")
+		}
+		switch field {
+		case "event":
+			eventType = value
+		case "data":
+			data.WriteString(value)
+			data.WriteByte('\n')
+			hasData = true
+		case "id":
+			if !strings.ContainsRune(value, 0) {
+				d.lastEventID = value
+			}
+		case "retry":
+			if isASCIIDigits(value) {
+				if n, err := strconv.Atoi(value); err == nil {
+					retry = n
+				}
+			}
+		}
+	}
+
+	if err := d.scanner.Err(); err != nil {
+		return SSEEvent{}, err
+	}
+	return SSEEvent{}, io.EOF
+}
+
+// scanSSELines 按LF、CR或CRLF切分行
+func scanSSELines(data []byte, atEOF bool) (advance int, token []byte, err error) {
+	if atEOF && len(data) == 0 {
+		return 0, nil, nil
+	}
+	if i := bytes.IndexAny(data, "\r\n"); i >= 0 {
+		if data[i] == '\n' {
+			return i + 1, data[:i], nil
+		}
+		// CR后需确认是否紧跟LF
+		if i+1 < len(data) {
+			if data[i+1] == '\n' {
+				return i + 2, data[:i], nil
+			}
+			return i + 1, data[:i], nil
+		}
+		if !atEOF {
+			return 0, nil, nil
+		}
+		return i + 1, data[:i], nil
+	}
+	if atEOF {
+		return len(data), data, nil
+	}
+	return 0, nil, nil
+}
+
+func isASCIIDigits(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] < '0' || s[i] > '9' {
+			return false
+		}
+	}
+	return s != ""
+}
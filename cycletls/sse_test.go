@@ -0,0 +1,173 @@
+package cycletls
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// chunkReader 每次最多返回size字节,模拟TCP分片到达
+type chunkReader struct {
+	data []byte
+	size int
+}
+
+func (r *chunkReader) Read(p []byte) (int, error) {
+	if len(r.data) == 0 {
+		return 0, io.EOF
+	}
+	n := min(r.size, len(p), len(r.data))
+	copy(p, r.data[:n])
+	r.data = r.data[n:]
+	return n, nil
+}
+
+func decodeAll(t *testing.T, reader io.Reader) []SSEEvent {
+	t.Helper()
+	decoder := NewSSEDecoder(reader)
+	var events []SSEEvent
+	for {
+		event, err := decoder.Next()
+		if errors.Is(err, io.EOF) {
+			return events
+		}
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		events = append(events, event)
+	}
+}
+
+func TestSSEDecoder(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		want  []SSEEvent
+	}{
+		{
+			name:  "single event",
+			input: "data: hello\n\n",
+			want:  []SSEEvent{{Event: "message", Data: "hello"}},
+		},
+		{
+			name:  "LF line endings",
+			input: "event: ping\ndata: 1\n\ndata: 2\n\n",
+			want:  []SSEEvent{{Event: "ping", Data: "1"}, {Event: "message", Data: "2"}},
+		},
+		{
+			name:  "CR line endings",
+			input: "event: ping\rdata: 1\r\rdata: 2\r\r",
+			want:  []SSEEvent{{Event: "ping", Data: "1"}, {Event: "message", Data: "2"}},
+		},
+		{
+			name:  "CRLF line endings",
+			input: "event: ping\r\ndata: 1\r\n\r\ndata: 2\r\n\r\n",
+			want:  []SSEEvent{{Event: "ping", Data: "1"}, {Event: "message", Data: "2"}},
+		},
+		{
+			name:  "mixed line endings",
+			input: "data: a\rdata: b\r\ndata: c\n\r\n",
+			want:  []SSEEvent{{Event: "message", Data: "a\nb\nc"}},
+		},
+		{
+			name:  "multi-line data",
+			input: "data: {\"a\":\ndata: 1}\ndata:\n\n",
+			want:  []SSEEvent{{Event: "message", Data: "{\"a\":\n1}\n"}},
+		},
+		{
+			name:  "only first space after colon is stripped",
+			input: "data:no space\ndata:  two spaces\n\n",
+			want:  []SSEEvent{{Event: "message", Data: "no space\n two spaces"}},
+		},
+		{
+			name:  "event id and retry fields",
+			input: "event: message_start\nid: 42\nretry: 3000\ndata: x\n\ndata: y\n\n",
+			want: []SSEEvent{
+				{Event: "message_start", Data: "x", ID: "42", Retry: 3000},
+				{Event: "message", Data: "y", ID: "42"},
+			},
+		},
+		{
+			name:  "invalid retry and id with NUL are ignored",
+			input: "id: 1\ndata: a\n\nid: 2\x003\nretry: 1s\ndata: b\n\n",
+			want:  []SSEEvent{{Event: "message", Data: "a", ID: "1"}, {Event: "message", Data: "b", ID: "1"}},
+		},
+		{
+			name:  "comment lines",
+			input: ": keepalive\n\n:another\ndata: a\n: inside event\n\n",
+			want:  []SSEEvent{{Event: "message", Data: "a"}},
+		},
+		{
+			name:  "event without data is not dispatched",
+			input: "event: ping\n\ndata: a\n\n",
+			want:  []SSEEvent{{Event: "message", Data: "a"}},
+		},
+		{
+			name:  "unknown fields and field without colon",
+			input: "foo: bar\ndata\ndata: a\n\n",
+			want:  []SSEEvent{{Event: "message", Data: "\na"}},
+		},
+		{
+			name:  "leading BOM",
+			input: "\uFEFFdata: a\n\n",
+			want:  []SSEEvent{{Event: "message", Data: "a"}},
+		},
+		{
+			name:  "BOM only stripped at stream start",
+			input: "data: a\n\ndata: \uFEFFb\n\n",
+			want:  []SSEEvent{{Event: "message", Data: "a"}, {Event: "message", Data: "\uFEFFb"}},
+		},
+		{
+			// 规范要求流结束时丢弃未以空行结束的事件
+			name:  "final event without trailing blank line is discarded",
+			input: "data: a\n\ndata: b\n",
+			want:  []SSEEvent{{Event: "message", Data: "a"}},
+		},
+		{
+			name:  "final event without trailing newline is discarded",
+			input: "data: a\n\ndata: b",
+			want:  []SSEEvent{{Event: "message", Data: "a"}},
+		},
+		{
+			name:  "DONE marker is a normal event",
+			input: "data: {\"x\":1}\n\ndata: [DONE]\n\n",
+			want:  []SSEEvent{{Event: "message", Data: "{\"x\":1}"}, {Event: "message", Data: "[DONE]"}},
+		},
+	}
+
+	for _, tt := range tests {
+		// 每个用例按不同分片大小读取,结果应与一次性读取一致
+		for _, size := range []int{1, 2, 3, 5, 7, len(tt.input)} {
+			t.Run(fmt.Sprintf("%s/chunk=%d", tt.name, size), func(t *testing.T) {
+				got := decodeAll(t, &chunkReader{data: []byte(tt.input), size: size})
+				if !reflect.DeepEqual(got, tt.want) {
+					t.Fatalf("got %#v, want %#v", got, tt.want)
+				}
+			})
+		}
+	}
+}
+
+func TestSSEDecoderCRAtChunkBoundary(t *testing.T) {
+	// CR与LF分属两次读取时,CRLF只算一个换行,不会产生多余的空行提前分发事件
+	input := "data: a\r\ndata: b\r\n\r\n"
+	for split := 1; split < len(input); split++ {
+		reader := io.MultiReader(strings.NewReader(input[:split]), strings.NewReader(input[split:]))
+		got := decodeAll(t, reader)
+		want := []SSEEvent{{Event: "message", Data: "a\nb"}}
+		if !reflect.DeepEqual(got, want) {
+			t.Fatalf("split at %d: got %#v, want %#v", split, got, want)
+		}
+	}
+}
+
+func TestSSEDecoderLongLine(t *testing.T) {
+	data := strings.Repeat("x", 1<<20)
+	got := decodeAll(t, &chunkReader{data: []byte("data: " + data + "\n\n"), size: 4096})
+	if len(got) != 1 || got[0].Data != data {
+		t.Fatalf("long data line was not decoded intact")
+	}
+}
@@ -12,6 +12,8 @@ import (
 	logger "kilo2api/common/loggger"
 	"kilo2api/middleware"
 	"kilo2api/model"
+	_ "kilo2api/provider/claude"
+	_ "kilo2api/provider/openrouter"
 	"kilo2api/router"
 	"os"
 	"strconv"
@@ -22,6 +24,7 @@ import (
 //var buildFS embed.FS
 
 func main() {
+	common.Init()
 	logger.SetupLogger()
 	logger.SysLog(fmt.Sprintf("kilo2api %s starting...", common.Version))
 
@@ -33,6 +36,7 @@ func main() {
 
 	var err error
 
+	common.InitModelRegistry()
 	model.InitTokenEncoders()
 	config.InitSGCookies()
 
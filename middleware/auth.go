@@ -5,6 +5,7 @@ import (
 	"github.com/samber/lo"
 	"kilo2api/common"
 	"kilo2api/common/config"
+	"kilo2api/common/helper"
 	logger "kilo2api/common/loggger"
 	"kilo2api/model"
 	"net/http"
@@ -26,6 +27,17 @@ func isValidBackendSecret(secret string) bool {
 func authHelperForOpenai(c *gin.Context) {
 	secret := c.Request.Header.Get("Authorization")
 	secret = strings.Replace(secret, "Bearer ", "", 1)
+	if secret == "" {
+		// Anthropic SDK 使用 x-api-key 传递密钥
+		secret = c.Request.Header.Get("x-api-key")
+	}
+	if secret == "" {
+		// Google SDK 使用 x-goog-api-key 或 key 参数传递密钥
+		secret = c.Request.Header.Get("x-goog-api-key")
+		if secret == "" {
+			secret = c.Query("key")
+		}
+	}
 
 	b := isValidSecret(secret)
 
@@ -34,7 +46,7 @@ func authHelperForOpenai(c *gin.Context) {
 			OpenAIError: model.OpenAIError{
 				Message: "API-KEY校验失败",
 				Type:    "invalid_request_error",
-				Code:    "invalid_authorization",
+				Code:    "invalid_api_key",
 			},
 		})
 		c.Abort()
@@ -45,6 +57,7 @@ func authHelperForOpenai(c *gin.Context) {
 	//	c.Request.Header.Set("Authorization", "")
 	//}
 
+	c.Set(helper.ApiKeyKey, secret)
 	c.Next()
 	return
 }
@@ -3,6 +3,7 @@ package middleware
 import (
 	"github.com/gin-gonic/gin"
 	"kilo2api/common/config"
+	"kilo2api/model"
 	"net/http"
 	"strings"
 )
@@ -17,7 +18,13 @@ func IPBlacklistMiddleware() gin.HandlerFunc {
 		for _, blockedIP := range config.IpBlackList {
 			if strings.TrimSpace(blockedIP) == clientIP {
 				// 如果在黑名单中，返回403 Forbidden
-				c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "Forbidden"})
+				c.AbortWithStatusJSON(http.StatusForbidden, model.OpenAIErrorResponse{
+					OpenAIError: model.OpenAIError{
+						Message: "Forbidden",
+						Type:    "permission_error",
+						Code:    "ip_blocked",
+					},
+				})
 				return
 			}
 		}
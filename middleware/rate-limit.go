@@ -4,7 +4,9 @@ import (
 	"github.com/gin-gonic/gin"
 	"kilo2api/common"
 	"kilo2api/common/config"
+	"kilo2api/model"
 	"net/http"
+	"strconv"
 )
 
 var timeFormat = "2006-01-02T15:04:05.000Z"
@@ -14,11 +16,14 @@ var inMemoryRateLimiter common.InMemoryRateLimiter
 func memoryRateLimiter(c *gin.Context, maxRequestNum int, duration int64, mark string) {
 	key := mark + c.ClientIP()
 	if !inMemoryRateLimiter.Request(key, maxRequestNum, duration) {
-		c.JSON(http.StatusTooManyRequests, gin.H{
-			"success": false,
-			"message": "请求过于频繁,请稍后再试",
+		c.Header("Retry-After", strconv.FormatInt(duration, 10))
+		c.AbortWithStatusJSON(http.StatusTooManyRequests, model.OpenAIErrorResponse{
+			OpenAIError: model.OpenAIError{
+				Message: "请求过于频繁,请稍后再试",
+				Type:    "rate_limit_error",
+				Code:    "rate_limit_exceeded",
+			},
 		})
-		c.Abort()
 		return
 	}
 }
@@ -0,0 +1,261 @@
+package model
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// ClaudeMessagesRequest Anthropic Messages接口(/v1/messages)请求结构
+type ClaudeMessagesRequest struct {
+	Model         string                 `json:"model"`
+	MaxTokens     int                    `json:"max_tokens"`
+	System        interface{}            `json:"system,omitempty"`
+	Messages      []ClaudeMessage        `json:"messages"`
+	Stream        bool                   `json:"stream,omitempty"`
+	Temperature   *float64               `json:"temperature,omitempty"`
+	TopP          *float64               `json:"top_p,omitempty"`
+	TopK          *int                   `json:"top_k,omitempty"`
+	StopSequences []string               `json:"stop_sequences,omitempty"`
+	Thinking      *ClaudeThinking        `json:"thinking,omitempty"`
+	Tools         []ClaudeTool           `json:"tools,omitempty"`
+	ToolChoice    *ClaudeToolChoice      `json:"tool_choice,omitempty"`
+	Metadata      map[string]interface{} `json:"metadata,omitempty"`
+}
+
+// ClaudeErrorResponse Anthropic格式的错误响应
+type ClaudeErrorResponse struct {
+	Type  string      `json:"type"`
+	Error ClaudeError `json:"error"`
+}
+
+type ClaudeError struct {
+	Type    string `json:"type"`
+	Message string `json:"message"`
+}
+
+// NewClaudeErrorResponse 创建Anthropic格式的错误响应
+func NewClaudeErrorResponse(errorType, message string) ClaudeErrorResponse {
+	return ClaudeErrorResponse{
+		Type: "error",
+		Error: ClaudeError{
+			Type:    errorType,
+			Message: message,
+		},
+	}
+}
+
+// ConvertClaudeToOpenAIRequest 将Anthropic Messages请求转换为OpenAI请求,用于非Claude来源的模型
+func ConvertClaudeToOpenAIRequest(claudeReq ClaudeMessagesRequest) (OpenAIChatCompletionRequest, error) {
+	openAIReq := OpenAIChatCompletionRequest{
+		Model:     claudeReq.Model,
+		Stream:    claudeReq.Stream,
+		MaxTokens: claudeReq.MaxTokens,
+	}
+	openAIReq.Temperature = claudeReq.Temperature
+	openAIReq.TopP = claudeReq.TopP
+	openAIReq.TopK = claudeReq.TopK
+	if len(claudeReq.StopSequences) > 0 {
+		openAIReq.Stop = claudeReq.StopSequences
+	}
+	if userID, ok := claudeReq.Metadata["user_id"].(string); ok {
+		openAIReq.User = userID
+	}
+	if claudeReq.Thinking != nil && claudeReq.Thinking.Type == "enabled" {
+		openAIReq.ThinkingBudget = claudeReq.Thinking.BudgetTokens
+	}
+
+	// 处理system
+	systemText, err := claudeSystemText(claudeReq.System)
+	if err != nil {
+		return openAIReq, err
+	}
+	if systemText != "" {
+		openAIReq.Messages = append(openAIReq.Messages, OpenAIChatMessage{
+			Role:    "system",
+			Content: systemText,
+		})
+	}
+
+	for _, msg := range claudeReq.Messages {
+		messages, err := convertClaudeMessageToOpenAI(msg)
+		if err != nil {
+			return openAIReq, err
+		}
+		openAIReq.Messages = append(openAIReq.Messages, messages...)
+	}
+
+	// 处理工具
+	for _, tool := range claudeReq.Tools {
+		openAIReq.Tools = append(openAIReq.Tools, OpenAITool{
+			Type: "function",
+			Function: OpenAIFunction{
+				Name:        tool.Name,
+				Description: tool.Description,
+				Parameters:  tool.InputSchema,
+			},
+		})
+	}
+	if claudeReq.ToolChoice != nil {
+		switch claudeReq.ToolChoice.Type {
+		case "auto":
+			openAIReq.ToolChoice = "auto"
+		case "any":
+			openAIReq.ToolChoice = "required"
+		case "none":
+			openAIReq.ToolChoice = "none"
+		case "tool":
+			openAIReq.ToolChoice = map[string]interface{}{
+				"type": "function",
+				"function": map[string]interface{}{
+					"name": claudeReq.ToolChoice.Name,
+				},
+			}
+		}
+		if claudeReq.ToolChoice.DisableParallelToolUse {
+			parallelToolCalls := false
+			openAIReq.ParallelToolCalls = &parallelToolCalls
+		}
+	}
+
+	return openAIReq, nil
+}
+
+// claudeSystemText 提取system参数中的文本,支持字符串与文本块数组
+func claudeSystemText(system interface{}) (string, error) {
+	switch s := system.(type) {
+	case nil:
+		return "", nil
+	case string:
+		return s, nil
+	case []interface{}:
+		var texts []string
+		for _, block := range s {
+			if blockMap, ok := block.(map[string]interface{}); ok {
+				if text, ok := blockMap["text"].(string); ok {
+					texts = append(texts, text)
+				}
+			}
+		}
+		return strings.Join(texts, "\n"), nil
+	}
+	contentBytes, err := json.Marshal(system)
+	if err != nil {
+		return "", fmt.Errorf("无法序列化system内容: %v", err)
+	}
+	return string(contentBytes), nil
+}
+
+// convertClaudeMessageToOpenAI 将单条Claude消息转换为OpenAI消息,tool_result块会拆分为独立的tool消息
+func convertClaudeMessageToOpenAI(msg ClaudeMessage) ([]OpenAIChatMessage, error) {
+	blocks, ok := msg.Content.([]interface{})
+	if !ok {
+		if text, ok := msg.Content.(string); ok {
+			return []OpenAIChatMessage{{Role: msg.Role, Content: text}}, nil
+		}
+		contentBytes, err := json.Marshal(msg.Content)
+		if err != nil {
+			return nil, fmt.Errorf("无法序列化消息内容: %v", err)
+		}
+		return []OpenAIChatMessage{{Role: msg.Role, Content: string(contentBytes)}}, nil
+	}
+
+	var messages []OpenAIChatMessage
+	var parts []interface{}
+	var toolCalls []OpenAIToolCall
+	for _, block := range blocks {
+		blockMap, ok := block.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		switch blockMap["type"] {
+		case "text":
+			text, _ := blockMap["text"].(string)
+			parts = append(parts, map[string]interface{}{
+				"type": "text",
+				"text": text,
+			})
+		case "image":
+			source, _ := blockMap["source"].(map[string]interface{})
+			url := claudeImageURL(source)
+			if url != "" {
+				parts = append(parts, map[string]interface{}{
+					"type": "image_url",
+					"image_url": map[string]interface{}{
+						"url": url,
+					},
+				})
+			}
+		case "tool_use":
+			id, _ := blockMap["id"].(string)
+			name, _ := blockMap["name"].(string)
+			arguments, err := json.Marshal(blockMap["input"])
+			if err != nil {
+				return nil, fmt.Errorf("无法序列化tool_use参数: %v", err)
+			}
+			toolCalls = append(toolCalls, OpenAIToolCall{
+				ID:   id,
+				Type: "function",
+				Function: OpenAIFunctionCall{
+					Name:      name,
+					Arguments: string(arguments),
+				},
+			})
+		case "tool_result":
+			toolUseID, _ := blockMap["tool_use_id"].(string)
+			messages = append(messages, OpenAIChatMessage{
+				Role:       "tool",
+				ToolCallID: toolUseID,
+				Content:    claudeToolResultText(blockMap["content"]),
+			})
+		}
+	}
+
+	if len(parts) > 0 || len(toolCalls) > 0 {
+		message := OpenAIChatMessage{
+			Role:      msg.Role,
+			ToolCalls: toolCalls,
+		}
+		if len(parts) > 0 {
+			message.Content = parts
+		}
+		messages = append(messages, message)
+	}
+	return messages, nil
+}
+
+// claudeImageURL 将Claude图像source转换为URL或data URL
+func claudeImageURL(source map[string]interface{}) string {
+	switch source["type"] {
+	case "base64":
+		mediaType, _ := source["media_type"].(string)
+		data, _ := source["data"].(string)
+		return fmt.Sprintf("data:%s;base64,%s", mediaType, data)
+	case "url":
+		url, _ := source["url"].(string)
+		return url
+	}
+	return ""
+}
+
+// claudeToolResultText 提取tool_result中的文本内容
+func claudeToolResultText(content interface{}) string {
+	switch c := content.(type) {
+	case nil:
+		return ""
+	case string:
+		return c
+	case []interface{}:
+		var texts []string
+		for _, block := range c {
+			if blockMap, ok := block.(map[string]interface{}); ok {
+				if text, ok := blockMap["text"].(string); ok {
+					texts = append(texts, text)
+				}
+			}
+		}
+		return strings.Join(texts, "\n")
+	}
+	contentBytes, _ := json.Marshal(content)
+	return string(contentBytes)
+}
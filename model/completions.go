@@ -0,0 +1,99 @@
+package model
+
+import (
+	"fmt"
+)
+
+// OpenAICompletionRequest OpenAI旧版文本补全接口(/v1/completions)请求结构
+type OpenAICompletionRequest struct {
+	Model            string               `json:"model"`
+	Prompt           interface{}          `json:"prompt"` // 字符串或字符串数组
+	Suffix           string               `json:"suffix,omitempty"`
+	Echo             bool                 `json:"echo,omitempty"`
+	Stop             interface{}          `json:"stop,omitempty"`
+	Stream           bool                 `json:"stream,omitempty"`
+	StreamOptions    *OpenAIStreamOptions `json:"stream_options,omitempty"`
+	MaxTokens        int                  `json:"max_tokens,omitempty"`
+	Temperature      *float64             `json:"temperature,omitempty"`
+	TopP             *float64             `json:"top_p,omitempty"`
+	N                int                  `json:"n,omitempty"`
+	PresencePenalty  *float64             `json:"presence_penalty,omitempty"`
+	FrequencyPenalty *float64             `json:"frequency_penalty,omitempty"`
+	Seed             *int64               `json:"seed,omitempty"`
+	LogitBias        map[string]float64   `json:"logit_bias,omitempty"`
+	User             string               `json:"user,omitempty"`
+}
+
+// OpenAICompletionResponse 文本补全响应,流式与非流式共用
+type OpenAICompletionResponse struct {
+	ID      string                   `json:"id"`
+	Object  string                   `json:"object"`
+	Created int64                    `json:"created"`
+	Model   string                   `json:"model"`
+	Choices []OpenAICompletionChoice `json:"choices"`
+	Usage   *OpenAIUsage             `json:"usage,omitempty"`
+}
+
+type OpenAICompletionChoice struct {
+	Text         string  `json:"text"`
+	Index        int     `json:"index"`
+	LogProbs     *string `json:"logprobs"`
+	FinishReason *string `json:"finish_reason"`
+}
+
+// 补全接口没有对话语义,以系统提示要求模型只输出续写内容
+const (
+	completionSystemPrompt       = "You are a text completion engine. Continue the text provided by the user exactly where it ends. Output only the continuation, without repeating the given text and without any explanation or formatting."
+	completionInsertSystemPrompt = "You are a text completion engine performing fill-in-the-middle. The user provides a prefix inside <prefix> tags and a suffix inside <suffix> tags. Output only the text that belongs between them, without repeating the prefix or suffix and without any explanation or formatting."
+)
+
+// Prompts 将prompt统一为字符串数组
+func (r *OpenAICompletionRequest) Prompts() ([]string, error) {
+	switch prompt := r.Prompt.(type) {
+	case string:
+		return []string{prompt}, nil
+	case []interface{}:
+		if len(prompt) == 0 {
+			return nil, fmt.Errorf("prompt must not be empty")
+		}
+		prompts := make([]string, 0, len(prompt))
+		for _, item := range prompt {
+			s, ok := item.(string)
+			if !ok {
+				// token数组形式的prompt无法还原为文本
+				return nil, fmt.Errorf("prompt must be a string or an array of strings")
+			}
+			prompts = append(prompts, s)
+		}
+		return prompts, nil
+	}
+	return nil, fmt.Errorf("prompt must be a string or an array of strings")
+}
+
+// ConvertCompletionToOpenAIRequest 将单个prompt包装为对话请求,复用对话接口的转换链路
+func ConvertCompletionToOpenAIRequest(completionReq OpenAICompletionRequest, prompt string) OpenAIChatCompletionRequest {
+	systemPrompt, userPrompt := completionSystemPrompt, prompt
+	if completionReq.Suffix != "" {
+		systemPrompt = completionInsertSystemPrompt
+		userPrompt = fmt.Sprintf("<prefix>%s</prefix>\n<suffix>%s</suffix>", prompt, completionReq.Suffix)
+	}
+	return OpenAIChatCompletionRequest{
+		Model:  completionReq.Model,
+		Stream: completionReq.Stream,
+		Messages: []OpenAIChatMessage{
+			{Role: "system", Content: systemPrompt},
+			{Role: "user", Content: userPrompt},
+		},
+		MaxTokens:        completionReq.MaxTokens,
+		Temperature:      completionReq.Temperature,
+		StreamOptions:    completionReq.StreamOptions,
+		TopP:             completionReq.TopP,
+		Stop:             completionReq.Stop,
+		PresencePenalty:  completionReq.PresencePenalty,
+		FrequencyPenalty: completionReq.FrequencyPenalty,
+		Seed:             completionReq.Seed,
+		LogitBias:        completionReq.LogitBias,
+		User:             completionReq.User,
+		N:                completionReq.N,
+	}
+}
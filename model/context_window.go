@@ -0,0 +1,111 @@
+package model
+
+import (
+	"fmt"
+	"kilo2api/common/config"
+)
+
+// ContextLengthError 输入与max_tokens之和超出模型上下文窗口
+type ContextLengthError struct {
+	ContextWindow int
+	InputTokens   int
+	MaxTokens     int
+}
+
+func (e *ContextLengthError) Error() string {
+	return fmt.Sprintf("This model's maximum context length is %d tokens. However, you requested %d tokens (%d in the messages, %d in the completion). Please reduce the length of the messages or completion.",
+		e.ContextWindow, e.InputTokens+e.MaxTokens, e.InputTokens, e.MaxTokens)
+}
+
+// ContextTruncation 截断结果
+type ContextTruncation struct {
+	Strategy        string
+	DroppedMessages int
+	DroppedTokens   int
+}
+
+func (t ContextTruncation) String() string {
+	return fmt.Sprintf("strategy=%s, messages=%d, tokens=%d", t.Strategy, t.DroppedMessages, t.DroppedTokens)
+}
+
+// FitContextWindow 按上下文窗口(预留max_tokens)检查上游请求体的输入token,超出时按策略丢弃messages中的整轮对话。
+// 系统消息与最后一轮对话始终保留,一轮对话以用户消息开始(工具结果不视为新的一轮),保证工具调用与结果成对丢弃
+func FitContextWindow(body map[string]interface{}, modelName string, contextWindow, maxTokens int, strategy string) (ContextTruncation, error) {
+	truncation := ContextTruncation{Strategy: strategy}
+	if contextWindow <= 0 || strategy == config.ContextTruncationNone {
+		return truncation, nil
+	}
+
+	counter := bodyTokenCounter{encoder: GetTokenEncoder(modelName), model: modelName}
+	messages, _ := body["messages"].([]interface{})
+	messageTokens := make([]int, len(messages))
+	inputTokens := counter.countFixed(body)
+	for i, message := range messages {
+		messageTokens[i] = counter.countMessage(message)
+		inputTokens += messageTokens[i]
+	}
+	budget := contextWindow - maxTokens
+	if inputTokens <= budget {
+		return truncation, nil
+	}
+	contextErr := &ContextLengthError{ContextWindow: contextWindow, InputTokens: inputTokens, MaxTokens: maxTokens}
+	if strategy != config.ContextTruncationDropOldest && strategy != config.ContextTruncationMiddleOut {
+		return truncation, contextErr
+	}
+
+	turns := splitTurns(messages)
+	for inputTokens > budget && len(turns) > 1 {
+		index := 0
+		if strategy == config.ContextTruncationMiddleOut && len(turns) > 2 {
+			index = len(turns) / 2
+		}
+		for _, i := range turns[index] {
+			inputTokens -= messageTokens[i]
+			truncation.DroppedTokens += messageTokens[i]
+			truncation.DroppedMessages++
+			messages[i] = nil
+		}
+		turns = append(turns[:index], turns[index+1:]...)
+	}
+	if inputTokens > budget {
+		return truncation, contextErr
+	}
+
+	kept := make([]interface{}, 0, len(messages)-truncation.DroppedMessages)
+	for _, message := range messages {
+		if message != nil {
+			kept = append(kept, message)
+		}
+	}
+	body["messages"] = kept
+	return truncation, nil
+}
+
+// splitTurns 将非系统消息按对话轮次分组,返回每轮消息的下标。Claude格式的工具结果以用户消息返回,
+// 合并后可能同时包含文本,只要含有tool_result块即归入上一轮,保证tool_use与tool_result不被拆开
+func splitTurns(messages []interface{}) [][]int {
+	var turns [][]int
+	for i, rawMessage := range messages {
+		message, _ := rawMessage.(map[string]interface{})
+		role, _ := message["role"].(string)
+		if role == "system" || role == "developer" {
+			continue
+		}
+		if len(turns) == 0 || (role == "user" && !hasToolResultBlock(message["content"])) {
+			turns = append(turns, nil)
+		}
+		turns[len(turns)-1] = append(turns[len(turns)-1], i)
+	}
+	return turns
+}
+
+// hasToolResultBlock 内容中是否包含tool_result块
+func hasToolResultBlock(content interface{}) bool {
+	blocks, _ := content.([]interface{})
+	for _, block := range blocks {
+		if blockMap, ok := block.(map[string]interface{}); ok && blockMap["type"] == "tool_result" {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,118 @@
+package model
+
+import (
+	"encoding/json"
+	"kilo2api/common/config"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/pkoukk/tiktoken-go"
+)
+
+// byteBpeLoader 按字节编码的离线词表,测试时不下载tiktoken词表
+type byteBpeLoader struct{}
+
+func (byteBpeLoader) LoadTiktokenBpe(string) (map[string]int, error) {
+	ranks := make(map[string]int, 256)
+	for i := 0; i < 256; i++ {
+		ranks[string([]byte{byte(i)})] = i
+	}
+	return ranks, nil
+}
+
+var initEncodersOnce sync.Once
+
+func initTestEncoders() {
+	initEncodersOnce.Do(func() {
+		tiktoken.SetBpeLoader(byteBpeLoader{})
+		InitTokenEncoders()
+	})
+}
+
+func decodeBody(t *testing.T, s string) map[string]interface{} {
+	t.Helper()
+	var body map[string]interface{}
+	if err := json.Unmarshal([]byte(s), &body); err != nil {
+		t.Fatal(err)
+	}
+	return body
+}
+
+// toolResultWithTextBody 同角色消息合并后,工具结果与用户文本位于同一条消息
+func toolResultWithTextBody(t *testing.T) map[string]interface{} {
+	long := strings.Repeat("a", 2000)
+	return decodeBody(t, `{"system":[{"type":"text","text":"sys"}],"messages":[
+		{"role":"user","content":"`+long+`"},
+		{"role":"assistant","content":[{"type":"tool_use","id":"t1","name":"f","input":{}}]},
+		{"role":"user","content":[{"type":"tool_result","tool_use_id":"t1","content":"`+long+`"},{"type":"text","text":"continue"}]},
+		{"role":"assistant","content":"done"},
+		{"role":"user","content":"last"}
+	]}`)
+}
+
+func TestSplitTurnsKeepsToolResultWithToolUse(t *testing.T) {
+	body := toolResultWithTextBody(t)
+	turns := splitTurns(body["messages"].([]interface{}))
+	want := [][]int{{0, 1, 2, 3}, {4}}
+	if !reflect.DeepEqual(turns, want) {
+		t.Fatalf("expected turns %v, got %v", want, turns)
+	}
+}
+
+func TestFitContextWindowDropsToolPairsTogether(t *testing.T) {
+	initTestEncoders()
+	for _, strategy := range []string{config.ContextTruncationDropOldest, config.ContextTruncationMiddleOut} {
+		t.Run(strategy, func(t *testing.T) {
+			body := toolResultWithTextBody(t)
+			truncation, err := FitContextWindow(body, "claude-sonnet-4-20250514", 1000, 100, strategy)
+			if err != nil {
+				t.Fatal(err)
+			}
+			messages := body["messages"].([]interface{})
+			if truncation.DroppedMessages != 4 || len(messages) != 1 {
+				t.Fatalf("expected the whole tool turn to be dropped, got %s with %d messages left", truncation, len(messages))
+			}
+			for _, message := range messages {
+				if hasToolResultBlock(message.(map[string]interface{})["content"]) {
+					t.Fatal("tool_result kept without its tool_use")
+				}
+			}
+		})
+	}
+}
+
+func TestFitContextWindowRejectByDefault(t *testing.T) {
+	initTestEncoders()
+	body := toolResultWithTextBody(t)
+	_, err := FitContextWindow(body, "claude-sonnet-4-20250514", 1000, 100, config.ContextTruncation)
+	if _, ok := err.(*ContextLengthError); !ok {
+		t.Fatalf("expected ContextLengthError with the default strategy, got %v", err)
+	}
+	if len(body["messages"].([]interface{})) != 5 {
+		t.Fatal("messages must not be dropped with the default strategy")
+	}
+}
+
+func TestFitContextWindowDoesNotFetchImages(t *testing.T) {
+	initTestEncoders()
+	var hits int64
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt64(&hits, 1)
+	}))
+	defer server.Close()
+
+	body := decodeBody(t, `{"messages":[
+		{"role":"user","content":[{"type":"image","source":{"type":"url","url":"`+server.URL+`/a.png"}},{"type":"text","text":"hi"}]}
+	]}`)
+	if _, err := FitContextWindow(body, "claude-sonnet-4-20250514", 200000, 1000, config.ContextTruncationReject); err != nil {
+		t.Fatal(err)
+	}
+	if hits != 0 {
+		t.Fatalf("image url was fetched %d times while fitting the context window", hits)
+	}
+}
@@ -0,0 +1,380 @@
+package model
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// GeminiGenerateContentRequest Gemini原生接口(generateContent/streamGenerateContent)请求结构
+type GeminiGenerateContentRequest struct {
+	Contents          []GeminiNativeContent   `json:"contents"`
+	SystemInstruction *GeminiNativeContent    `json:"systemInstruction,omitempty"`
+	GenerationConfig  *GeminiGenerationConfig `json:"generationConfig,omitempty"`
+	Tools             []GeminiNativeTool      `json:"tools,omitempty"`
+	ToolConfig        *GeminiToolConfig       `json:"toolConfig,omitempty"`
+}
+
+// GeminiNativeContent Gemini原生接口的消息内容,role为user或model
+type GeminiNativeContent struct {
+	Role  string       `json:"role,omitempty"`
+	Parts []GeminiPart `json:"parts"`
+}
+
+type GeminiPart struct {
+	Text             string                  `json:"text,omitempty"`
+	Thought          bool                    `json:"thought,omitempty"`
+	ThoughtSignature string                  `json:"thoughtSignature,omitempty"`
+	InlineData       *GeminiInlineData       `json:"inlineData,omitempty"`
+	FileData         *GeminiFileData         `json:"fileData,omitempty"`
+	FunctionCall     *GeminiFunctionCall     `json:"functionCall,omitempty"`
+	FunctionResponse *GeminiFunctionResponse `json:"functionResponse,omitempty"`
+}
+
+type GeminiInlineData struct {
+	MimeType string `json:"mimeType"`
+	Data     string `json:"data"`
+}
+
+type GeminiFileData struct {
+	MimeType string `json:"mimeType,omitempty"`
+	FileURI  string `json:"fileUri"`
+}
+
+type GeminiFunctionCall struct {
+	ID   string                 `json:"id,omitempty"`
+	Name string                 `json:"name"`
+	Args map[string]interface{} `json:"args"`
+}
+
+type GeminiFunctionResponse struct {
+	ID       string      `json:"id,omitempty"`
+	Name     string      `json:"name"`
+	Response interface{} `json:"response"`
+}
+
+type GeminiGenerationConfig struct {
+	Temperature        *float64              `json:"temperature,omitempty"`
+	TopP               *float64              `json:"topP,omitempty"`
+	TopK               *int                  `json:"topK,omitempty"`
+	MaxOutputTokens    int                   `json:"maxOutputTokens,omitempty"`
+	StopSequences      []string              `json:"stopSequences,omitempty"`
+	CandidateCount     int                   `json:"candidateCount,omitempty"`
+	PresencePenalty    *float64              `json:"presencePenalty,omitempty"`
+	FrequencyPenalty   *float64              `json:"frequencyPenalty,omitempty"`
+	Seed               *int64                `json:"seed,omitempty"`
+	ResponseMimeType   string                `json:"responseMimeType,omitempty"`
+	ResponseSchema     interface{}           `json:"responseSchema,omitempty"`
+	ResponseJSONSchema interface{}           `json:"responseJsonSchema,omitempty"`
+	ThinkingConfig     *GeminiThinkingConfig `json:"thinkingConfig,omitempty"`
+}
+
+// GeminiThinkingConfig thinkingBudget为-1时由模型决定,0为关闭思考
+type GeminiThinkingConfig struct {
+	IncludeThoughts bool `json:"includeThoughts,omitempty"`
+	ThinkingBudget  *int `json:"thinkingBudget,omitempty"`
+}
+
+type GeminiNativeTool struct {
+	FunctionDeclarations []GeminiFunctionDeclaration `json:"functionDeclarations,omitempty"`
+}
+
+type GeminiFunctionDeclaration struct {
+	Name                 string      `json:"name"`
+	Description          string      `json:"description,omitempty"`
+	Parameters           interface{} `json:"parameters,omitempty"`
+	ParametersJSONSchema interface{} `json:"parametersJsonSchema,omitempty"`
+}
+
+type GeminiToolConfig struct {
+	FunctionCallingConfig *GeminiFunctionCallingConfig `json:"functionCallingConfig,omitempty"`
+}
+
+// GeminiFunctionCallingConfig mode为AUTO、ANY或NONE
+type GeminiFunctionCallingConfig struct {
+	Mode                 string   `json:"mode,omitempty"`
+	AllowedFunctionNames []string `json:"allowedFunctionNames,omitempty"`
+}
+
+// GeminiGenerateContentResponse Gemini原生接口响应,流式与非流式共用
+type GeminiGenerateContentResponse struct {
+	Candidates    []GeminiCandidate    `json:"candidates"`
+	UsageMetadata *GeminiUsageMetadata `json:"usageMetadata,omitempty"`
+	ModelVersion  string               `json:"modelVersion,omitempty"`
+	ResponseID    string               `json:"responseId,omitempty"`
+}
+
+type GeminiCandidate struct {
+	Content      GeminiNativeContent `json:"content"`
+	FinishReason string              `json:"finishReason,omitempty"`
+	Index        int                 `json:"index"`
+}
+
+type GeminiUsageMetadata struct {
+	PromptTokenCount        int `json:"promptTokenCount"`
+	CandidatesTokenCount    int `json:"candidatesTokenCount"`
+	TotalTokenCount         int `json:"totalTokenCount"`
+	CachedContentTokenCount int `json:"cachedContentTokenCount,omitempty"`
+	ThoughtsTokenCount      int `json:"thoughtsTokenCount,omitempty"`
+}
+
+// GeminiErrorResponse Google API格式的错误响应
+type GeminiErrorResponse struct {
+	Error GeminiError `json:"error"`
+}
+
+type GeminiError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+	Status  string `json:"status"`
+}
+
+// NewGeminiErrorResponse 创建Google API格式的错误响应
+func NewGeminiErrorResponse(code int, status, message string) GeminiErrorResponse {
+	return GeminiErrorResponse{Error: GeminiError{Code: code, Message: message, Status: status}}
+}
+
+// IncludeThoughts 请求是否要求返回思考内容
+func (r *GeminiGenerateContentRequest) IncludeThoughts() bool {
+	return r.GenerationConfig != nil && r.GenerationConfig.ThinkingConfig != nil && r.GenerationConfig.ThinkingConfig.IncludeThoughts
+}
+
+// ConvertGeminiToOpenAIRequest 将Gemini原生请求转换为OpenAI对话请求,复用对话接口的转换链路
+func ConvertGeminiToOpenAIRequest(geminiReq GeminiGenerateContentRequest, modelName string, stream bool) (OpenAIChatCompletionRequest, error) {
+	openAIReq := OpenAIChatCompletionRequest{
+		Model:  modelName,
+		Stream: stream,
+	}
+
+	if config := geminiReq.GenerationConfig; config != nil {
+		openAIReq.Temperature = config.Temperature
+		openAIReq.TopP = config.TopP
+		openAIReq.TopK = config.TopK
+		openAIReq.MaxTokens = config.MaxOutputTokens
+		if len(config.StopSequences) > 0 {
+			openAIReq.Stop = config.StopSequences
+		}
+		openAIReq.N = config.CandidateCount
+		openAIReq.PresencePenalty = config.PresencePenalty
+		openAIReq.FrequencyPenalty = config.FrequencyPenalty
+		openAIReq.Seed = config.Seed
+		if config.ThinkingConfig != nil && config.ThinkingConfig.ThinkingBudget != nil && *config.ThinkingConfig.ThinkingBudget > 0 {
+			openAIReq.ThinkingBudget = *config.ThinkingConfig.ThinkingBudget
+		}
+		openAIReq.ResponseFormat = geminiResponseFormat(config)
+	}
+
+	if geminiReq.SystemInstruction != nil {
+		var texts []string
+		for _, part := range geminiReq.SystemInstruction.Parts {
+			if part.Text != "" {
+				texts = append(texts, part.Text)
+			}
+		}
+		if len(texts) > 0 {
+			openAIReq.Messages = append(openAIReq.Messages, OpenAIChatMessage{
+				Role:    "system",
+				Content: strings.Join(texts, "\n"),
+			})
+		}
+	}
+
+	converter := geminiContentConverter{pendingCalls: map[string][]string{}}
+	for _, content := range geminiReq.Contents {
+		messages, err := converter.convert(content)
+		if err != nil {
+			return openAIReq, err
+		}
+		openAIReq.Messages = append(openAIReq.Messages, messages...)
+	}
+
+	for _, tool := range geminiReq.Tools {
+		for _, declaration := range tool.FunctionDeclarations {
+			parameters := declaration.ParametersJSONSchema
+			if parameters == nil {
+				parameters = lowerSchemaTypes(declaration.Parameters)
+			}
+			openAIReq.Tools = append(openAIReq.Tools, OpenAITool{
+				Type: "function",
+				Function: OpenAIFunction{
+					Name:        declaration.Name,
+					Description: declaration.Description,
+					Parameters:  parameters,
+				},
+			})
+		}
+	}
+	if geminiReq.ToolConfig != nil && geminiReq.ToolConfig.FunctionCallingConfig != nil {
+		callingConfig := geminiReq.ToolConfig.FunctionCallingConfig
+		switch strings.ToUpper(callingConfig.Mode) {
+		case "AUTO":
+			openAIReq.ToolChoice = "auto"
+		case "NONE":
+			openAIReq.ToolChoice = "none"
+		case "ANY":
+			openAIReq.ToolChoice = "required"
+			if len(callingConfig.AllowedFunctionNames) == 1 {
+				openAIReq.ToolChoice = map[string]interface{}{
+					"type": "function",
+					"function": map[string]interface{}{
+						"name": callingConfig.AllowedFunctionNames[0],
+					},
+				}
+			}
+		}
+	}
+
+	return openAIReq, nil
+}
+
+// geminiResponseFormat 将responseMimeType/responseSchema转换为response_format
+func geminiResponseFormat(config *GeminiGenerationConfig) *OpenAIResponseFormat {
+	if config.ResponseMimeType != "application/json" {
+		return nil
+	}
+	schema := config.ResponseJSONSchema
+	if schema == nil && config.ResponseSchema != nil {
+		schema = lowerSchemaTypes(config.ResponseSchema)
+	}
+	if schema == nil {
+		return &OpenAIResponseFormat{Type: "json_object"}
+	}
+	return &OpenAIResponseFormat{
+		Type: "json_schema",
+		JSONSchema: &OpenAIJSONSchema{
+			Name:   "response",
+			Schema: schema,
+		},
+	}
+}
+
+// lowerSchemaTypes Gemini的OpenAPI schema使用大写类型名(如OBJECT),转换为JSON Schema的小写类型名
+func lowerSchemaTypes(schema interface{}) interface{} {
+	switch value := schema.(type) {
+	case map[string]interface{}:
+		result := make(map[string]interface{}, len(value))
+		for key, item := range value {
+			if t, ok := item.(string); ok && key == "type" {
+				result[key] = strings.ToLower(t)
+				continue
+			}
+			result[key] = lowerSchemaTypes(item)
+		}
+		return result
+	case []interface{}:
+		result := make([]interface{}, len(value))
+		for i, item := range value {
+			result[i] = lowerSchemaTypes(item)
+		}
+		return result
+	}
+	return schema
+}
+
+// geminiContentConverter 转换消息内容,functionCall未携带id时生成id并按函数名与functionResponse配对
+type geminiContentConverter struct {
+	callCount    int
+	pendingCalls map[string][]string
+}
+
+func (g *geminiContentConverter) convert(content GeminiNativeContent) ([]OpenAIChatMessage, error) {
+	role := "user"
+	if content.Role == "model" {
+		role = "assistant"
+	}
+
+	var messages []OpenAIChatMessage
+	var parts []interface{}
+	var toolCalls []OpenAIToolCall
+	var reasoning, signature string
+	for _, part := range content.Parts {
+		switch {
+		case part.Thought:
+			reasoning += part.Text
+			signature += part.ThoughtSignature
+		case part.FunctionCall != nil:
+			id := part.FunctionCall.ID
+			if id == "" {
+				g.callCount++
+				id = fmt.Sprintf("call_%s_%d", part.FunctionCall.Name, g.callCount)
+			}
+			g.pendingCalls[part.FunctionCall.Name] = append(g.pendingCalls[part.FunctionCall.Name], id)
+			arguments, err := json.Marshal(part.FunctionCall.Args)
+			if err != nil {
+				return nil, fmt.Errorf("无法序列化functionCall参数: %v", err)
+			}
+			toolCalls = append(toolCalls, OpenAIToolCall{
+				ID:   id,
+				Type: "function",
+				Function: OpenAIFunctionCall{
+					Name:      part.FunctionCall.Name,
+					Arguments: string(arguments),
+				},
+			})
+			signature += part.ThoughtSignature
+		case part.FunctionResponse != nil:
+			response, err := json.Marshal(part.FunctionResponse.Response)
+			if err != nil {
+				return nil, fmt.Errorf("无法序列化functionResponse: %v", err)
+			}
+			messages = append(messages, OpenAIChatMessage{
+				Role:       "tool",
+				ToolCallID: g.matchCall(part.FunctionResponse),
+				Content:    string(response),
+			})
+		case part.InlineData != nil:
+			parts = append(parts, map[string]interface{}{
+				"type": "image_url",
+				"image_url": map[string]interface{}{
+					"url": fmt.Sprintf("data:%s;base64,%s", part.InlineData.MimeType, part.InlineData.Data),
+				},
+			})
+		case part.FileData != nil:
+			parts = append(parts, map[string]interface{}{
+				"type": "image_url",
+				"image_url": map[string]interface{}{
+					"url": part.FileData.FileURI,
+				},
+			})
+		case part.Text != "":
+			parts = append(parts, map[string]interface{}{
+				"type": "text",
+				"text": part.Text,
+			})
+		}
+	}
+
+	if len(parts) > 0 || len(toolCalls) > 0 || reasoning != "" {
+		message := OpenAIChatMessage{
+			Role:               role,
+			ToolCalls:          toolCalls,
+			ReasoningContent:   reasoning,
+			ReasoningSignature: signature,
+		}
+		if len(parts) > 0 {
+			message.Content = parts
+		}
+		messages = append(messages, message)
+	}
+	return messages, nil
+}
+
+// matchCall 返回functionResponse对应的工具调用id
+func (g *geminiContentConverter) matchCall(response *GeminiFunctionResponse) string {
+	pending := g.pendingCalls[response.Name]
+	if response.ID != "" {
+		for i, id := range pending {
+			if id == response.ID {
+				g.pendingCalls[response.Name] = append(pending[:i], pending[i+1:]...)
+				break
+			}
+		}
+		return response.ID
+	}
+	if len(pending) == 0 {
+		g.callCount++
+		return fmt.Sprintf("call_%s_%d", response.Name, g.callCount)
+	}
+	g.pendingCalls[response.Name] = pending[1:]
+	return pending[0]
+}
@@ -0,0 +1,296 @@
+package model
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// OllamaChatRequest Ollama对话接口(/api/chat)请求结构
+type OllamaChatRequest struct {
+	Model    string          `json:"model"`
+	Messages []OllamaMessage `json:"messages"`
+	Tools    []OpenAITool    `json:"tools,omitempty"`
+	Format   interface{}     `json:"format,omitempty"` // "json"或JSON Schema
+	Options  *OllamaOptions  `json:"options,omitempty"`
+	Stream   *bool           `json:"stream,omitempty"` // 默认为true
+	Think    interface{}     `json:"think,omitempty"`  // bool或low/medium/high
+}
+
+// OllamaGenerateRequest Ollama补全接口(/api/generate)请求结构
+type OllamaGenerateRequest struct {
+	Model   string         `json:"model"`
+	Prompt  string         `json:"prompt"`
+	Suffix  string         `json:"suffix,omitempty"`
+	System  string         `json:"system,omitempty"`
+	Images  []string       `json:"images,omitempty"`
+	Format  interface{}    `json:"format,omitempty"`
+	Options *OllamaOptions `json:"options,omitempty"`
+	Stream  *bool          `json:"stream,omitempty"`
+	Think   interface{}    `json:"think,omitempty"`
+}
+
+type OllamaMessage struct {
+	Role      string           `json:"role"`
+	Content   string           `json:"content"`
+	Thinking  string           `json:"thinking,omitempty"`
+	Images    []string         `json:"images,omitempty"` // base64图片
+	ToolCalls []OllamaToolCall `json:"tool_calls,omitempty"`
+	ToolName  string           `json:"tool_name,omitempty"`
+}
+
+type OllamaToolCall struct {
+	Function OllamaToolCallFunction `json:"function"`
+}
+
+type OllamaToolCallFunction struct {
+	Name      string                 `json:"name"`
+	Arguments map[string]interface{} `json:"arguments"`
+}
+
+// OllamaOptions Ollama的采样参数,num_predict对应max_tokens
+type OllamaOptions struct {
+	Temperature      *float64    `json:"temperature,omitempty"`
+	TopP             *float64    `json:"top_p,omitempty"`
+	TopK             *int        `json:"top_k,omitempty"`
+	NumPredict       int         `json:"num_predict,omitempty"`
+	Stop             interface{} `json:"stop,omitempty"`
+	Seed             *int64      `json:"seed,omitempty"`
+	PresencePenalty  *float64    `json:"presence_penalty,omitempty"`
+	FrequencyPenalty *float64    `json:"frequency_penalty,omitempty"`
+}
+
+// OllamaChatResponse /api/chat的响应,流式时每行一个,最后一行done为true并携带统计信息
+type OllamaChatResponse struct {
+	Model     string        `json:"model"`
+	CreatedAt string        `json:"created_at"`
+	Message   OllamaMessage `json:"message"`
+	OllamaStats
+}
+
+// OllamaGenerateResponse /api/generate的响应
+type OllamaGenerateResponse struct {
+	Model     string `json:"model"`
+	CreatedAt string `json:"created_at"`
+	Response  string `json:"response"`
+	Thinking  string `json:"thinking,omitempty"`
+	OllamaStats
+}
+
+// OllamaStats 结束状态与统计信息,耗时单位为纳秒
+type OllamaStats struct {
+	Done               bool   `json:"done"`
+	DoneReason         string `json:"done_reason,omitempty"`
+	TotalDuration      int64  `json:"total_duration,omitempty"`
+	LoadDuration       int64  `json:"load_duration,omitempty"`
+	PromptEvalCount    int    `json:"prompt_eval_count,omitempty"`
+	PromptEvalDuration int64  `json:"prompt_eval_duration,omitempty"`
+	EvalCount          int    `json:"eval_count,omitempty"`
+	EvalDuration       int64  `json:"eval_duration,omitempty"`
+}
+
+// OllamaTagsResponse /api/tags的响应
+type OllamaTagsResponse struct {
+	Models []OllamaModel `json:"models"`
+}
+
+type OllamaModel struct {
+	Name       string             `json:"name"`
+	Model      string             `json:"model"`
+	ModifiedAt string             `json:"modified_at"`
+	Size       int64              `json:"size"`
+	Digest     string             `json:"digest"`
+	Details    OllamaModelDetails `json:"details"`
+}
+
+type OllamaModelDetails struct {
+	Format            string   `json:"format"`
+	Family            string   `json:"family"`
+	Families          []string `json:"families"`
+	ParameterSize     string   `json:"parameter_size"`
+	QuantizationLevel string   `json:"quantization_level"`
+}
+
+// OllamaShowRequest /api/show请求,旧版客户端使用name字段
+type OllamaShowRequest struct {
+	Model string `json:"model"`
+	Name  string `json:"name"`
+}
+
+type OllamaShowResponse struct {
+	Modelfile    string                 `json:"modelfile"`
+	Parameters   string                 `json:"parameters"`
+	Template     string                 `json:"template"`
+	Details      OllamaModelDetails     `json:"details"`
+	ModelInfo    map[string]interface{} `json:"model_info"`
+	Capabilities []string               `json:"capabilities"`
+	ModifiedAt   string                 `json:"modified_at"`
+}
+
+// OllamaErrorResponse Ollama格式的错误响应
+type OllamaErrorResponse struct {
+	Error string `json:"error"`
+}
+
+// IsOllamaStream Ollama请求未指定stream时默认流式返回
+func IsOllamaStream(stream *bool) bool {
+	return stream == nil || *stream
+}
+
+// OllamaThinkEnabled think为true或指定强度时返回思考内容
+func OllamaThinkEnabled(think interface{}) bool {
+	switch value := think.(type) {
+	case bool:
+		return value
+	case string:
+		return value != ""
+	}
+	return false
+}
+
+// ConvertOllamaChatToOpenAIRequest 将Ollama对话请求转换为OpenAI对话请求,复用对话接口的转换链路
+func ConvertOllamaChatToOpenAIRequest(ollamaReq OllamaChatRequest) (OpenAIChatCompletionRequest, error) {
+	openAIReq := OpenAIChatCompletionRequest{
+		Model:  ollamaReq.Model,
+		Stream: IsOllamaStream(ollamaReq.Stream),
+		Tools:  ollamaReq.Tools,
+	}
+	applyOllamaOptions(&openAIReq, ollamaReq.Options, ollamaReq.Format, ollamaReq.Think)
+
+	// Ollama的工具调用没有id,按顺序与tool消息配对
+	var pendingCalls []string
+	callCount := 0
+	for _, msg := range ollamaReq.Messages {
+		message := OpenAIChatMessage{
+			Role:             msg.Role,
+			ReasoningContent: msg.Thinking,
+		}
+		switch msg.Role {
+		case "tool":
+			if len(pendingCalls) > 0 {
+				message.ToolCallID = pendingCalls[0]
+				pendingCalls = pendingCalls[1:]
+			} else {
+				callCount++
+				message.ToolCallID = fmt.Sprintf("call_%s_%d", msg.ToolName, callCount)
+			}
+			message.Content = msg.Content
+			openAIReq.Messages = append(openAIReq.Messages, message)
+			continue
+		case "assistant":
+			for _, toolCall := range msg.ToolCalls {
+				arguments, err := json.Marshal(toolCall.Function.Arguments)
+				if err != nil {
+					return openAIReq, fmt.Errorf("无法序列化tool_calls参数: %v", err)
+				}
+				callCount++
+				id := fmt.Sprintf("call_%s_%d", toolCall.Function.Name, callCount)
+				pendingCalls = append(pendingCalls, id)
+				message.ToolCalls = append(message.ToolCalls, OpenAIToolCall{
+					ID:   id,
+					Type: "function",
+					Function: OpenAIFunctionCall{
+						Name:      toolCall.Function.Name,
+						Arguments: string(arguments),
+					},
+				})
+			}
+		}
+		message.Content = ollamaMessageContent(msg.Content, msg.Images)
+		openAIReq.Messages = append(openAIReq.Messages, message)
+	}
+	return openAIReq, nil
+}
+
+// ConvertOllamaGenerateToOpenAIRequest 将Ollama补全请求转换为OpenAI对话请求。
+// 与Ollama套用对话模板一致,prompt作为用户消息;指定suffix时按中间填充处理
+func ConvertOllamaGenerateToOpenAIRequest(ollamaReq OllamaGenerateRequest) OpenAIChatCompletionRequest {
+	var openAIReq OpenAIChatCompletionRequest
+	if ollamaReq.Suffix != "" {
+		completionReq := OpenAICompletionRequest{Model: ollamaReq.Model, Suffix: ollamaReq.Suffix}
+		openAIReq = ConvertCompletionToOpenAIRequest(completionReq, ollamaReq.Prompt)
+	} else {
+		openAIReq = OpenAIChatCompletionRequest{
+			Model:    ollamaReq.Model,
+			Messages: []OpenAIChatMessage{{Role: "user", Content: ollamaMessageContent(ollamaReq.Prompt, ollamaReq.Images)}},
+		}
+		if ollamaReq.System != "" {
+			openAIReq.Messages = append([]OpenAIChatMessage{{Role: "system", Content: ollamaReq.System}}, openAIReq.Messages...)
+		}
+	}
+	openAIReq.Stream = IsOllamaStream(ollamaReq.Stream)
+	applyOllamaOptions(&openAIReq, ollamaReq.Options, ollamaReq.Format, ollamaReq.Think)
+	return openAIReq
+}
+
+func applyOllamaOptions(openAIReq *OpenAIChatCompletionRequest, options *OllamaOptions, format interface{}, think interface{}) {
+	if options != nil {
+		openAIReq.Temperature = options.Temperature
+		openAIReq.TopP = options.TopP
+		openAIReq.TopK = options.TopK
+		if options.NumPredict > 0 {
+			openAIReq.MaxTokens = options.NumPredict
+		}
+		openAIReq.Stop = options.Stop
+		openAIReq.Seed = options.Seed
+		openAIReq.PresencePenalty = options.PresencePenalty
+		openAIReq.FrequencyPenalty = options.FrequencyPenalty
+	}
+
+	switch value := format.(type) {
+	case string:
+		if value == "json" {
+			openAIReq.ResponseFormat = &OpenAIResponseFormat{Type: "json_object"}
+		}
+	case map[string]interface{}:
+		openAIReq.ResponseFormat = &OpenAIResponseFormat{
+			Type:       "json_schema",
+			JSONSchema: &OpenAIJSONSchema{Name: "response", Schema: value},
+		}
+	}
+
+	switch value := think.(type) {
+	case bool:
+		if value {
+			openAIReq.ReasoningEffort = defaultReasoningEffort
+		}
+	case string:
+		openAIReq.ReasoningEffort = value
+	}
+}
+
+// ollamaMessageContent 带图片时转换为多段内容
+func ollamaMessageContent(content string, images []string) interface{} {
+	if len(images) == 0 {
+		return content
+	}
+	var parts []interface{}
+	if content != "" {
+		parts = append(parts, map[string]interface{}{
+			"type": "text",
+			"text": content,
+		})
+	}
+	for _, image := range images {
+		parts = append(parts, map[string]interface{}{
+			"type": "image_url",
+			"image_url": map[string]interface{}{
+				"url": fmt.Sprintf("data:%s;base64,%s", base64ImageMimeType(image), image),
+			},
+		})
+	}
+	return parts
+}
+
+// base64ImageMimeType 按文件头推断base64图片的类型,无法识别时按png处理
+func base64ImageMimeType(data string) string {
+	switch {
+	case strings.HasPrefix(data, "/9j/"):
+		return "image/jpeg"
+	case strings.HasPrefix(data, "R0lGOD"):
+		return "image/gif"
+	case strings.HasPrefix(data, "UklGR"):
+		return "image/webp"
+	}
+	return "image/png"
+}
@@ -0,0 +1,106 @@
+package model
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"kilo2api/common"
+)
+
+// ResponseFormatToolName 不支持response_format的上游通过强制调用该工具模拟结构化输出
+const ResponseFormatToolName = "json_response"
+
+// OpenAIResponseFormat 结构化输出格式
+type OpenAIResponseFormat struct {
+	Type       string            `json:"type"`
+	JSONSchema *OpenAIJSONSchema `json:"json_schema,omitempty"`
+}
+
+// OpenAIJSONSchema json_schema格式的schema定义
+type OpenAIJSONSchema struct {
+	Name        string      `json:"name"`
+	Description string      `json:"description,omitempty"`
+	Schema      interface{} `json:"schema,omitempty"`
+	Strict      *bool       `json:"strict,omitempty"`
+}
+
+// IsJSON 是否要求以JSON输出
+func (f *OpenAIResponseFormat) IsJSON() bool {
+	return f != nil && (f.Type == "json_object" || f.Type == "json_schema")
+}
+
+// Check 校验response_format参数
+func (f *OpenAIResponseFormat) Check() error {
+	if f == nil {
+		return nil
+	}
+	switch f.Type {
+	case "text", "json_object":
+		return nil
+	case "json_schema":
+		if f.JSONSchema == nil || f.JSONSchema.Name == "" {
+			return errors.New("response_format.json_schema.name is required")
+		}
+		if f.JSONSchema.Schema != nil {
+			if _, ok := f.JSONSchema.Schema.(map[string]interface{}); !ok {
+				return errors.New("response_format.json_schema.schema must be an object")
+			}
+			if err := common.CheckJSONSchema(f.JSONSchema.Schema); err != nil {
+				return fmt.Errorf("invalid response_format.json_schema.schema: %v", err)
+			}
+		}
+		return nil
+	}
+	return fmt.Errorf("invalid response_format type %s, expected one of: text, json_object, json_schema", f.Type)
+}
+
+// ValidateOutput 校验模型输出是否符合response_format
+func (f *OpenAIResponseFormat) ValidateOutput(output string) error {
+	if !f.IsJSON() {
+		return nil
+	}
+	var value interface{}
+	if err := json.Unmarshal([]byte(output), &value); err != nil {
+		return fmt.Errorf("output is not valid JSON: %v", err)
+	}
+	if f.Type == "json_object" || f.JSONSchema.Schema == nil {
+		if _, ok := value.(map[string]interface{}); !ok {
+			return errors.New("output is not a JSON object")
+		}
+		return nil
+	}
+	return common.ValidateJSONSchema(f.JSONSchema.Schema, value)
+}
+
+// claudeResponseFormatTool 构造模拟结构化输出的工具,工具的input_schema即目标schema
+func claudeResponseFormatTool(f *OpenAIResponseFormat) (ClaudeTool, error) {
+	tool := ClaudeTool{
+		Name:        ResponseFormatToolName,
+		Description: "Respond with a JSON object that conforms to the input schema.",
+		InputSchema: map[string]interface{}{"type": "object"},
+	}
+	if f.Type != "json_schema" {
+		return tool, nil
+	}
+	if f.JSONSchema.Description != "" {
+		tool.Description = f.JSONSchema.Description
+	}
+	if f.JSONSchema.Schema != nil {
+		if err := f.CheckToolSchema(); err != nil {
+			return tool, err
+		}
+		tool.InputSchema = f.JSONSchema.Schema
+	}
+	return tool, nil
+}
+
+// CheckToolSchema 以工具调用模拟结构化输出时,工具的input_schema必须为object
+func (f *OpenAIResponseFormat) CheckToolSchema() error {
+	if f == nil || f.Type != "json_schema" || f.JSONSchema == nil || f.JSONSchema.Schema == nil {
+		return nil
+	}
+	if schema, _ := f.JSONSchema.Schema.(map[string]interface{}); schema["type"] != "object" {
+		return errors.New("response_format.json_schema.schema must have type object for Claude models")
+	}
+	return nil
+}
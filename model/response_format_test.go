@@ -0,0 +1,40 @@
+package model
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestResponseFormatCheckToolSchema(t *testing.T) {
+	tests := []struct {
+		name    string
+		format  string
+		wantErr bool
+	}{
+		{"object schema", `{"type":"json_schema","json_schema":{"name":"x","schema":{"type":"object","properties":{"a":{"type":"string"}}}}}`, false},
+		{"array schema", `{"type":"json_schema","json_schema":{"name":"x","schema":{"type":"array","items":{"type":"string"}}}}`, true},
+		{"schema without type", `{"type":"json_schema","json_schema":{"name":"x","schema":{"properties":{}}}}`, true},
+		{"no schema", `{"type":"json_schema","json_schema":{"name":"x"}}`, false},
+		{"json_object", `{"type":"json_object"}`, false},
+		{"text", `{"type":"text"}`, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var format OpenAIResponseFormat
+			if err := json.Unmarshal([]byte(tt.format), &format); err != nil {
+				t.Fatal(err)
+			}
+			if err := format.Check(); err != nil {
+				t.Fatalf("Check: %v", err)
+			}
+			if err := format.CheckToolSchema(); (err != nil) != tt.wantErr {
+				t.Fatalf("CheckToolSchema err = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+
+	var format *OpenAIResponseFormat
+	if err := format.CheckToolSchema(); err != nil {
+		t.Fatalf("nil response_format: %v", err)
+	}
+}
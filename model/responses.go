@@ -0,0 +1,259 @@
+package model
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// OpenAIResponsesRequest OpenAI Responses接口(/v1/responses)请求结构
+type OpenAIResponsesRequest struct {
+	Model             string                 `json:"model"`
+	Input             interface{}            `json:"input"`
+	Instructions      string                 `json:"instructions,omitempty"`
+	Stream            bool                   `json:"stream,omitempty"`
+	MaxOutputTokens   int                    `json:"max_output_tokens,omitempty"`
+	Temperature       *float64               `json:"temperature,omitempty"`
+	TopP              *float64               `json:"top_p,omitempty"`
+	Tools             []OpenAIResponsesTool  `json:"tools,omitempty"`
+	ToolChoice        interface{}            `json:"tool_choice,omitempty"`
+	ParallelToolCalls *bool                  `json:"parallel_tool_calls,omitempty"`
+	Reasoning         *OpenAIReasoning       `json:"reasoning,omitempty"`
+	Store             *bool                  `json:"store,omitempty"`
+	Metadata          map[string]interface{} `json:"metadata,omitempty"`
+	User              string                 `json:"user,omitempty"`
+}
+
+// OpenAIResponsesTool Responses接口的工具定义,函数字段平铺在顶层
+type OpenAIResponsesTool struct {
+	Type        string      `json:"type"`
+	Name        string      `json:"name,omitempty"`
+	Description string      `json:"description,omitempty"`
+	Parameters  interface{} `json:"parameters,omitempty"`
+	Strict      *bool       `json:"strict,omitempty"`
+}
+
+// OpenAIReasoning Responses接口的推理配置
+type OpenAIReasoning struct {
+	Effort  string `json:"effort,omitempty"`
+	Summary string `json:"summary,omitempty"`
+}
+
+// ConvertResponsesToOpenAIRequest 将Responses请求转换为OpenAI对话请求,复用对话接口的转换链路
+func ConvertResponsesToOpenAIRequest(responsesReq OpenAIResponsesRequest) (OpenAIChatCompletionRequest, error) {
+	openAIReq := OpenAIChatCompletionRequest{
+		Model:             responsesReq.Model,
+		Stream:            responsesReq.Stream,
+		MaxTokens:         responsesReq.MaxOutputTokens,
+		ParallelToolCalls: responsesReq.ParallelToolCalls,
+	}
+	openAIReq.Temperature = responsesReq.Temperature
+	if responsesReq.Reasoning != nil {
+		openAIReq.ReasoningEffort = responsesReq.Reasoning.Effort
+	}
+	openAIReq.TopP = responsesReq.TopP
+	openAIReq.User = responsesReq.User
+
+	if responsesReq.Instructions != "" {
+		openAIReq.Messages = append(openAIReq.Messages, OpenAIChatMessage{
+			Role:    "system",
+			Content: responsesReq.Instructions,
+		})
+	}
+
+	switch input := responsesReq.Input.(type) {
+	case nil:
+	case string:
+		openAIReq.Messages = append(openAIReq.Messages, OpenAIChatMessage{
+			Role:    "user",
+			Content: input,
+		})
+	case []interface{}:
+		for _, rawItem := range input {
+			item, ok := rawItem.(map[string]interface{})
+			if !ok {
+				continue
+			}
+			if err := appendResponsesInputItem(&openAIReq, item); err != nil {
+				return openAIReq, err
+			}
+		}
+	default:
+		return openAIReq, fmt.Errorf("unsupported input type %T", responsesReq.Input)
+	}
+
+	for _, tool := range responsesReq.Tools {
+		if tool.Type != "function" {
+			continue
+		}
+		openAIReq.Tools = append(openAIReq.Tools, OpenAITool{
+			Type: "function",
+			Function: OpenAIFunction{
+				Name:        tool.Name,
+				Description: tool.Description,
+				Parameters:  tool.Parameters,
+				Strict:      tool.Strict,
+			},
+		})
+	}
+
+	switch toolChoice := responsesReq.ToolChoice.(type) {
+	case string:
+		openAIReq.ToolChoice = toolChoice
+	case map[string]interface{}:
+		if name, ok := toolChoice["name"].(string); ok && name != "" {
+			openAIReq.ToolChoice = map[string]interface{}{
+				"type": "function",
+				"function": map[string]interface{}{
+					"name": name,
+				},
+			}
+		}
+	}
+
+	return openAIReq, nil
+}
+
+// appendResponsesInputItem 将单个input项追加为对话消息
+func appendResponsesInputItem(openAIReq *OpenAIChatCompletionRequest, item map[string]interface{}) error {
+	itemType, _ := item["type"].(string)
+	if itemType == "" {
+		// 省略type的简写消息 {"role": "...", "content": "..."}
+		itemType = "message"
+	}
+
+	switch itemType {
+	case "message":
+		role, _ := item["role"].(string)
+		if role == "developer" {
+			role = "system"
+		}
+		content, err := convertResponsesContent(item["content"])
+		if err != nil {
+			return err
+		}
+		// 推理项之后的助手消息与推理项合并为同一条消息
+		if n := len(openAIReq.Messages); role == "assistant" && n > 0 && openAIReq.Messages[n-1].Role == "assistant" && openAIReq.Messages[n-1].Content == nil && len(openAIReq.Messages[n-1].ToolCalls) == 0 {
+			openAIReq.Messages[n-1].Content = content
+			return nil
+		}
+		openAIReq.Messages = append(openAIReq.Messages, OpenAIChatMessage{
+			Role:    role,
+			Content: content,
+		})
+	case "function_call":
+		callID, _ := item["call_id"].(string)
+		name, _ := item["name"].(string)
+		arguments, _ := item["arguments"].(string)
+		toolCall := OpenAIToolCall{
+			ID:   callID,
+			Type: "function",
+			Function: OpenAIFunctionCall{
+				Name:      name,
+				Arguments: arguments,
+			},
+		}
+		// 连续的函数调用合并到同一条助手消息
+		if n := len(openAIReq.Messages); n > 0 && openAIReq.Messages[n-1].Role == "assistant" {
+			openAIReq.Messages[n-1].ToolCalls = append(openAIReq.Messages[n-1].ToolCalls, toolCall)
+		} else {
+			openAIReq.Messages = append(openAIReq.Messages, OpenAIChatMessage{
+				Role:      "assistant",
+				ToolCalls: []OpenAIToolCall{toolCall},
+			})
+		}
+	case "function_call_output":
+		callID, _ := item["call_id"].(string)
+		output, ok := item["output"].(string)
+		if !ok {
+			outputBytes, err := json.Marshal(item["output"])
+			if err != nil {
+				return fmt.Errorf("无法序列化function_call_output: %v", err)
+			}
+			output = string(outputBytes)
+		}
+		openAIReq.Messages = append(openAIReq.Messages, OpenAIChatMessage{
+			Role:       "tool",
+			ToolCallID: callID,
+			Content:    output,
+		})
+	case "reasoning":
+		// 仅携带encrypted_content(思考块签名)的推理项回传上游,作为下一条助手消息的思考内容
+		signature, _ := item["encrypted_content"].(string)
+		if signature == "" {
+			return nil
+		}
+		var texts []string
+		summary, _ := item["summary"].([]interface{})
+		for _, rawPart := range summary {
+			if part, ok := rawPart.(map[string]interface{}); ok {
+				text, _ := part["text"].(string)
+				texts = append(texts, text)
+			}
+		}
+		openAIReq.Messages = append(openAIReq.Messages, OpenAIChatMessage{
+			Role:               "assistant",
+			ReasoningContent:   strings.Join(texts, ""),
+			ReasoningSignature: signature,
+		})
+	default:
+		return fmt.Errorf("unsupported input item type %s", itemType)
+	}
+	return nil
+}
+
+// convertResponsesContent 将Responses的内容块转换为OpenAI对话的多模态内容
+func convertResponsesContent(content interface{}) (interface{}, error) {
+	parts, ok := content.([]interface{})
+	if !ok {
+		return content, nil
+	}
+
+	var converted []interface{}
+	var texts []string
+	hasImage := false
+	for _, rawPart := range parts {
+		part, ok := rawPart.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		switch part["type"] {
+		case "input_text", "output_text", "text":
+			text, _ := part["text"].(string)
+			texts = append(texts, text)
+			converted = append(converted, map[string]interface{}{
+				"type": "text",
+				"text": text,
+			})
+		case "input_image":
+			imageURL, _ := part["image_url"].(string)
+			if imageURL == "" {
+				continue
+			}
+			hasImage = true
+			imagePart := map[string]interface{}{
+				"url": imageURL,
+			}
+			if detail, ok := part["detail"].(string); ok {
+				imagePart["detail"] = detail
+			}
+			converted = append(converted, map[string]interface{}{
+				"type":      "image_url",
+				"image_url": imagePart,
+			})
+		case "refusal":
+			refusal, _ := part["refusal"].(string)
+			texts = append(texts, refusal)
+			converted = append(converted, map[string]interface{}{
+				"type": "text",
+				"text": refusal,
+			})
+		}
+	}
+
+	// 纯文本内容合并为字符串,兼容不支持多模态数组的上游
+	if !hasImage {
+		return strings.Join(texts, ""), nil
+	}
+	return converted, nil
+}
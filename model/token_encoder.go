@@ -1,19 +1,52 @@
 package model
 
 import (
+	"encoding/json"
 	"errors"
 	"fmt"
 	"github.com/pkoukk/tiktoken-go"
 	"kilo2api/common"
 	logger "kilo2api/common/loggger"
+	"math"
 
 	//"kilo2api/model"
 	"strings"
+	"sync"
 )
 
 // tokenEncoderMap won't grow after initialization
 var tokenEncoderMap = map[string]*tiktoken.Tiktoken{}
+var tokenEncoderLock sync.RWMutex
 var defaultTokenEncoder *tiktoken.Tiktoken
+var gpt4TokenEncoder *tiktoken.Tiktoken
+
+// TokenEncoder 按模型计算文本的token数
+type TokenEncoder interface {
+	Count(text string) int
+}
+
+type tiktokenEncoder struct {
+	encoder *tiktoken.Tiktoken
+}
+
+func (e tiktokenEncoder) Count(text string) int {
+	return getTokenNum(e.encoder, text)
+}
+
+// claudeTokenRatio Claude 3及以后的分词器未公开,实测同一文本的token数约为cl100k的1.15倍
+const claudeTokenRatio = 1.15
+
+// claudeTokenEncoder 以cl100k计数按比例放大,近似Claude的分词结果
+type claudeTokenEncoder struct {
+	base *tiktoken.Tiktoken
+}
+
+func (e claudeTokenEncoder) Count(text string) int {
+	if text == "" {
+		return 0
+	}
+	return int(math.Ceil(float64(getTokenNum(e.base, text)) * claudeTokenRatio))
+}
 
 func InitTokenEncoders() {
 	logger.SysLog("initializing token encoders...")
@@ -26,7 +59,7 @@ func InitTokenEncoders() {
 	if err != nil {
 		logger.FatalLog(fmt.Sprintf("failed to get gpt-4o token encoder: %s", err.Error()))
 	}
-	gpt4TokenEncoder, err := tiktoken.EncodingForModel("gpt-4")
+	gpt4TokenEncoder, err = tiktoken.EncodingForModel("gpt-4")
 	if err != nil {
 		logger.FatalLog(fmt.Sprintf("failed to get gpt-4 token encoder: %s", err.Error()))
 	}
@@ -44,8 +77,18 @@ func InitTokenEncoders() {
 	logger.SysLog("token encoders initialized.")
 }
 
+// GetTokenEncoder 获取模型对应的编码器,Claude模型使用近似编码器
+func GetTokenEncoder(model string) TokenEncoder {
+	if isClaudeModel(model) && gpt4TokenEncoder != nil {
+		return claudeTokenEncoder{base: gpt4TokenEncoder}
+	}
+	return tiktokenEncoder{encoder: getTokenEncoder(model)}
+}
+
 func getTokenEncoder(model string) *tiktoken.Tiktoken {
+	tokenEncoderLock.RLock()
 	tokenEncoder, ok := tokenEncoderMap[model]
+	tokenEncoderLock.RUnlock()
 	if ok && tokenEncoder != nil {
 		return tokenEncoder
 	}
@@ -55,18 +98,36 @@ func getTokenEncoder(model string) *tiktoken.Tiktoken {
 			//logger.SysError(fmt.Sprintf("[IGNORE] | failed to get token encoder for model %s: %s, using encoder for gpt-3.5-turbo", model, err.Error()))
 			tokenEncoder = defaultTokenEncoder
 		}
+		tokenEncoderLock.Lock()
 		tokenEncoderMap[model] = tokenEncoder
+		tokenEncoderLock.Unlock()
 		return tokenEncoder
 	}
 	return defaultTokenEncoder
 }
 
+// isClaudeModel 判断模型是否为Claude模型(包括经OpenRouter转发的anthropic/claude-*)
+func isClaudeModel(model string) bool {
+	if modelInfo, ok := common.GetModelInfo(model); ok {
+		return modelInfo.Source == "claude" || strings.Contains(strings.ToLower(modelInfo.Model), "claude")
+	}
+	return strings.Contains(strings.ToLower(model), "claude")
+}
+
+// isGeminiModel 判断模型是否为Gemini模型
+func isGeminiModel(model string) bool {
+	if modelInfo, ok := common.GetModelInfo(model); ok {
+		model = modelInfo.Model
+	}
+	return strings.Contains(strings.ToLower(model), "gemini")
+}
+
 func getTokenNum(tokenEncoder *tiktoken.Tiktoken, text string) int {
 	return len(tokenEncoder.Encode(text, nil, nil))
 }
 
 func CountTokenMessages(messages []OpenAIChatMessage, model string) int {
-	tokenEncoder := getTokenEncoder(model)
+	tokenEncoder := GetTokenEncoder(model)
 	// Reference:
 	// https://github.com/openai/openai-cookbook/blob/main/examples/How_to_count_tokens_with_tiktoken.ipynb
 	// https://github.com/pkoukk/tiktoken-go/issues/6
@@ -83,7 +144,7 @@ func CountTokenMessages(messages []OpenAIChatMessage, model string) int {
 		tokenNum += tokensPerMessage
 		switch v := message.Content.(type) {
 		case string:
-			tokenNum += getTokenNum(tokenEncoder, v)
+			tokenNum += tokenEncoder.Count(v)
 		case []any:
 			for _, it := range v {
 				m := it.(map[string]any)
@@ -91,7 +152,7 @@ func CountTokenMessages(messages []OpenAIChatMessage, model string) int {
 				case "text":
 					if textValue, ok := m["text"]; ok {
 						if textString, ok := textValue.(string); ok {
-							tokenNum += getTokenNum(tokenEncoder, textString)
+							tokenNum += tokenEncoder.Count(textString)
 						}
 					}
 				case "image_url":
@@ -112,7 +173,7 @@ func CountTokenMessages(messages []OpenAIChatMessage, model string) int {
 				}
 			}
 		}
-		tokenNum += getTokenNum(tokenEncoder, message.Role)
+		tokenNum += tokenEncoder.Count(message.Role)
 	}
 	tokenNum += 3 // Every reply is primed with <|start|>assistant<|message|>
 	return tokenNum
@@ -126,11 +187,39 @@ const (
 	gpt4oMiniLowDetailCost  = 2833
 	gpt4oMiniHighDetailCost = 5667
 	gpt4oMiniAdditionalCost = 2833
+	// Gemini每张图片固定消耗258 token
+	geminiImageCost = 258
+	// Claude图片token约为宽*高/750,长边超过1568或超过约1.15百万像素时先缩放
+	claudeImagePixelsPerToken = 750
+	claudeImageMaxEdge        = 1568
+	claudeImageMaxPixels      = 1150000
+	// remoteImageSize 远程图片不下载,按Claude无需缩放的最大正方形尺寸估算
+	remoteImageSize = 1092
 )
 
+// imageSize 图片宽高,只解析data URL,远程图片使用固定估算尺寸,计数时不发起网络请求
+func imageSize(url string) (int, int, error) {
+	if !strings.HasPrefix(url, "data:") {
+		return remoteImageSize, remoteImageSize, nil
+	}
+	return common.GetImageSize(url)
+}
+
 // https://platform.openai.com/docs/guides/vision/calculating-costs
 // https://github.com/openai/openai-cookbook/blob/05e3f9be4c7a2ae7ecf029a7c32065b024730ebe/examples/How_to_count_tokens_with_tiktoken.ipynb
 func countImageTokens(url string, detail string, model string) (_ int, err error) {
+	// Claude与Gemini不区分detail
+	if isClaudeModel(model) {
+		width, height, err := imageSize(url)
+		if err != nil {
+			return 0, err
+		}
+		return claudeImageTokens(width, height), nil
+	}
+	if isGeminiModel(model) {
+		return geminiImageCost, nil
+	}
+
 	// Reference: https://platform.openai.com/docs/guides/vision/low-or-high-fidelity-image-understanding
 	// detail == "auto" is undocumented on how it works, it just said the model will use the auto setting which will look at the image input size and decide if it should use the low or high setting.
 	// According to the official guide, "low" disable the high-res model,
@@ -166,11 +255,52 @@ func countImageTokens(url string, detail string, model string) (_ int, err error
 			return gpt4oMiniLowDetailCost, nil
 		}
 		return lowDetailCost, nil
+	case "high":
+		width, height, err := imageSize(url)
+		if err != nil {
+			return 0, err
+		}
+		tiles := highDetailTiles(width, height)
+		if strings.HasPrefix(model, "gpt-4o-mini") {
+			return tiles*gpt4oMiniHighDetailCost + gpt4oMiniAdditionalCost, nil
+		}
+		return tiles*highDetailCostPerTile + additionalCost, nil
 	default:
 		return 0, errors.New("invalid detail option")
 	}
 }
 
+// highDetailTiles high模式下先将图片缩放至2048x2048以内,再将短边缩放至768,按512x512切块
+func highDetailTiles(width, height int) int {
+	if width <= 0 || height <= 0 {
+		return 0
+	}
+	w, h := float64(width), float64(height)
+	if w > 2048 || h > 2048 {
+		ratio := 2048 / math.Max(w, h)
+		w, h = w*ratio, h*ratio
+	}
+	if shortest := math.Min(w, h); shortest > 768 {
+		ratio := 768 / shortest
+		w, h = w*ratio, h*ratio
+	}
+	return int(math.Ceil(w/512) * math.Ceil(h/512))
+}
+
+// claudeImageTokens 按Claude的缩放规则计算图片token
+func claudeImageTokens(width, height int) int {
+	w, h := float64(width), float64(height)
+	if longest := math.Max(w, h); longest > claudeImageMaxEdge {
+		ratio := claudeImageMaxEdge / longest
+		w, h = w*ratio, h*ratio
+	}
+	if w*h > claudeImageMaxPixels {
+		ratio := math.Sqrt(claudeImageMaxPixels / (w * h))
+		w, h = w*ratio, h*ratio
+	}
+	return int(math.Ceil(w * h / claudeImagePixelsPerToken))
+}
+
 func CountTokenInput(input any, model string) int {
 	switch v := input.(type) {
 	case string:
@@ -186,10 +316,122 @@ func CountTokenInput(input any, model string) int {
 }
 
 func CountTokenText(text string, model string) int {
-	tokenEncoder := getTokenEncoder(model)
-	return getTokenNum(tokenEncoder, text)
+	return GetTokenEncoder(model).Count(text)
 }
 
 func CountToken(text string) int {
 	return CountTokenInput(text, "gpt-3.5-turbo")
 }
+
+// CountRequestBodyTokens 统计转换后上游请求体的输入token,
+// 支持Claude(system/messages/tools)与OpenAI格式(messages/tools)的请求体
+func CountRequestBodyTokens(body map[string]interface{}, model string) int {
+	counter := bodyTokenCounter{encoder: GetTokenEncoder(model), model: model}
+	tokenNum := counter.countFixed(body)
+	messages, _ := body["messages"].([]interface{})
+	for _, message := range messages {
+		tokenNum += counter.countMessage(message)
+	}
+	return tokenNum
+}
+
+type bodyTokenCounter struct {
+	encoder TokenEncoder
+	model   string
+}
+
+// countFixed 统计messages以外的部分(system、tools及回复前缀)
+func (b bodyTokenCounter) countFixed(body map[string]interface{}) int {
+	tokenNum := b.count(body["system"]) + b.countJSON(body["tools"])
+	if messages, _ := body["messages"].([]interface{}); len(messages) > 0 {
+		tokenNum += 3 // Every reply is primed with <|start|>assistant<|message|>
+	}
+	return tokenNum
+}
+
+func (b bodyTokenCounter) countMessage(rawMessage interface{}) int {
+	message, ok := rawMessage.(map[string]interface{})
+	if !ok {
+		return 0
+	}
+	// 与CountTokenMessages一致,每条消息额外计3个token
+	role, _ := message["role"].(string)
+	return 3 + b.encoder.Count(role) + b.count(message["content"]) + b.countJSON(message["tool_calls"])
+}
+
+func (b bodyTokenCounter) count(content interface{}) int {
+	switch value := content.(type) {
+	case string:
+		return b.encoder.Count(value)
+	case []interface{}:
+		tokenNum := 0
+		for _, item := range value {
+			tokenNum += b.count(item)
+		}
+		return tokenNum
+	case map[string]interface{}:
+		return b.countBlock(value)
+	}
+	return 0
+}
+
+func (b bodyTokenCounter) countBlock(block map[string]interface{}) int {
+	switch block["type"] {
+	case "text":
+		text, _ := block["text"].(string)
+		return b.encoder.Count(text)
+	case "thinking":
+		thinking, _ := block["thinking"].(string)
+		return b.encoder.Count(thinking)
+	case "redacted_thinking":
+		return 0
+	case "image", "image_url":
+		return b.countImage(block)
+	case "tool_use":
+		name, _ := block["name"].(string)
+		return b.encoder.Count(name) + b.countJSON(block["input"])
+	case "tool_result":
+		return b.count(block["content"])
+	}
+	return b.countJSON(block)
+}
+
+// countImage 支持Claude的source、OpenRouter的image.url与OpenAI的image_url.url
+func (b bodyTokenCounter) countImage(block map[string]interface{}) int {
+	var url, detail string
+	if source, ok := block["source"].(map[string]interface{}); ok {
+		if source["type"] == "base64" {
+			mediaType, _ := source["media_type"].(string)
+			data, _ := source["data"].(string)
+			url = fmt.Sprintf("data:%s;base64,%s", mediaType, data)
+		} else {
+			url, _ = source["url"].(string)
+		}
+	}
+	for _, key := range []string{"image", "image_url"} {
+		if image, ok := block[key].(map[string]interface{}); ok {
+			url, _ = image["url"].(string)
+			detail, _ = image["detail"].(string)
+		}
+	}
+	if url == "" {
+		return 0
+	}
+	imageTokens, err := countImageTokens(url, detail, b.model)
+	if err != nil {
+		logger.SysError("error counting image tokens: " + err.Error())
+		return 0
+	}
+	return imageTokens
+}
+
+func (b bodyTokenCounter) countJSON(value interface{}) int {
+	if value == nil {
+		return 0
+	}
+	data, err := json.Marshal(value)
+	if err != nil {
+		return 0
+	}
+	return b.encoder.Count(string(data))
+}
@@ -0,0 +1,55 @@
+package model
+
+import (
+	"bytes"
+	"encoding/base64"
+	"image"
+	"image/png"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+)
+
+func pngDataURL(t *testing.T, width, height int) string {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, image.NewRGBA(image.Rect(0, 0, width, height))); err != nil {
+		t.Fatal(err)
+	}
+	return "data:image/png;base64," + base64.StdEncoding.EncodeToString(buf.Bytes())
+}
+
+func TestCountImageTokensDoesNotFetchRemoteImages(t *testing.T) {
+	var hits int64
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt64(&hits, 1)
+	}))
+	defer server.Close()
+
+	for _, tt := range []struct{ model, detail string }{
+		{"claude-sonnet-4-20250514", ""},
+		{"gpt-4o", "high"},
+	} {
+		tokens, err := countImageTokens(server.URL+"/image.png", tt.detail, tt.model)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.model, err)
+		}
+		if tokens <= 0 {
+			t.Fatalf("%s: expected a fixed estimate for remote images, got %d", tt.model, tokens)
+		}
+	}
+	if hits != 0 {
+		t.Fatalf("remote image was fetched %d times", hits)
+	}
+}
+
+func TestCountImageTokensDataURL(t *testing.T) {
+	tokens, err := countImageTokens(pngDataURL(t, 750, 100), "", "claude-sonnet-4-20250514")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tokens != 100 {
+		t.Fatalf("expected 100 tokens for a 750x100 image, got %d", tokens)
+	}
+}
@@ -0,0 +1,134 @@
+package model
+
+import (
+	"fmt"
+	"kilo2api/common"
+)
+
+// chatRoles 支持的消息角色
+var chatRoles = map[string]bool{
+	"system":    true,
+	"developer": true,
+	"user":      true,
+	"assistant": true,
+	"tool":      true,
+}
+
+// invalidRequest 构造param指向具体字段的参数错误
+func invalidRequest(param, code, format string, args ...interface{}) *OpenAIError {
+	return &OpenAIError{
+		Message: fmt.Sprintf(format, args...),
+		Type:    "invalid_request_error",
+		Param:   param,
+		Code:    code,
+	}
+}
+
+// Validate 校验对话请求的消息与采样参数,在过滤空消息前执行,param中的下标与客户端请求一致
+func (r *OpenAIChatCompletionRequest) Validate(modelInfo common.ModelInfo) *OpenAIError {
+	if len(r.Messages) == 0 {
+		return invalidRequest("messages", "invalid_messages", "messages must contain at least one message")
+	}
+	if r.Temperature != nil && (*r.Temperature < 0 || *r.Temperature > 2) {
+		return invalidRequest("temperature", "invalid_temperature", "Invalid temperature %g, expected a value between 0 and 2", *r.Temperature)
+	}
+	if r.TopP != nil && (*r.TopP < 0 || *r.TopP > 1) {
+		return invalidRequest("top_p", "invalid_top_p", "Invalid top_p %g, expected a value between 0 and 1", *r.TopP)
+	}
+
+	for i, msg := range r.Messages {
+		if !chatRoles[msg.Role] {
+			return invalidRequest(fmt.Sprintf("messages[%d].role", i), "invalid_role",
+				"Invalid role '%s', expected one of: system, developer, user, assistant, tool", msg.Role)
+		}
+		if openAIError := validateMessageContent(i, msg, modelInfo); openAIError != nil {
+			return openAIError
+		}
+		if msg.Role != "tool" {
+			continue
+		}
+		// 工具结果必须紧跟在携带tool_calls的助手消息(或其他工具结果)之后
+		if msg.ToolCallID == "" {
+			return invalidRequest(fmt.Sprintf("messages[%d].tool_call_id", i), "invalid_tool_message",
+				"messages with role 'tool' must have a tool_call_id")
+		}
+		if i == 0 || (r.Messages[i-1].Role != "tool" && len(r.Messages[i-1].ToolCalls) == 0) {
+			return invalidRequest(fmt.Sprintf("messages[%d].role", i), "invalid_tool_message",
+				"messages with role 'tool' must be a response to a preceding message with 'tool_calls'")
+		}
+	}
+
+	last := len(r.Messages) - 1
+	if len(r.Messages[last].ToolCalls) > 0 {
+		return invalidRequest(fmt.Sprintf("messages[%d]", last), "invalid_tool_message",
+			"An assistant message with 'tool_calls' must be followed by tool messages responding to each 'tool_call_id'")
+	}
+	return nil
+}
+
+// validateMessageContent 校验消息内容的格式,以及非视觉模型是否收到了图片
+func validateMessageContent(index int, msg OpenAIChatMessage, modelInfo common.ModelInfo) *OpenAIError {
+	switch content := msg.Content.(type) {
+	case nil, string:
+		return nil
+	case []interface{}:
+		for j, part := range content {
+			param := fmt.Sprintf("messages[%d].content[%d]", index, j)
+			partMap, ok := part.(map[string]interface{})
+			if !ok {
+				return invalidRequest(param, "invalid_content", "Content parts must be objects")
+			}
+			partType, _ := partMap["type"].(string)
+			switch partType {
+			case "":
+				return invalidRequest(param+".type", "invalid_content", "Content part is missing type")
+			case "text":
+				if _, ok := partMap["text"].(string); !ok {
+					return invalidRequest(param+".text", "invalid_content", "Text content part must have a string text")
+				}
+			case "image_url":
+				if !modelInfo.Vision {
+					return invalidRequest(param, "unsupported_capability", "Model %s does not support image input", modelInfo.ID)
+				}
+				imageURL, _ := partMap["image_url"].(map[string]interface{})
+				if url, _ := imageURL["url"].(string); url == "" {
+					return invalidRequest(param+".image_url.url", "invalid_content", "Image content part must have a url")
+				}
+			}
+		}
+		return nil
+	default:
+		return invalidRequest(fmt.Sprintf("messages[%d].content", index), "invalid_content", "Message content must be a string or an array of content parts")
+	}
+}
+
+// ValidateClaudeMessages Claude的消息约束:首条非系统消息须为用户消息,开启思考时不支持以助手消息结尾的预填充,
+// temperature上限为1。连续的同角色消息在转换时合并,无需客户端保证严格交替
+func (r *OpenAIChatCompletionRequest) ValidateClaudeMessages(modelInfo common.ModelInfo) *OpenAIError {
+	if r.Temperature != nil && *r.Temperature > 1 {
+		return invalidRequest("temperature", "invalid_temperature", "Invalid temperature %g, expected a value between 0 and 1 for model %s", *r.Temperature, modelInfo.ID)
+	}
+
+	var conversation []int
+	for i, msg := range r.Messages {
+		if msg.Role != "system" && msg.Role != "developer" && !isEmptyContentMessage(msg) {
+			conversation = append(conversation, i)
+		}
+	}
+	if len(conversation) == 0 {
+		return invalidRequest("messages", "invalid_messages", "messages must contain at least one non-system message with content")
+	}
+	if first := conversation[0]; r.Messages[first].Role != "user" {
+		return invalidRequest(fmt.Sprintf("messages[%d].role", first), "invalid_message_order",
+			"The first non-system message must have role 'user' for model %s", modelInfo.ID)
+	}
+
+	last := conversation[len(conversation)-1]
+	if r.Messages[last].Role == "assistant" && !r.ResponseFormat.IsJSON() {
+		if budget, _ := r.ResolveThinkingBudget(modelInfo); budget > 0 {
+			return invalidRequest(fmt.Sprintf("messages[%d].role", last), "invalid_message_order",
+				"The final message cannot have role 'assistant' when thinking is enabled for model %s", modelInfo.ID)
+		}
+	}
+	return nil
+}
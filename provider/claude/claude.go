@@ -0,0 +1,219 @@
+package claude
+
+import (
+	"encoding/json"
+	"fmt"
+	"kilo2api/common"
+	"kilo2api/cycletls"
+	"kilo2api/model"
+	"kilo2api/provider"
+	"net/http"
+)
+
+const endpoint = provider.KiloBaseURL + "/api/claude/v1/messages"
+
+func init() {
+	provider.Register(&Provider{})
+}
+
+// Provider Anthropic Messages协议的上游
+type Provider struct{}
+
+func (p *Provider) Name() string {
+	return "claude"
+}
+
+func (p *Provider) BuildRequest(openAIReq model.OpenAIChatCompletionRequest, modelInfo common.ModelInfo) ([]byte, error) {
+	claudeReq, err := model.ConvertOpenAIToClaudeRequest(openAIReq, modelInfo)
+	if err != nil {
+		return nil, fmt.Errorf("ConvertOpenAIToClaudeRequest err: %v", err)
+	}
+	return json.Marshal(claudeReq)
+}
+
+func (p *Provider) SupportedParams() []string {
+	return model.ClaudeSupportedParams
+}
+
+func (p *Provider) ResponseFormatTool() string {
+	return model.ResponseFormatToolName
+}
+
+func (p *Provider) ValidateMessages(openAIReq model.OpenAIChatCompletionRequest, modelInfo common.ModelInfo) *model.OpenAIError {
+	return openAIReq.ValidateClaudeMessages(modelInfo)
+}
+
+// BuildClaudeRequest 透传Anthropic Messages请求,仅替换模型并强制使用流式
+func (p *Provider) BuildClaudeRequest(claudeReq model.ClaudeMessagesRequest, modelInfo common.ModelInfo) ([]byte, error) {
+	upstreamReq := claudeReq
+	upstreamReq.Model = modelInfo.Model
+	upstreamReq.Stream = true
+	if upstreamReq.MaxTokens <= 1 {
+		upstreamReq.MaxTokens = 8192
+	}
+	if upstreamReq.Temperature == nil {
+		upstreamReq.Temperature = modelInfo.Temperature
+	}
+	if modelInfo.Thinking && upstreamReq.Thinking == nil {
+		upstreamReq.Temperature = nil
+		upstreamReq.Thinking = &model.ClaudeThinking{
+			Type:         "enabled",
+			BudgetTokens: model.ThinkingBudget(modelInfo, upstreamReq.MaxTokens),
+		}
+	}
+	return json.Marshal(upstreamReq)
+}
+
+func (p *Provider) Endpoint() string {
+	return endpoint
+}
+
+func (p *Provider) Headers(token string) map[string]string {
+	return map[string]string{
+		"User-Agent":                  "Ls/JS 0.37.0",
+		"Accept":                      "application/json",
+		"Accept-Encoding":             "gzip,deflate",
+		"Content-Type":                "application/json",
+		"x-stainless-lang":            "js",
+		"x-stainless-package-version": "0.37.0",
+		"x-stainless-os":              "MacOS",
+		"x-stainless-arch":            "arm64",
+		"x-stainless-runtime":         "node",
+		"x-stainless-runtime-version": "v20.18.3",
+		"authorization":               fmt.Sprintf("Bearer %s", token),
+		"anthropic-version":           "2023-06-01",
+		"anthropic-beta":              "prompt-caching-2024-07-31,output-128k-2025-02-19",
+		"x-stainless-retry-count":     "0",
+		"x-stainless-timeout":         "600000",
+	}
+}
+
+func (p *Provider) NewStreamParser() provider.StreamParser {
+	return &streamParser{toolIndexes: make(map[int]int)}
+}
+
+func (p *Provider) ClassifyError(status int, headers map[string]string, body string) *provider.UpstreamError {
+	return provider.ClassifyUpstreamError(status, headers, body)
+}
+
+// streamParser 解析Anthropic流式事件
+type streamParser struct {
+	// toolIndexes 内容块索引到工具调用序号的映射
+	toolIndexes map[int]int
+	usage       provider.Usage
+}
+
+func (s *streamParser) Parse(sseEvent cycletls.SSEEvent) ([]provider.Event, bool, error) {
+	var event map[string]interface{}
+	if err := json.Unmarshal([]byte(sseEvent.Data), &event); err != nil {
+		return nil, false, fmt.Errorf("failed to unmarshal event: %v", err)
+	}
+
+	// 事件类型以data中的type为准,缺失时使用SSE事件名
+	eventType, ok := event["type"].(string)
+	if !ok {
+		eventType = sseEvent.Event
+	}
+
+	switch eventType {
+	case "message_start":
+		message, _ := event["message"].(map[string]interface{})
+		if usage, ok := message["usage"].(map[string]interface{}); ok {
+			s.updateInputUsage(usage)
+			s.usage.CompletionTokens = common.ToInt(usage["output_tokens"])
+			return []provider.Event{s.usageEvent()}, false, nil
+		}
+	case "content_block_start":
+		block, _ := event["content_block"].(map[string]interface{})
+		if block["type"] != "tool_use" {
+			return nil, false, nil
+		}
+		toolIndex := len(s.toolIndexes)
+		s.toolIndexes[common.ToInt(event["index"])] = toolIndex
+		id, _ := block["id"].(string)
+		name, _ := block["name"].(string)
+		return []provider.Event{{
+			Type:       provider.EventToolCallStart,
+			ToolIndex:  toolIndex,
+			ToolCallID: id,
+			ToolName:   name,
+		}}, false, nil
+	case "content_block_delta":
+		delta, _ := event["delta"].(map[string]interface{})
+		switch delta["type"] {
+		case "thinking_delta":
+			thinking, _ := delta["thinking"].(string)
+			return []provider.Event{{Type: provider.EventThinking, Text: thinking}}, false, nil
+		case "signature_delta":
+			signature, _ := delta["signature"].(string)
+			return []provider.Event{{Type: provider.EventThinkingSignature, Text: signature}}, false, nil
+		case "text_delta":
+			text, _ := delta["text"].(string)
+			return []provider.Event{{Type: provider.EventText, Text: text}}, false, nil
+		case "input_json_delta":
+			toolIndex, ok := s.toolIndexes[common.ToInt(event["index"])]
+			if !ok {
+				return nil, false, nil
+			}
+			partial, _ := delta["partial_json"].(string)
+			return []provider.Event{{
+				Type:      provider.EventToolCallArguments,
+				ToolIndex: toolIndex,
+				Text:      partial,
+			}}, false, nil
+		}
+	case "message_delta":
+		var events []provider.Event
+		if usage, ok := event["usage"].(map[string]interface{}); ok {
+			s.updateInputUsage(usage)
+			s.usage.CompletionTokens = common.ToInt(usage["output_tokens"])
+			events = append(events, s.usageEvent())
+		}
+		if delta, ok := event["delta"].(map[string]interface{}); ok {
+			if stopReason, ok := delta["stop_reason"].(string); ok {
+				events = append(events, provider.Event{
+					Type:         provider.EventFinish,
+					FinishReason: finishReason(stopReason),
+				})
+			}
+		}
+		return events, false, nil
+	case "message_stop":
+		return nil, true, nil
+	case "error":
+		return nil, true, provider.ClassifyUpstreamError(http.StatusOK, nil, sseEvent.Data)
+	}
+	return nil, false, nil
+}
+
+// updateInputUsage 更新输入用量,message_delta中未携带输入用量时保留message_start的值
+func (s *streamParser) updateInputUsage(usage map[string]interface{}) {
+	inputTokens := common.ToInt(usage["input_tokens"])
+	cacheCreation := common.ToInt(usage["cache_creation_input_tokens"])
+	cacheRead := common.ToInt(usage["cache_read_input_tokens"])
+	if inputTokens+cacheCreation+cacheRead == 0 {
+		return
+	}
+	s.usage.PromptTokens = inputTokens + cacheCreation + cacheRead
+	s.usage.CachedTokens = cacheRead
+	s.usage.CacheCreationTokens = cacheCreation
+}
+
+func (s *streamParser) usageEvent() provider.Event {
+	usage := s.usage
+	return provider.Event{Type: provider.EventUsage, Usage: &usage}
+}
+
+// finishReason 将Anthropic的stop_reason转换为OpenAI的finish_reason
+func finishReason(stopReason string) string {
+	switch stopReason {
+	case "max_tokens", "model_context_window_exceeded":
+		return provider.FinishReasonLength
+	case "tool_use":
+		return provider.FinishReasonToolCalls
+	case "refusal":
+		return provider.FinishReasonContentFilter
+	}
+	// end_turn、stop_sequence、pause_turn
+	return provider.FinishReasonStop
+}
@@ -0,0 +1,137 @@
+package claude
+
+import (
+	"kilo2api/cycletls"
+	"kilo2api/provider"
+	"testing"
+)
+
+// parseStream 依次解析上游事件,返回全部归一化事件以及流是否正常结束
+func parseStream(t *testing.T, data []string) ([]provider.Event, bool) {
+	t.Helper()
+	parser := (&Provider{}).NewStreamParser()
+	var events []provider.Event
+	for _, d := range data {
+		parsed, done, err := parser.Parse(cycletls.SSEEvent{Data: d})
+		if err != nil {
+			t.Fatalf("Parse(%s): %v", d, err)
+		}
+		events = append(events, parsed...)
+		if done {
+			return events, true
+		}
+	}
+	return events, false
+}
+
+func finishReasons(events []provider.Event) []string {
+	var reasons []string
+	for _, event := range events {
+		if event.Type == provider.EventFinish {
+			reasons = append(reasons, event.FinishReason)
+		}
+	}
+	return reasons
+}
+
+func TestStreamParserFinishReason(t *testing.T) {
+	tests := []struct {
+		name       string
+		stopReason string
+		want       string
+	}{
+		{"end_turn", "end_turn", provider.FinishReasonStop},
+		{"max_tokens", "max_tokens", provider.FinishReasonLength},
+		{"context window exceeded", "model_context_window_exceeded", provider.FinishReasonLength},
+		{"tool_use", "tool_use", provider.FinishReasonToolCalls},
+		{"stop_sequence", "stop_sequence", provider.FinishReasonStop},
+		{"refusal", "refusal", provider.FinishReasonContentFilter},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			events, done := parseStream(t, []string{
+				`{"type":"message_start","message":{"id":"msg_1","usage":{"input_tokens":10,"output_tokens":1}}}`,
+				`{"type":"content_block_delta","index":0,"delta":{"type":"text_delta","text":"hi"}}`,
+				`{"type":"message_delta","delta":{"stop_reason":"` + tt.stopReason + `"},"usage":{"output_tokens":5}}`,
+				`{"type":"message_stop"}`,
+			})
+			if !done {
+				t.Fatal("stream not done after message_stop")
+			}
+			if reasons := finishReasons(events); len(reasons) != 1 || reasons[0] != tt.want {
+				t.Fatalf("finish reasons = %v, want [%s]", reasons, tt.want)
+			}
+		})
+	}
+}
+
+func TestStreamParserToolUse(t *testing.T) {
+	events, done := parseStream(t, []string{
+		`{"type":"message_start","message":{"usage":{"input_tokens":3,"cache_read_input_tokens":7,"output_tokens":1}}}`,
+		`{"type":"content_block_start","index":0,"content_block":{"type":"text","text":""}}`,
+		`{"type":"content_block_start","index":1,"content_block":{"type":"tool_use","id":"toolu_1","name":"get_weather","input":{}}}`,
+		`{"type":"content_block_delta","index":1,"delta":{"type":"input_json_delta","partial_json":"{\"city\":"}}`,
+		`{"type":"content_block_delta","index":1,"delta":{"type":"input_json_delta","partial_json":"\"Paris\"}"}}`,
+		`{"type":"message_delta","delta":{"stop_reason":"tool_use"},"usage":{"output_tokens":12}}`,
+		`{"type":"message_stop"}`,
+	})
+	if !done {
+		t.Fatal("stream not done after message_stop")
+	}
+
+	var arguments string
+	var usage *provider.Usage
+	for _, event := range events {
+		switch event.Type {
+		case provider.EventToolCallStart:
+			if event.ToolIndex != 0 || event.ToolCallID != "toolu_1" || event.ToolName != "get_weather" {
+				t.Fatalf("unexpected tool call start %+v", event)
+			}
+		case provider.EventToolCallArguments:
+			if event.ToolIndex != 0 {
+				t.Fatalf("arguments for tool index %d", event.ToolIndex)
+			}
+			arguments += event.Text
+		case provider.EventUsage:
+			usage = event.Usage
+		}
+	}
+	if arguments != `{"city":"Paris"}` {
+		t.Fatalf("arguments = %q", arguments)
+	}
+	if reasons := finishReasons(events); len(reasons) != 1 || reasons[0] != provider.FinishReasonToolCalls {
+		t.Fatalf("finish reasons = %v", reasons)
+	}
+	// message_delta未携带输入用量时保留message_start的值
+	if usage == nil || usage.PromptTokens != 10 || usage.CachedTokens != 7 || usage.CompletionTokens != 12 {
+		t.Fatalf("usage = %+v", usage)
+	}
+}
+
+func TestStreamParserTruncated(t *testing.T) {
+	events, done := parseStream(t, []string{
+		`{"type":"message_start","message":{"usage":{"input_tokens":10,"output_tokens":1}}}`,
+		`{"type":"content_block_delta","index":0,"delta":{"type":"text_delta","text":"partial"}}`,
+	})
+	if done {
+		t.Fatal("truncated stream reported done")
+	}
+	if reasons := finishReasons(events); len(reasons) != 0 {
+		t.Fatalf("finish reasons = %v, want none", reasons)
+	}
+}
+
+func TestStreamParserError(t *testing.T) {
+	parser := (&Provider{}).NewStreamParser()
+	_, done, err := parser.Parse(cycletls.SSEEvent{
+		Event: "error",
+		Data:  `{"type":"error","error":{"type":"overloaded_error","message":"Overloaded"}}`,
+	})
+	if !done {
+		t.Fatal("error event did not end the stream")
+	}
+	upstreamErr, ok := err.(*provider.UpstreamError)
+	if !ok || upstreamErr.Kind != provider.ErrorOverloaded {
+		t.Fatalf("err = %v, want overloaded upstream error", err)
+	}
+}
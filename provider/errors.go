@@ -0,0 +1,240 @@
+package provider
+
+import (
+	"encoding/json"
+	"fmt"
+	"kilo2api/common"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ErrorKind 上游错误分类
+type ErrorKind int
+
+const (
+	// ErrorUnknown 无法识别的错误
+	ErrorUnknown ErrorKind = iota
+	// ErrorAuth 登录凭证失效
+	ErrorAuth
+	// ErrorForbidden 请求被拒绝
+	ErrorForbidden
+	// ErrorCloudflare 被Cloudflare拦截或要求人机验证
+	ErrorCloudflare
+	// ErrorRateLimit 并发/频率受限
+	ErrorRateLimit
+	// ErrorQuota 账号额度耗尽
+	ErrorQuota
+	// ErrorOverloaded 上游过载
+	ErrorOverloaded
+	// ErrorInvalidRequest 请求参数错误
+	ErrorInvalidRequest
+	// ErrorContextLength 输入超出上下文窗口
+	ErrorContextLength
+	// ErrorContentFilter 触发内容审核
+	ErrorContentFilter
+	// ErrorServer 上游服务异常
+	ErrorServer
+)
+
+var errorKindNames = map[ErrorKind]string{
+	ErrorUnknown:        "unknown",
+	ErrorAuth:           "auth",
+	ErrorForbidden:      "forbidden",
+	ErrorCloudflare:     "cloudflare",
+	ErrorRateLimit:      "rate_limit",
+	ErrorQuota:          "quota",
+	ErrorOverloaded:     "overloaded",
+	ErrorInvalidRequest: "invalid_request",
+	ErrorContextLength:  "context_length",
+	ErrorContentFilter:  "content_filter",
+	ErrorServer:         "server",
+}
+
+func (k ErrorKind) String() string {
+	return errorKindNames[k]
+}
+
+// UpstreamError 分类后的上游错误
+type UpstreamError struct {
+	Kind ErrorKind
+	// Status 上游HTTP状态码,流中返回的错误为200
+	Status int
+	// Type 上游错误类型,如rate_limit_error;kilocode网关为error字段的字符串
+	Type string
+	// Code 上游错误码,如context_length_exceeded
+	Code    string
+	Message string
+	// RetryAfter 上游要求的重试等待时间,未提供时为0
+	RetryAfter time.Duration
+}
+
+func (e *UpstreamError) Error() string {
+	return fmt.Sprintf("upstream %s error (status %d): %s", e.Kind, e.Status, e.Message)
+}
+
+// errorTypeKinds 按上游返回的错误类型/错误码分类,键为小写。
+// 包括Anthropic的error.type、OpenAI/OpenRouter的error.code与kilocode网关的error字符串
+var errorTypeKinds = map[string]ErrorKind{
+	"authentication_error":         ErrorAuth,
+	"invalid_api_key":              ErrorAuth,
+	"invalid token":                ErrorAuth,
+	"permission_error":             ErrorForbidden,
+	"rate_limit_error":             ErrorRateLimit,
+	"rate_limit_exceeded":          ErrorRateLimit,
+	"too many concurrent requests": ErrorRateLimit,
+	"insufficient_quota":           ErrorQuota,
+	"billing_error":                ErrorQuota,
+	"usage limit exceeded":         ErrorQuota,
+	"overloaded_error":             ErrorOverloaded,
+	"context_length_exceeded":      ErrorContextLength,
+	"content_filter":               ErrorContentFilter,
+	"content_policy_violation":     ErrorContentFilter,
+	"invalid_request_error":        ErrorInvalidRequest,
+	"not_found_error":              ErrorInvalidRequest,
+	"request_too_large":            ErrorInvalidRequest,
+	"model_not_found":              ErrorInvalidRequest,
+	"api_error":                    ErrorServer,
+	"server_error":                 ErrorServer,
+	"service unavailable":          ErrorServer,
+}
+
+// statusKinds 按HTTP状态码分类
+var statusKinds = map[int]ErrorKind{
+	http.StatusBadRequest:            ErrorInvalidRequest,
+	http.StatusUnauthorized:          ErrorAuth,
+	http.StatusPaymentRequired:       ErrorQuota,
+	http.StatusForbidden:             ErrorForbidden,
+	http.StatusNotFound:              ErrorInvalidRequest,
+	http.StatusRequestEntityTooLarge: ErrorInvalidRequest,
+	http.StatusUnprocessableEntity:   ErrorInvalidRequest,
+	http.StatusTooManyRequests:       ErrorRateLimit,
+	529:                              ErrorOverloaded,
+}
+
+var (
+	// contextLengthKeywords 上下文超限通常以invalid_request_error/400返回,按错误信息细分
+	contextLengthKeywords = []string{"context_length_exceeded", "prompt is too long", "maximum context length", "context window", "context length", "too many tokens", "input is too long"}
+	// contentFilterKeywords 内容审核可能以400/403返回,按错误信息细分,避免403审核被当作凭证被拒
+	contentFilterKeywords = []string{"content_filter", "content filtering", "content policy", "content_policy", "moderation", "flagged"}
+	// fallbackKeywords 没有可用的状态码与错误类型时(如流中的纯文本错误)才按关键字匹配
+	fallbackKeywords = []struct {
+		kind     ErrorKind
+		keywords []string
+	}{
+		{ErrorAuth, []string{"invalid token", "invalid api key", "unauthorized"}},
+		{ErrorQuota, []string{"usage limit", "insufficient credits", "insufficient_quota"}},
+		{ErrorContextLength, contextLengthKeywords},
+		{ErrorContentFilter, contentFilterKeywords},
+		{ErrorRateLimit, []string{"rate limit", "rate_limit", "too many requests", "too many concurrent requests"}},
+		{ErrorOverloaded, []string{"overloaded"}},
+		{ErrorServer, []string{"service unavailable", "internal server error"}},
+	}
+)
+
+// ClassifyUpstreamError 根据状态码、响应头与响应体对上游错误分类。
+// 响应体支持kilocode网关({"error":"...","message":"..."})、Anthropic({"type":"error","error":{...}})
+// 与OpenAI/OpenRouter({"error":{"message","code"}})格式,以及Cloudflare拦截页面。
+// 优先按错误码/错误类型分类,其次按HTTP状态码,两者都没有时才按错误信息中的关键字匹配
+func ClassifyUpstreamError(status int, headers map[string]string, body string) *UpstreamError {
+	upstreamErr := &UpstreamError{Status: status, Message: strings.TrimSpace(body)}
+	upstreamErr.RetryAfter = parseRetryAfter(headers)
+	if common.IsCloudflareChallenge(body) || common.IsCloudflareBlock(body) {
+		upstreamErr.Kind = ErrorCloudflare
+		upstreamErr.Message = "blocked by Cloudflare"
+		return upstreamErr
+	}
+	parseErrorBody(upstreamErr, body)
+
+	message := strings.ToLower(upstreamErr.Message)
+	if kind, ok := errorTypeKinds[strings.ToLower(upstreamErr.Code)]; ok {
+		upstreamErr.Kind = kind
+	} else if kind, ok := errorTypeKinds[strings.ToLower(upstreamErr.Type)]; ok {
+		upstreamErr.Kind = kind
+	} else if kind, ok := statusKinds[upstreamErr.Status]; ok {
+		upstreamErr.Kind = kind
+	} else if upstreamErr.Status >= http.StatusInternalServerError {
+		upstreamErr.Kind = ErrorServer
+	} else {
+		for _, fallback := range fallbackKeywords {
+			if containsAny(message, fallback.keywords) {
+				upstreamErr.Kind = fallback.kind
+				break
+			}
+		}
+	}
+
+	// 参数错误与拒绝访问按错误信息细分为上下文超限或内容审核
+	if upstreamErr.Kind == ErrorInvalidRequest || upstreamErr.Kind == ErrorForbidden {
+		switch {
+		case containsAny(message, contextLengthKeywords):
+			upstreamErr.Kind = ErrorContextLength
+		case containsAny(message, contentFilterKeywords):
+			upstreamErr.Kind = ErrorContentFilter
+		}
+	}
+	return upstreamErr
+}
+
+func containsAny(text string, keywords []string) bool {
+	for _, keyword := range keywords {
+		if strings.Contains(text, keyword) {
+			return true
+		}
+	}
+	return false
+}
+
+// parseErrorBody 解析JSON错误体中的错误类型、错误码与信息,OpenRouter的code为HTTP状态码
+func parseErrorBody(upstreamErr *UpstreamError, body string) {
+	var errorBody map[string]interface{}
+	if err := json.Unmarshal([]byte(body), &errorBody); err != nil {
+		return
+	}
+	switch value := errorBody["error"].(type) {
+	case string:
+		upstreamErr.Type = value
+		if message, ok := errorBody["message"].(string); ok {
+			upstreamErr.Message = value + ": " + message
+		} else {
+			upstreamErr.Message = value
+		}
+	case map[string]interface{}:
+		upstreamErr.Type, _ = value["type"].(string)
+		if message, ok := value["message"].(string); ok {
+			upstreamErr.Message = message
+		}
+		switch code := value["code"].(type) {
+		case float64:
+			if upstreamErr.Status == 0 || upstreamErr.Status == http.StatusOK {
+				upstreamErr.Status = int(code)
+			}
+		case string:
+			upstreamErr.Code = code
+		}
+	}
+}
+
+// parseRetryAfter 解析retry-after-ms与Retry-After(秒数或HTTP日期)响应头
+func parseRetryAfter(headers map[string]string) time.Duration {
+	var retryAfter, retryAfterMs string
+	for name, value := range headers {
+		switch strings.ToLower(name) {
+		case "retry-after":
+			retryAfter = strings.TrimSpace(value)
+		case "retry-after-ms":
+			retryAfterMs = strings.TrimSpace(value)
+		}
+	}
+	if ms, err := strconv.ParseFloat(retryAfterMs, 64); err == nil && ms > 0 {
+		return time.Duration(ms * float64(time.Millisecond))
+	}
+	if seconds, err := strconv.Atoi(retryAfter); err == nil && seconds > 0 {
+		return time.Duration(seconds) * time.Second
+	}
+	if date, err := http.ParseTime(retryAfter); err == nil && time.Until(date) > 0 {
+		return time.Until(date)
+	}
+	return 0
+}
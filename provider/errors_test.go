@@ -0,0 +1,252 @@
+package provider
+
+import (
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestClassifyUpstreamError(t *testing.T) {
+	tests := []struct {
+		name       string
+		status     int
+		headers    map[string]string
+		body       string
+		wantKind   ErrorKind
+		wantStatus int
+	}{
+		// Anthropic
+		{
+			name:     "anthropic prompt too long",
+			status:   http.StatusBadRequest,
+			body:     `{"type":"error","error":{"type":"invalid_request_error","message":"prompt is too long: 215304 tokens > 200000 maximum"}}`,
+			wantKind: ErrorContextLength,
+		},
+		{
+			name:     "anthropic invalid request",
+			status:   http.StatusBadRequest,
+			body:     `{"type":"error","error":{"type":"invalid_request_error","message":"messages: roles must alternate between \"user\" and \"assistant\", but found multiple \"user\" roles in a row"}}`,
+			wantKind: ErrorInvalidRequest,
+		},
+		{
+			name:     "anthropic invalid request mentioning safety is not a content filter",
+			status:   http.StatusBadRequest,
+			body:     `{"type":"error","error":{"type":"invalid_request_error","message":"metadata.safety_identifier: Extra inputs are not permitted"}}`,
+			wantKind: ErrorInvalidRequest,
+		},
+		{
+			name:     "anthropic output blocked",
+			status:   http.StatusBadRequest,
+			body:     `{"type":"error","error":{"type":"invalid_request_error","message":"Output blocked by content filtering policy"}}`,
+			wantKind: ErrorContentFilter,
+		},
+		{
+			name:     "anthropic authentication",
+			status:   http.StatusUnauthorized,
+			body:     `{"type":"error","error":{"type":"authentication_error","message":"invalid x-api-key"}}`,
+			wantKind: ErrorAuth,
+		},
+		{
+			name:     "anthropic permission",
+			status:   http.StatusForbidden,
+			body:     `{"type":"error","error":{"type":"permission_error","message":"Your API key does not have permission to use the specified resource."}}`,
+			wantKind: ErrorForbidden,
+		},
+		{
+			name:     "anthropic not found",
+			status:   http.StatusNotFound,
+			body:     `{"type":"error","error":{"type":"not_found_error","message":"model: claude-3-opus-latest"}}`,
+			wantKind: ErrorInvalidRequest,
+		},
+		{
+			name:     "anthropic request too large",
+			status:   http.StatusRequestEntityTooLarge,
+			body:     `{"type":"error","error":{"type":"request_too_large","message":"Request exceeds the maximum allowed number of bytes."}}`,
+			wantKind: ErrorInvalidRequest,
+		},
+		{
+			name:     "anthropic rate limit mentioning tokens",
+			status:   http.StatusTooManyRequests,
+			body:     `{"type":"error","error":{"type":"rate_limit_error","message":"This request would exceed the rate limit for your organization of 40,000 input tokens per minute. For details, refer to: https://docs.anthropic.com/en/api/rate-limits."}}`,
+			wantKind: ErrorRateLimit,
+		},
+		{
+			name:     "anthropic api error",
+			status:   http.StatusInternalServerError,
+			body:     `{"type":"error","error":{"type":"api_error","message":"Internal server error"}}`,
+			wantKind: ErrorServer,
+		},
+		{
+			name:     "anthropic overloaded",
+			status:   529,
+			body:     `{"type":"error","error":{"type":"overloaded_error","message":"Overloaded"}}`,
+			wantKind: ErrorOverloaded,
+		},
+		{
+			name:       "anthropic overloaded in stream",
+			status:     http.StatusOK,
+			body:       `{"type":"error","error":{"details":null,"type":"overloaded_error","message":"Overloaded"}}`,
+			wantKind:   ErrorOverloaded,
+			wantStatus: http.StatusOK,
+		},
+
+		// OpenRouter
+		{
+			name:     "openrouter insufficient credits",
+			status:   http.StatusPaymentRequired,
+			body:     `{"error":{"message":"Insufficient credits. Add more using https://openrouter.ai/settings/credits","code":402}}`,
+			wantKind: ErrorQuota,
+		},
+		{
+			name:     "openrouter moderation",
+			status:   http.StatusForbidden,
+			body:     `{"error":{"code":403,"message":"anthropic/claude-3.7-sonnet requires moderation on OpenRouter. Your input was flagged for \"harassment\". No credits were charged.","metadata":{"reasons":["harassment"],"flagged_input":"...","provider_name":"OpenAI","model_slug":"anthropic/claude-3.7-sonnet"}}}`,
+			wantKind: ErrorContentFilter,
+		},
+		{
+			name:     "openrouter context length",
+			status:   http.StatusBadRequest,
+			body:     `{"error":{"message":"This endpoint's maximum context length is 200000 tokens. However, you requested about 251203 tokens (251203 of text input). Please reduce the length of either one, or use the \"middle-out\" transform to compress your prompt automatically.","code":400,"metadata":{"provider_name":null}}}`,
+			wantKind: ErrorContextLength,
+		},
+		{
+			name:     "openrouter too many tokens is not a rate limit",
+			status:   http.StatusBadRequest,
+			body:     `{"error":{"message":"Too many tokens in request: 1050000 > 1048576","code":400}}`,
+			wantKind: ErrorContextLength,
+		},
+		{
+			name:       "openrouter too many tokens in stream",
+			status:     http.StatusOK,
+			body:       `{"error":{"message":"Too many tokens in request: 1050000 > 1048576"}}`,
+			wantKind:   ErrorContextLength,
+			wantStatus: http.StatusOK,
+		},
+		{
+			name:     "openrouter rate limit",
+			status:   http.StatusTooManyRequests,
+			body:     `{"error":{"message":"Rate limit exceeded: free-models-per-day","code":429,"metadata":{"headers":{"X-RateLimit-Limit":"50","X-RateLimit-Remaining":"0"}}}}`,
+			wantKind: ErrorRateLimit,
+		},
+		{
+			name:     "openrouter no auth",
+			status:   http.StatusUnauthorized,
+			body:     `{"error":{"message":"No auth credentials found","code":401}}`,
+			wantKind: ErrorAuth,
+		},
+		{
+			name:       "openrouter provider error in stream",
+			status:     http.StatusOK,
+			body:       `{"id":"gen-1","object":"chat.completion.chunk","error":{"code":502,"message":"Provider returned error","metadata":{"raw":"upstream unauthorized","provider_name":"Anthropic"}}}`,
+			wantKind:   ErrorServer,
+			wantStatus: http.StatusBadGateway,
+		},
+		{
+			name:       "openai style code",
+			status:     http.StatusOK,
+			body:       `{"error":{"message":"You exceeded your current quota","type":"insufficient_quota","code":"insufficient_quota"}}`,
+			wantKind:   ErrorQuota,
+			wantStatus: http.StatusOK,
+		},
+
+		// kilocode网关
+		{
+			name:     "kilo concurrent limit",
+			status:   http.StatusTooManyRequests,
+			body:     `{"error":"Too many concurrent requests","message":"You have reached your maximum concurrent request limit. Please try again later."}`,
+			wantKind: ErrorRateLimit,
+		},
+		{
+			name:     "kilo usage limit",
+			status:   http.StatusPaymentRequired,
+			body:     `{"error":"Usage limit exceeded","message":"You have reached your Kilo Code usage limit. Please add credits."}`,
+			wantKind: ErrorQuota,
+		},
+		{
+			name:     "kilo invalid token",
+			status:   http.StatusUnauthorized,
+			body:     `{"error":"Invalid token"}`,
+			wantKind: ErrorAuth,
+		},
+		{
+			name:     "kilo service unavailable",
+			status:   http.StatusServiceUnavailable,
+			body:     `{"error":"Service Unavailable","message":"The service is temporarily unavailable. Please try again later."}`,
+			wantKind: ErrorServer,
+		},
+		{
+			name:     "plain text status error",
+			status:   http.StatusServiceUnavailable,
+			body:     `HTTP error status: 503`,
+			wantKind: ErrorServer,
+		},
+		{
+			name:     "cloudflare block",
+			status:   http.StatusForbidden,
+			body:     `<!DOCTYPE html><html><head><title>Attention Required! | Cloudflare</title></head><body><h1 data-translate="block_headline">Sorry, you have been blocked</h1></body></html>`,
+			wantKind: ErrorCloudflare,
+		},
+		{
+			name:     "unknown",
+			status:   http.StatusOK,
+			body:     `something went wrong`,
+			wantKind: ErrorUnknown,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			upstreamErr := ClassifyUpstreamError(tt.status, tt.headers, tt.body)
+			if upstreamErr.Kind != tt.wantKind {
+				t.Fatalf("kind = %s, want %s (message %q)", upstreamErr.Kind, tt.wantKind, upstreamErr.Message)
+			}
+			wantStatus := tt.wantStatus
+			if wantStatus == 0 {
+				wantStatus = tt.status
+			}
+			if upstreamErr.Status != wantStatus {
+				t.Fatalf("status = %d, want %d", upstreamErr.Status, wantStatus)
+			}
+		})
+	}
+}
+
+func TestClassifyUpstreamErrorMessage(t *testing.T) {
+	upstreamErr := ClassifyUpstreamError(http.StatusTooManyRequests, nil,
+		`{"error":"Too many concurrent requests","message":"Please try again later."}`)
+	if want := "Too many concurrent requests: Please try again later."; upstreamErr.Message != want {
+		t.Fatalf("message = %q, want %q", upstreamErr.Message, want)
+	}
+
+	upstreamErr = ClassifyUpstreamError(http.StatusBadRequest, nil,
+		`{"error":{"message":"Invalid model","type":"invalid_request_error","code":"model_not_found"}}`)
+	if upstreamErr.Type != "invalid_request_error" || upstreamErr.Code != "model_not_found" || upstreamErr.Message != "Invalid model" {
+		t.Fatalf("unexpected parse result %+v", upstreamErr)
+	}
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	tests := []struct {
+		name    string
+		headers map[string]string
+		want    time.Duration
+	}{
+		{"seconds", map[string]string{"Retry-After": "12"}, 12 * time.Second},
+		{"milliseconds take precedence", map[string]string{"retry-after-ms": "1500", "retry-after": "12"}, 1500 * time.Millisecond},
+		{"invalid", map[string]string{"Retry-After": "soon"}, 0},
+		{"past date", map[string]string{"Retry-After": "Wed, 21 Oct 2015 07:28:00 GMT"}, 0},
+		{"missing", nil, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := parseRetryAfter(tt.headers); got != tt.want {
+				t.Fatalf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	future := time.Now().Add(30 * time.Second).UTC().Format(http.TimeFormat)
+	if got := parseRetryAfter(map[string]string{"Retry-After": future}); got <= 0 || got > 30*time.Second {
+		t.Fatalf("http date: got %v", got)
+	}
+}
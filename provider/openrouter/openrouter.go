@@ -0,0 +1,183 @@
+package openrouter
+
+import (
+	"encoding/json"
+	"fmt"
+	"kilo2api/common"
+	"kilo2api/cycletls"
+	"kilo2api/model"
+	"kilo2api/provider"
+	"net/http"
+	"strings"
+)
+
+const endpoint = provider.KiloBaseURL + "/api/openrouter/chat/completions"
+
+func init() {
+	provider.Register(&Provider{})
+}
+
+// Provider OpenAI Chat Completions协议的OpenRouter上游
+type Provider struct{}
+
+func (p *Provider) Name() string {
+	return "openrouter"
+}
+
+func (p *Provider) BuildRequest(openAIReq model.OpenAIChatCompletionRequest, modelInfo common.ModelInfo) ([]byte, error) {
+	geminiReq, err := model.ConvertOpenAIToGeminiRequest(openAIReq, modelInfo)
+	if err != nil {
+		return nil, fmt.Errorf("ConvertOpenAIToGeminiRequest err: %v", err)
+	}
+	return json.Marshal(geminiReq)
+}
+
+func (p *Provider) Endpoint() string {
+	return endpoint
+}
+
+func (p *Provider) Headers(token string) map[string]string {
+	return map[string]string{
+		"User-Agent":                  "La/JS 4.78.1",
+		"Accept":                      "application/json",
+		"Accept-Encoding":             "gzip,deflate",
+		"Content-Type":                "application/json",
+		"x-stainless-lang":            "js",
+		"x-stainless-package-version": "4.78.1",
+		"x-stainless-os":              "MacOS",
+		"x-stainless-arch":            "arm64",
+		"x-stainless-runtime":         "node",
+		"x-stainless-runtime-version": "v20.18.3",
+		"authorization":               fmt.Sprintf("Bearer %s", token),
+		"http-referer":                "https://kilocode.ai",
+		"x-title":                     "Kilo Code",
+		"x-stainless-retry-count":     "0",
+	}
+}
+
+func (p *Provider) NewStreamParser() provider.StreamParser {
+	return &streamParser{toolIndexes: make(map[int]int)}
+}
+
+func (p *Provider) ClassifyError(status int, headers map[string]string, body string) *provider.UpstreamError {
+	return provider.ClassifyUpstreamError(status, headers, body)
+}
+
+func (p *Provider) SupportedParams() []string {
+	return model.GeminiSupportedParams
+}
+
+// streamParser 解析OpenAI格式的流式数据块
+type streamParser struct {
+	// toolIndexes 上游tool_calls索引到工具调用序号的映射
+	toolIndexes map[int]int
+	finished    bool
+}
+
+func (s *streamParser) Parse(sseEvent cycletls.SSEEvent) ([]provider.Event, bool, error) {
+	data := strings.TrimSpace(sseEvent.Data)
+
+	// 处理[DONE]标记
+	if data == "[DONE]" {
+		if s.finished {
+			return nil, true, nil
+		}
+		s.finished = true
+		return []provider.Event{{Type: provider.EventFinish, FinishReason: provider.FinishReasonStop}}, true, nil
+	}
+
+	var chunk map[string]interface{}
+	if err := json.Unmarshal([]byte(data), &chunk); err != nil {
+		return nil, false, fmt.Errorf("failed to unmarshal event: %v", err)
+	}
+
+	if _, ok := chunk["error"]; ok {
+		return nil, true, provider.ClassifyUpstreamError(http.StatusOK, nil, data)
+	}
+
+	var events []provider.Event
+	choices, _ := chunk["choices"].([]interface{})
+	if len(choices) > 0 {
+		choice, _ := choices[0].(map[string]interface{})
+		delta, _ := choice["delta"].(map[string]interface{})
+
+		// 思考模型在reasoning字段返回思考内容,部分模型使用reasoning_content
+		reasoning, _ := delta["reasoning"].(string)
+		if reasoning == "" {
+			reasoning, _ = delta["reasoning_content"].(string)
+		}
+		if reasoning != "" {
+			events = append(events, provider.Event{Type: provider.EventThinking, Text: reasoning})
+		}
+
+		if content, ok := delta["content"].(string); ok && content != "" {
+			events = append(events, provider.Event{Type: provider.EventText, Text: content})
+		}
+
+		// 处理工具调用增量
+		rawToolCalls, _ := delta["tool_calls"].([]interface{})
+		for _, rawToolCall := range rawToolCalls {
+			toolCall, ok := rawToolCall.(map[string]interface{})
+			if !ok {
+				continue
+			}
+			upstreamIndex := common.ToInt(toolCall["index"])
+			function, _ := toolCall["function"].(map[string]interface{})
+			toolIndex, exists := s.toolIndexes[upstreamIndex]
+			if !exists {
+				toolIndex = len(s.toolIndexes)
+				s.toolIndexes[upstreamIndex] = toolIndex
+				id, _ := toolCall["id"].(string)
+				name, _ := function["name"].(string)
+				events = append(events, provider.Event{
+					Type:       provider.EventToolCallStart,
+					ToolIndex:  toolIndex,
+					ToolCallID: id,
+					ToolName:   name,
+				})
+			}
+			if arguments, _ := function["arguments"].(string); arguments != "" {
+				events = append(events, provider.Event{
+					Type:      provider.EventToolCallArguments,
+					ToolIndex: toolIndex,
+					Text:      arguments,
+				})
+			}
+		}
+
+		if finishReason, ok := choice["finish_reason"].(string); ok && finishReason != "" && !s.finished {
+			s.finished = true
+			events = append(events, provider.Event{Type: provider.EventFinish, FinishReason: normalizeFinishReason(finishReason)})
+		}
+	} else if _, hasUsage := chunk["usage"]; !hasUsage {
+		return nil, false, fmt.Errorf("invalid openrouter response format: choices not found or empty")
+	}
+
+	if usage, ok := chunk["usage"].(map[string]interface{}); ok {
+		promptDetails, _ := usage["prompt_tokens_details"].(map[string]interface{})
+		completionDetails, _ := usage["completion_tokens_details"].(map[string]interface{})
+		events = append(events, provider.Event{
+			Type: provider.EventUsage,
+			Usage: &provider.Usage{
+				PromptTokens:     common.ToInt(usage["prompt_tokens"]),
+				CompletionTokens: common.ToInt(usage["completion_tokens"]),
+				CachedTokens:     common.ToInt(promptDetails["cached_tokens"]),
+				ReasoningTokens:  common.ToInt(completionDetails["reasoning_tokens"]),
+			},
+		})
+	}
+	return events, false, nil
+}
+
+// normalizeFinishReason 将上游finish_reason统一为OpenAI取值,兼容部分模型透传的原生结束原因
+func normalizeFinishReason(finishReason string) string {
+	switch strings.ToLower(finishReason) {
+	case "length", "max_tokens":
+		return provider.FinishReasonLength
+	case "tool_calls", "function_call", "tool_use":
+		return provider.FinishReasonToolCalls
+	case "content_filter", "safety", "recitation", "blocklist", "prohibited_content", "spii":
+		return provider.FinishReasonContentFilter
+	}
+	return provider.FinishReasonStop
+}
@@ -0,0 +1,134 @@
+package openrouter
+
+import (
+	"kilo2api/cycletls"
+	"kilo2api/provider"
+	"testing"
+)
+
+// parseStream 依次解析上游数据块,返回全部归一化事件以及流是否正常结束
+func parseStream(t *testing.T, data []string) ([]provider.Event, bool) {
+	t.Helper()
+	parser := (&Provider{}).NewStreamParser()
+	var events []provider.Event
+	for _, d := range data {
+		parsed, done, err := parser.Parse(cycletls.SSEEvent{Data: d})
+		if err != nil {
+			t.Fatalf("Parse(%s): %v", d, err)
+		}
+		events = append(events, parsed...)
+		if done {
+			return events, true
+		}
+	}
+	return events, false
+}
+
+func finishReasons(events []provider.Event) []string {
+	var reasons []string
+	for _, event := range events {
+		if event.Type == provider.EventFinish {
+			reasons = append(reasons, event.FinishReason)
+		}
+	}
+	return reasons
+}
+
+func TestStreamParserFinishReason(t *testing.T) {
+	tests := []struct {
+		name         string
+		finishReason string
+		want         string
+	}{
+		{"stop", "stop", provider.FinishReasonStop},
+		{"length", "length", provider.FinishReasonLength},
+		{"native max_tokens", "max_tokens", provider.FinishReasonLength},
+		{"tool_calls", "tool_calls", provider.FinishReasonToolCalls},
+		{"native tool_use", "tool_use", provider.FinishReasonToolCalls},
+		{"native stop_sequence", "stop_sequence", provider.FinishReasonStop},
+		{"gemini safety", "SAFETY", provider.FinishReasonContentFilter},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			events, done := parseStream(t, []string{
+				`{"id":"gen-1","choices":[{"index":0,"delta":{"role":"assistant","content":"hi"},"finish_reason":null}]}`,
+				`{"id":"gen-1","choices":[{"index":0,"delta":{"content":""},"finish_reason":"` + tt.finishReason + `"}]}`,
+				`[DONE]`,
+			})
+			if !done {
+				t.Fatal("stream not done after [DONE]")
+			}
+			// [DONE]不再重复产生结束事件
+			if reasons := finishReasons(events); len(reasons) != 1 || reasons[0] != tt.want {
+				t.Fatalf("finish reasons = %v, want [%s]", reasons, tt.want)
+			}
+		})
+	}
+}
+
+func TestStreamParserToolCalls(t *testing.T) {
+	events, done := parseStream(t, []string{
+		`{"choices":[{"index":0,"delta":{"role":"assistant","tool_calls":[{"index":0,"id":"call_1","type":"function","function":{"name":"get_weather","arguments":""}}]}}]}`,
+		`{"choices":[{"index":0,"delta":{"tool_calls":[{"index":0,"function":{"arguments":"{\"city\":"}}]}}]}`,
+		`{"choices":[{"index":0,"delta":{"tool_calls":[{"index":1,"id":"call_2","function":{"name":"get_time","arguments":"{}"}}]}}]}`,
+		`{"choices":[{"index":0,"delta":{"tool_calls":[{"index":0,"function":{"arguments":"\"Paris\"}"}}]}}]}`,
+		`{"choices":[{"index":0,"delta":{},"finish_reason":"tool_calls"}]}`,
+		`{"choices":[],"usage":{"prompt_tokens":20,"completion_tokens":8,"prompt_tokens_details":{"cached_tokens":4},"completion_tokens_details":{"reasoning_tokens":2}}}`,
+		`[DONE]`,
+	})
+	if !done {
+		t.Fatal("stream not done after [DONE]")
+	}
+
+	names := make(map[int]string)
+	arguments := make(map[int]string)
+	var usage *provider.Usage
+	for _, event := range events {
+		switch event.Type {
+		case provider.EventToolCallStart:
+			names[event.ToolIndex] = event.ToolCallID + ":" + event.ToolName
+		case provider.EventToolCallArguments:
+			arguments[event.ToolIndex] += event.Text
+		case provider.EventUsage:
+			usage = event.Usage
+		}
+	}
+	if names[0] != "call_1:get_weather" || names[1] != "call_2:get_time" || len(names) != 2 {
+		t.Fatalf("tool calls = %v", names)
+	}
+	if arguments[0] != `{"city":"Paris"}` || arguments[1] != `{}` {
+		t.Fatalf("arguments = %v", arguments)
+	}
+	if reasons := finishReasons(events); len(reasons) != 1 || reasons[0] != provider.FinishReasonToolCalls {
+		t.Fatalf("finish reasons = %v", reasons)
+	}
+	if usage == nil || *usage != (provider.Usage{PromptTokens: 20, CompletionTokens: 8, CachedTokens: 4, ReasoningTokens: 2}) {
+		t.Fatalf("usage = %+v", usage)
+	}
+}
+
+func TestStreamParserTruncated(t *testing.T) {
+	events, done := parseStream(t, []string{
+		`{"choices":[{"index":0,"delta":{"role":"assistant","content":"partial"},"finish_reason":null}]}`,
+		`{"choices":[{"index":0,"delta":{"reasoning":"thinking"},"finish_reason":null}]}`,
+	})
+	if done {
+		t.Fatal("truncated stream reported done")
+	}
+	if reasons := finishReasons(events); len(reasons) != 0 {
+		t.Fatalf("finish reasons = %v, want none", reasons)
+	}
+}
+
+func TestStreamParserError(t *testing.T) {
+	parser := (&Provider{}).NewStreamParser()
+	_, done, err := parser.Parse(cycletls.SSEEvent{
+		Data: `{"id":"gen-1","error":{"code":502,"message":"Provider returned error"}}`,
+	})
+	if !done {
+		t.Fatal("error chunk did not end the stream")
+	}
+	if _, ok := err.(*provider.UpstreamError); !ok {
+		t.Fatalf("err = %v, want upstream error", err)
+	}
+}
@@ -0,0 +1,133 @@
+package provider
+
+import (
+	"kilo2api/common"
+	"kilo2api/cycletls"
+	"kilo2api/model"
+	"sync"
+)
+
+// KiloBaseURL kilocode上游地址
+const KiloBaseURL = "https://kilocode.ai"
+
+// Provider 上游提供方,负责构造请求、提供请求地址与请求头、解析流式响应以及错误分类。
+// 新的上游格式以独立包实现该接口,并在init中调用Register注册。
+type Provider interface {
+	// Name 提供方名称,与ModelInfo.Source对应
+	Name() string
+	// BuildRequest 将OpenAI对话请求转换为上游请求体
+	BuildRequest(openAIReq model.OpenAIChatCompletionRequest, modelInfo common.ModelInfo) ([]byte, error)
+	// Endpoint 上游请求地址
+	Endpoint() string
+	// Headers 上游请求头
+	Headers(token string) map[string]string
+	// NewStreamParser 创建流式响应解析器,每个请求使用独立的解析器
+	NewStreamParser() StreamParser
+	// ClassifyError 根据状态码、响应头与响应体对上游错误分类
+	ClassifyError(status int, headers map[string]string, body string) *UpstreamError
+	// SupportedParams 上游支持的采样参数(top_p、stop等),其余参数会被忽略
+	SupportedParams() []string
+}
+
+// ClaudeNativeProvider 原生支持Anthropic Messages协议的提供方,/v1/messages 可直接透传
+type ClaudeNativeProvider interface {
+	Provider
+	// BuildClaudeRequest 将Anthropic Messages请求转换为上游请求体
+	BuildClaudeRequest(claudeReq model.ClaudeMessagesRequest, modelInfo common.ModelInfo) ([]byte, error)
+}
+
+// ResponseFormatEmulator 不支持response_format的提供方,通过强制工具调用模拟结构化输出,
+// 该工具的参数即为JSON输出
+type ResponseFormatEmulator interface {
+	Provider
+	// ResponseFormatTool 返回模拟结构化输出时强制调用的工具名称
+	ResponseFormatTool() string
+}
+
+// MessageValidator 对消息顺序或参数范围有额外约束的提供方,在请求发往上游前校验
+type MessageValidator interface {
+	Provider
+	// ValidateMessages 校验对话请求,返回的错误param指向具体字段
+	ValidateMessages(openAIReq model.OpenAIChatCompletionRequest, modelInfo common.ModelInfo) *model.OpenAIError
+}
+
+// StreamParser 将上游流式数据解析为归一化事件
+type StreamParser interface {
+	// Parse 解析一条上游SSE事件,done为true表示上游响应已结束
+	Parse(sseEvent cycletls.SSEEvent) (events []Event, done bool, err error)
+}
+
+// EventType 归一化事件类型
+type EventType int
+
+const (
+	// EventText 文本增量
+	EventText EventType = iota
+	// EventThinking 思考过程增量
+	EventThinking
+	// EventThinkingSignature 思考块签名,多轮对话回传思考内容时需原样携带
+	EventThinkingSignature
+	// EventToolCallStart 工具调用开始,携带ID与名称
+	EventToolCallStart
+	// EventToolCallArguments 工具调用参数增量
+	EventToolCallArguments
+	// EventUsage 上游报告的用量,为截至当前的累计值
+	EventUsage
+	// EventFinish 内容生成结束,之后仍可能有EventUsage
+	EventFinish
+)
+
+// OpenAI格式的结束原因
+const (
+	FinishReasonStop          = "stop"
+	FinishReasonLength        = "length"
+	FinishReasonToolCalls     = "tool_calls"
+	FinishReasonContentFilter = "content_filter"
+)
+
+// Event 归一化的流式事件
+type Event struct {
+	Type EventType
+	// Text 文本/思考/工具参数增量或思考签名
+	Text string
+	// ToolIndex 工具调用在本次响应中的序号,从0开始
+	ToolIndex  int
+	ToolCallID string
+	ToolName   string
+	// FinishReason OpenAI格式的结束原因,上游未提供时为空
+	FinishReason string
+	Usage        *Usage
+}
+
+// Usage 上游报告的token用量
+type Usage struct {
+	// PromptTokens 输入token总数,包含缓存命中与缓存写入部分
+	PromptTokens     int
+	CompletionTokens int
+	// CachedTokens 命中缓存的输入token
+	CachedTokens int
+	// CacheCreationTokens 写入缓存的输入token
+	CacheCreationTokens int
+	// ReasoningTokens 输出中用于推理的token
+	ReasoningTokens int
+}
+
+var (
+	providers   = make(map[string]Provider)
+	providersMu sync.RWMutex
+)
+
+// Register 注册提供方,重复注册同名提供方时覆盖
+func Register(p Provider) {
+	providersMu.Lock()
+	defer providersMu.Unlock()
+	providers[p.Name()] = p
+}
+
+// Get 按名称获取提供方
+func Get(name string) (Provider, bool) {
+	providersMu.RLock()
+	defer providersMu.RUnlock()
+	p, ok := providers[name]
+	return p, ok
+}
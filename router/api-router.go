@@ -30,9 +30,28 @@ func SetApiRouter(router *gin.Engine) {
 	v1Router := router.Group(fmt.Sprintf("%s/v1", ProcessPath(config.RoutePrefix)))
 	v1Router.Use(middleware.OpenAIAuth())
 	v1Router.POST("/chat/completions", controller.ChatForOpenAI)
+	v1Router.POST("/completions", controller.CompletionsForOpenAI)
+	v1Router.POST("/messages", controller.MessagesForClaude)
+	v1Router.POST("/messages/count_tokens", controller.CountTokensForClaude)
+	v1Router.POST("/tokenize", controller.TokenizeForOpenAI)
+	v1Router.POST("/responses", controller.ResponsesForOpenAI)
 	//v1Router.POST("/images/generations", controller.ImagesForOpenAI)
 	v1Router.GET("/models", controller.OpenaiModels)
 
+	v1betaRouter := router.Group(fmt.Sprintf("%s/v1beta", ProcessPath(config.RoutePrefix)))
+	v1betaRouter.Use(middleware.OpenAIAuth())
+	// /v1beta/models/{model}:generateContent、/v1beta/models/{model}:streamGenerateContent
+	v1betaRouter.POST("/models/*action", controller.GenerateContentForGemini)
+
+	// Ollama兼容接口
+	ollamaRouter := router.Group(fmt.Sprintf("%s/api", ProcessPath(config.RoutePrefix)))
+	ollamaRouter.Use(middleware.OpenAIAuth())
+	ollamaRouter.POST("/chat", controller.ChatForOllama)
+	ollamaRouter.POST("/generate", controller.GenerateForOllama)
+	ollamaRouter.GET("/tags", controller.TagsForOllama)
+	ollamaRouter.POST("/show", controller.ShowForOllama)
+	ollamaRouter.GET("/version", controller.VersionForOllama)
+
 }
 
 func ProcessPath(path string) string {